@@ -0,0 +1,63 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreos/butane/config/common"
+)
+
+// ResolveCommand returns the absolute path of the executable which
+// RunCommand would run for command in directory dir (or the current
+// directory if dir is empty).  Commands without a slash are looked up in
+// PATH; others are relative to dir.
+func ResolveCommand(command, dir string) (string, error) {
+	if strings.Contains(command, "/") && !filepath.IsAbs(command) && dir != "" {
+		command = filepath.Join(dir, command)
+	}
+	resolved, err := exec.LookPath(command)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(resolved)
+}
+
+// RunCommand runs command with args, without a shell, in directory dir
+// (or the current directory if dir is empty) and returns its stdout.  A
+// non-zero exit status is an error which includes the command's stderr.
+// The command is killed if ctx expires.
+func RunCommand(ctx context.Context, command string, args []string, dir string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%s: %w", command, common.ErrTimeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %w: %s", command, err, msg)
+		}
+		return nil, fmt.Errorf("%s: %w", command, err)
+	}
+	return out, nil
+}
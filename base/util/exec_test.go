@@ -0,0 +1,74 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunCommand(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("contents\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// arguments are passed without a shell, relative to dir
+	out, err := RunCommand(context.Background(), "cat", []string{"file"}, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "contents\n", string(out))
+	out, err = RunCommand(context.Background(), "echo", []string{"$HOME", "a;b"}, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "$HOME a;b\n", string(out))
+
+	// non-zero exit includes stderr
+	_, err = RunCommand(context.Background(), "cat", []string{"missing"}, dir)
+	assert.ErrorContains(t, err, "cat: exit status 1: cat: missing: No such file or directory")
+}
+
+func TestResolveCommand(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "echo"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// paths with a slash are relative to dir
+	resolved, err := ResolveCommand("./echo", dir)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "echo"), resolved)
+
+	// bare names are looked up in PATH
+	resolved, err = ResolveCommand("echo", dir)
+	assert.NoError(t, err)
+	assert.True(t, filepath.IsAbs(resolved))
+	assert.NotEqual(t, filepath.Join(dir, "echo"), resolved)
+
+	_, err = ResolveCommand("./missing", dir)
+	assert.Error(t, err)
+}
+
+func TestRunCommandTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := RunCommand(ctx, "sleep", []string{"10"}, "")
+	assert.ErrorIs(t, err, common.ErrTimeout)
+}
@@ -15,7 +15,10 @@
 package util
 
 import (
+	"context"
+	"io/fs"
 	"os"
+	slashpath "path"
 	"path/filepath"
 	"strings"
 
@@ -50,6 +53,176 @@ func ReadLocalFile(configPath, filesDir string) ([]byte, error) {
 	return os.ReadFile(filePath)
 }
 
+// ReadLinkFS is a filesystem which can also read the targets of symlinks.
+type ReadLinkFS interface {
+	fs.FS
+	ReadLink(name string) (string, error)
+}
+
+// dirFS is the filesystem of a local directory.  Unlike os.DirFS, errors
+// report the full path of the file on disk.
+type dirFS string
+
+func (d dirFS) path(name string) string {
+	return filepath.Join(string(d), filepath.FromSlash(name))
+}
+
+func (d dirFS) Open(name string) (fs.File, error) {
+	return os.Open(d.path(name))
+}
+
+func (d dirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(d.path(name))
+}
+
+func (d dirFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(d.path(name))
+}
+
+func (d dirFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(d.path(name))
+}
+
+func (d dirFS) ReadLink(name string) (string, error) {
+	target, err := os.Readlink(d.path(name))
+	return filepath.ToSlash(target), err
+}
+
+// contextFS is a filesystem whose operations fail with common.ErrTimeout
+// once ctx expires.  Blocking file IO can't be cancelled, so operations
+// still running at that point are abandoned and finish in the
+// background.
+type contextFS struct {
+	fsys fs.FS
+	ctx  context.Context
+}
+
+// run calls f, returning early if ctx expires first.
+func (c contextFS) run(f func() (interface{}, error)) (interface{}, error) {
+	if c.ctx.Err() != nil {
+		return nil, common.ErrTimeout
+	}
+	type result struct {
+		value interface{}
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := f()
+		done <- result{value, err}
+	}()
+	select {
+	case res := <-done:
+		return res.value, res.err
+	case <-c.ctx.Done():
+		return nil, common.ErrTimeout
+	}
+}
+
+func (c contextFS) Open(name string) (fs.File, error) {
+	f, err := c.run(func() (interface{}, error) {
+		return c.fsys.Open(name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f.(fs.File), nil
+}
+
+func (c contextFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := c.run(func() (interface{}, error) {
+		return fs.ReadDir(c.fsys, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries.([]fs.DirEntry), nil
+}
+
+func (c contextFS) ReadFile(name string) ([]byte, error) {
+	contents, err := c.run(func() (interface{}, error) {
+		return fs.ReadFile(c.fsys, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return contents.([]byte), nil
+}
+
+func (c contextFS) Stat(name string) (fs.FileInfo, error) {
+	info, err := c.run(func() (interface{}, error) {
+		return fs.Stat(c.fsys, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info.(fs.FileInfo), nil
+}
+
+func (c contextFS) ReadLink(name string) (string, error) {
+	target, err := c.run(func() (interface{}, error) {
+		return ReadLink(c.fsys, name)
+	})
+	if err != nil {
+		return "", err
+	}
+	return target.(string), nil
+}
+
+// LocalFS returns the filesystem that local paths in the config are
+// relative to: options.FilesFS if specified, or else the directory
+// options.FilesDir.  If the options have a Timeout, operations on the
+// filesystem fail once it expires.
+func LocalFS(options common.TranslateOptions) (fs.FS, error) {
+	var fsys fs.FS
+	if options.FilesFS != nil {
+		fsys = options.FilesFS
+	} else if options.FilesDir == "" {
+		// a files dir isn't configured; refuse to read anything
+		return nil, common.ErrNoFilesDir
+	} else {
+		fsys = dirFS(options.FilesDir)
+	}
+	if ctx := options.Context(); ctx.Done() != nil {
+		fsys = contextFS{fsys, ctx}
+	}
+	return fsys, nil
+}
+
+// LocalFSPath converts a local path from the config into a path within
+// the LocalFS, failing if it would traverse outside the filesystem.
+func LocalFSPath(configPath string) (string, error) {
+	name := strings.TrimLeft(slashpath.Clean(configPath), "/")
+	if name == "" {
+		name = "."
+	}
+	if name == ".." || strings.HasPrefix(name, "../") || !fs.ValidPath(name) {
+		return "", common.ErrFilesDirEscape
+	}
+	return name, nil
+}
+
+// ReadLocalFSFile is like ReadLocalFile, but reads from the LocalFS.
+func ReadLocalFSFile(configPath string, options common.TranslateOptions) ([]byte, error) {
+	fsys, err := LocalFS(options)
+	if err != nil {
+		return nil, err
+	}
+	name, err := LocalFSPath(configPath)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadFile(fsys, name)
+}
+
+// ReadLink returns the slash-separated target of the symlink name in fsys.
+func ReadLink(fsys fs.FS, name string) (string, error) {
+	if linkFS, ok := fsys.(ReadLinkFS); ok {
+		return linkFS.ReadLink(name)
+	}
+	return "", common.ErrSymlinkUnsupported
+}
+
 // CheckForDecimalMode fails if the specified mode appears to have been
 // incorrectly specified in decimal instead of octal.
 func CheckForDecimalMode(mode int, directory bool) error {
@@ -16,8 +16,13 @@ package util
 
 import (
 	"fmt"
+	"io/fs"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/coreos/butane/config/common"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -126,3 +131,63 @@ func TestCheckForDecimalMode(t *testing.T) {
 	assert.Equal(t, expectedBadDirModes, badDirModes, "bad set of decimal directory modes")
 	assert.Equal(t, expectedBadFileModes, badFileModes, "bad set of decimal file modes")
 }
+
+func TestLocalFSPath(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+		err error
+	}{
+		{"file", "file", nil},
+		{"dir/file", "dir/file", nil},
+		{"/dir//file/", "dir/file", nil},
+		{"dir/../file", "file", nil},
+		{"", ".", nil},
+		{"/", ".", nil},
+		{"..", "", common.ErrFilesDirEscape},
+		{"../file", "", common.ErrFilesDirEscape},
+		{"dir/../../file", "", common.ErrFilesDirEscape},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("path %d", i), func(t *testing.T) {
+			out, err := LocalFSPath(test.in)
+			assert.Equal(t, test.err, err, "bad error")
+			assert.Equal(t, test.out, out, "bad path")
+		})
+	}
+}
+
+// hangingFS is a filesystem whose reads of "hang" never complete.
+type hangingFS struct {
+	fstest.MapFS
+}
+
+func (h hangingFS) ReadFile(name string) ([]byte, error) {
+	if name == "hang" {
+		select {}
+	}
+	return h.MapFS.ReadFile(name)
+}
+
+func TestLocalFSTimeout(t *testing.T) {
+	options := common.TranslateOptions{
+		FilesFS: hangingFS{fstest.MapFS{
+			"file": &fstest.MapFile{Data: []byte("contents")},
+		}},
+		Timeout: 100 * time.Millisecond,
+	}
+	options, cancel := options.StartTimeout()
+	defer cancel()
+
+	contents, err := ReadLocalFSFile("file", options)
+	assert.NoError(t, err, "reading file")
+	assert.Equal(t, "contents", string(contents), "bad contents")
+	_, err = ReadLocalFSFile("hang", options)
+	assert.Equal(t, common.ErrTimeout, err, "bad error for hung read")
+	// later operations fail immediately
+	fsys, err := LocalFS(options)
+	assert.NoError(t, err, "getting filesystem")
+	_, err = fs.Stat(fsys, "file")
+	assert.Equal(t, common.ErrTimeout, err, "bad error after timeout")
+}
@@ -0,0 +1,66 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/coreos/butane/config/common"
+)
+
+// ReadGitFile returns the contents of the file at repoPath in the git
+// repository at url, as of ref.  Only the commit named by ref is fetched,
+// into a temporary repository which is removed afterward.  Git is killed
+// if ctx expires.
+func ReadGitFile(ctx context.Context, url, ref, repoPath string) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "butane-git-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := runGit(ctx, dir, "init", "--quiet", "--bare"); err != nil {
+		return nil, err
+	}
+	if _, err := runGit(ctx, dir, "fetch", "--quiet", "--depth=1", "--no-tags", "--", url, ref); err != nil {
+		return nil, err
+	}
+	return runGit(ctx, dir, "cat-file", "blob", "FETCH_HEAD:"+repoPath)
+}
+
+func runGit(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	// fail rather than prompting for credentials
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("git %s: %w", args[0], common.ErrTimeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %w: %s", args[0], err, msg)
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return out, nil
+}
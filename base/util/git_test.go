@@ -0,0 +1,107 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadGitFile(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	write := func(name, contents string) {
+		if err := os.MkdirAll(filepath.Join(repo, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(repo, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "--quiet")
+	write("dir/file", "old contents\n")
+	git("add", ".")
+	git("commit", "--quiet", "-m", "first")
+	git("tag", "v1")
+	write("dir/file", "new contents\n")
+	git("commit", "--quiet", "-a", "-m", "second")
+
+	url := "file://" + filepath.ToSlash(repo)
+	tests := []struct {
+		ref  string
+		path string
+		out  string
+		fail bool
+	}{
+		// current commit
+		{
+			ref:  "HEAD",
+			path: "dir/file",
+			out:  "new contents\n",
+		},
+		// tag
+		{
+			ref:  "v1",
+			path: "dir/file",
+			out:  "old contents\n",
+		},
+		// missing ref
+		{
+			ref:  "missing",
+			path: "dir/file",
+			fail: true,
+		},
+		// missing file
+		{
+			ref:  "HEAD",
+			path: "dir/missing",
+			fail: true,
+		},
+		// directory
+		{
+			ref:  "HEAD",
+			path: "dir",
+			fail: true,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("read %d", i), func(t *testing.T) {
+			out, err := ReadGitFile(context.Background(), url, test.ref, test.path)
+			if test.fail {
+				assert.Error(t, err, "expected failure")
+				return
+			}
+			assert.NoError(t, err, "reading file")
+			assert.Equal(t, test.out, string(out), "bad contents")
+		})
+	}
+}
@@ -0,0 +1,430 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/coreos/butane/config/common"
+)
+
+const (
+	// manifest types accepted from the registry; OCI artifacts are
+	// usually pushed as image manifests
+	ociManifestAccept = "application/vnd.oci.image.manifest.v1+json, application/vnd.docker.distribution.manifest.v2+json"
+	// bound on the size of a manifest, which the registry doesn't
+	// describe in advance
+	ociMaxManifestSize = 4 << 20
+)
+
+var (
+	ociDigestRe = regexp.MustCompile(`^(sha256:[0-9a-f]{64}|sha512:[0-9a-f]{128})$`)
+	ociTagRe    = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+	ociRepoRe   = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+)
+
+// ociReference is a parsed OCI artifact reference.
+type ociReference struct {
+	registry   string
+	repository string
+	reference  string // tag or manifest digest
+}
+
+type ociManifest struct {
+	Layers []ociDescriptor `json:"layers"`
+}
+
+type ociDescriptor struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+// ValidOCIDigest returns true if digest is a sha256 or sha512 digest in
+// the OCI form, such as sha256:<64 hex digits>.
+func ValidOCIDigest(digest string) bool {
+	return ociDigestRe.MatchString(digest)
+}
+
+// ReadOCIBlob returns the contents of a layer of the OCI artifact named by
+// ref, which has the form registry/repository:tag or
+// registry/repository@digest.  The tag defaults to latest.  If digest is
+// set, it selects the layer; otherwise the artifact must have exactly one
+// layer.  The manifest, if named by digest, and the layer are checked
+// against their digests.  Credentials are read from the auths entries of
+// the podman and docker auth files; credential helpers aren't run, and
+// credentials are only sent to token servers over HTTPS.  Registries and
+// token servers on loopback addresses are accessed over plain HTTP.  If
+// maxSize isn't negative, at most maxSize bytes of the layer are read,
+// whatever size the manifest gives.
+func ReadOCIBlob(ctx context.Context, ref, digest string, maxSize int64) ([]byte, error) {
+	parsed, err := parseOCIReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	client := newOCIClient(ctx, parsed)
+
+	manifestBytes, err := client.get("manifests/"+parsed.reference, ociManifestAccept, ociMaxManifestSize)
+	if err != nil {
+		return nil, err
+	}
+	if ValidOCIDigest(parsed.reference) {
+		if err := checkOCIDigest(manifestBytes, parsed.reference); err != nil {
+			return nil, fmt.Errorf("manifest: %w", err)
+		}
+	}
+	var manifest ociManifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return nil, fmt.Errorf("%w: parsing manifest: %v", common.ErrOCIFetch, err)
+	}
+	var layer *ociDescriptor
+	for i := range manifest.Layers {
+		if digest == "" || manifest.Layers[i].Digest == digest {
+			if layer != nil {
+				return nil, common.ErrOCILayerAmbiguous
+			}
+			layer = &manifest.Layers[i]
+		}
+	}
+	if layer == nil {
+		if digest == "" {
+			return nil, common.ErrOCINoLayers
+		}
+		return nil, fmt.Errorf("%w: %s", common.ErrOCILayerNotFound, digest)
+	}
+	if !ValidOCIDigest(layer.Digest) {
+		return nil, fmt.Errorf("%w: unsupported layer digest %q", common.ErrOCIFetch, layer.Digest)
+	}
+
+	if maxSize >= 0 && layer.Size > maxSize {
+		return nil, fmt.Errorf("%w: layer is %d bytes", common.ErrEmbeddedSizeExceeded, layer.Size)
+	}
+	// the manifest's size can't be trusted to bound the read
+	limit := layer.Size
+	if maxSize >= 0 {
+		limit = maxSize
+	}
+	contents, err := client.get("blobs/"+layer.Digest, "", limit)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(contents)) != layer.Size {
+		return nil, fmt.Errorf("%w: expected %d bytes, found %d", common.ErrOCIDigestMismatch, layer.Size, len(contents))
+	}
+	if err := checkOCIDigest(contents, layer.Digest); err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+func parseOCIReference(ref string) (ociReference, error) {
+	var ret ociReference
+	registry, rest, ok := strings.Cut(ref, "/")
+	// the registry must be explicit; a first component without a
+	// dot, colon, or localhost is a repository on an implied registry
+	if !ok || (!strings.ContainsAny(registry, ".:") && registry != "localhost") {
+		return ret, fmt.Errorf("%w: %q", common.ErrOCIRefInvalid, ref)
+	}
+	ret.registry = registry
+	if repo, d, ok := strings.Cut(rest, "@"); ok {
+		if !ValidOCIDigest(d) {
+			return ret, fmt.Errorf("%w: %q", common.ErrOCIRefInvalid, ref)
+		}
+		ret.repository = repo
+		ret.reference = d
+	} else if i := strings.LastIndex(rest, ":"); i >= 0 {
+		ret.repository = rest[:i]
+		ret.reference = rest[i+1:]
+	} else {
+		ret.repository = rest
+		ret.reference = "latest"
+	}
+	if !ociRepoRe.MatchString(ret.repository) || (!ValidOCIDigest(ret.reference) && !ociTagRe.MatchString(ret.reference)) {
+		return ret, fmt.Errorf("%w: %q", common.ErrOCIRefInvalid, ref)
+	}
+	return ret, nil
+}
+
+func checkOCIDigest(contents []byte, digest string) error {
+	algorithm, expected, _ := strings.Cut(digest, ":")
+	var h hash.Hash
+	switch algorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return fmt.Errorf("%w: unsupported digest %q", common.ErrOCIFetch, digest)
+	}
+	h.Write(contents)
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != expected {
+		return fmt.Errorf("%w: expected %s, found %s:%s", common.ErrOCIDigestMismatch, digest, algorithm, actual)
+	}
+	return nil
+}
+
+// ociClient fetches from one repository, authenticating as the registry
+// requests.
+type ociClient struct {
+	ctx      context.Context
+	base     string // URL of the repository in the distribution API
+	registry string
+	username string
+	password string
+	token    string // bearer token, once obtained
+	basic    bool   // whether the registry asked for basic auth
+}
+
+func newOCIClient(ctx context.Context, ref ociReference) *ociClient {
+	scheme := "https"
+	if isLoopbackHost(ref.registry) {
+		scheme = "http"
+	}
+	c := ociClient{
+		ctx:      ctx,
+		base:     fmt.Sprintf("%s://%s/v2/%s/", scheme, ref.registry, ref.repository),
+		registry: ref.registry,
+	}
+	c.username, c.password = ociCredentials(ref.registry, ref.repository)
+	return &c
+}
+
+// get returns the body of the resource at p, relative to the repository,
+// failing if it's larger than limit bytes.
+func (c *ociClient) get(p, accept string, limit int64) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, c.base+p, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", common.ErrOCIFetch, err)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		} else if c.basic && c.username != "" {
+			req.SetBasicAuth(c.username, c.password)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return nil, fmt.Errorf("%s: %w", c.registry, common.ErrTimeout)
+			}
+			return nil, fmt.Errorf("%w: %v", common.ErrOCIFetch, err)
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			if err := c.authenticate(resp.Header.Get("WWW-Authenticate")); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%w: %s: %s", common.ErrOCIFetch, req.URL.Redacted(), resp.Status)
+		}
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return nil, fmt.Errorf("%s: %w", c.registry, common.ErrTimeout)
+			}
+			return nil, fmt.Errorf("%w: %v", common.ErrOCIFetch, err)
+		}
+		if int64(len(body)) > limit {
+			return nil, fmt.Errorf("%w: %s: response is larger than %d bytes", common.ErrOCIFetch, req.URL.Redacted(), limit)
+		}
+		return body, nil
+	}
+}
+
+// authenticate responds to an authentication challenge from the
+// registry, either by using basic auth for later requests or by
+// fetching a bearer token.
+func (c *ociClient) authenticate(challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.username == "" {
+			return fmt.Errorf("%w: %s: no credentials found", common.ErrOCIFetch, c.registry)
+		}
+		c.basic = true
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("%w: %s: unsupported authentication challenge %q", common.ErrOCIFetch, c.registry, challenge)
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Scheme == "" {
+		return fmt.Errorf("%w: %s: invalid token realm", common.ErrOCIFetch, c.registry)
+	}
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	if scope := params["scope"]; scope != "" {
+		query.Set("scope", scope)
+	}
+	realm.RawQuery = query.Encode()
+	// don't send credentials where they could be intercepted
+	if c.username != "" && realm.Scheme != "https" && !isLoopbackHost(realm.Host) {
+		return fmt.Errorf("%w: %s: token realm %s doesn't use https", common.ErrOCIFetch, c.registry, realm.Redacted())
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", common.ErrOCIFetch, err)
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if c.ctx.Err() != nil {
+			return fmt.Errorf("%s: %w", c.registry, common.ErrTimeout)
+		}
+		return fmt.Errorf("%w: %v", common.ErrOCIFetch, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s: fetching token: %s", common.ErrOCIFetch, c.registry, resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, ociMaxManifestSize)).Decode(&token); err != nil {
+		return fmt.Errorf("%w: %s: parsing token: %v", common.ErrOCIFetch, c.registry, err)
+	}
+	c.token = token.Token
+	if c.token == "" {
+		c.token = token.AccessToken
+	}
+	if c.token == "" {
+		return fmt.Errorf("%w: %s: empty token", common.ErrOCIFetch, c.registry)
+	}
+	return nil
+}
+
+// parseChallenge parses a WWW-Authenticate header with a single
+// challenge, such as Bearer realm="https://auth.example.com/token",
+// service="registry.example.com".
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := make(map[string]string)
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		if key = strings.ToLower(strings.TrimSpace(key)); key != "" {
+			params[key] = value
+		}
+	}
+	return scheme, params
+}
+
+// ociCredentials returns the username and password for repository on
+// registry from the first auth file with a matching entry.  Entries for
+// a repository or its namespaces take precedence over the registry entry
+// in the same file.
+func ociCredentials(registry, repository string) (string, string) {
+	var files []string
+	if f := os.Getenv("REGISTRY_AUTH_FILE"); f != "" {
+		files = append(files, f)
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		files = append(files, filepath.Join(dir, "containers", "auth.json"))
+	}
+	home, _ := os.UserHomeDir()
+	if home != "" {
+		files = append(files, filepath.Join(home, ".config", "containers", "auth.json"))
+	}
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		files = append(files, filepath.Join(dir, "config.json"))
+	} else if home != "" {
+		files = append(files, filepath.Join(home, ".docker", "config.json"))
+	}
+
+	var keys []string
+	scope := registry + "/" + repository
+	for {
+		keys = append(keys, scope)
+		i := strings.LastIndex(scope, "/")
+		if i < 0 {
+			break
+		}
+		scope = scope[:i]
+	}
+	keys = append(keys, "https://"+registry, "http://"+registry)
+
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		var config struct {
+			Auths map[string]struct {
+				Auth string `json:"auth"`
+			} `json:"auths"`
+		}
+		if json.Unmarshal(data, &config) != nil {
+			continue
+		}
+		for _, key := range keys {
+			entry, ok := config.Auths[key]
+			if !ok || entry.Auth == "" {
+				continue
+			}
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				continue
+			}
+			if username, password, ok := strings.Cut(string(decoded), ":"); ok {
+				return username, password
+			}
+		}
+	}
+	return "", ""
+}
+
+// isLoopbackHost returns true if the host of hostport is localhost or a
+// loopback address.
+func isLoopbackHost(hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
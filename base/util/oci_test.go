@@ -0,0 +1,245 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ociTestDigest(contents string) string {
+	sum := sha256.Sum256([]byte(contents))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// TestReadOCIBlob checks fetching layers from a registry which requires
+// a bearer token obtained with credentials from an auth file.
+func TestReadOCIBlob(t *testing.T) {
+	blobs := map[string]string{}
+	manifests := map[string]string{}
+	addBlob := func(contents string) string {
+		digest := ociTestDigest(contents)
+		blobs[digest] = contents
+		return digest
+	}
+	addManifest := func(tag string, layers ...string) string {
+		var descriptors []string
+		for _, layer := range layers {
+			descriptors = append(descriptors, fmt.Sprintf(`{"mediaType":"application/octet-stream","digest":"%s","size":%d}`, ociTestDigest(layer), len(layer)))
+		}
+		manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[%s]}`, strings.Join(descriptors, ","))
+		manifests[tag] = manifest
+		manifests[ociTestDigest(manifest)] = manifest
+		return ociTestDigest(manifest)
+	}
+
+	single := addBlob("single layer\n")
+	first := addBlob("first\n")
+	second := addBlob("second\n")
+	singleManifest := addManifest("single", "single layer\n")
+	addManifest("multi", "first\n", "second\n")
+	addManifest("empty")
+	// manifest whose layer is served with the wrong contents
+	addManifest("corrupt", "expected\n")
+	blobs[ociTestDigest("expected\n")] = "unexpect\n"
+	// manifest which understates the size of its layer
+	manifests["understated"] = fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[{"mediaType":"application/octet-stream","digest":"%s","size":1}]}`, single)
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/token" {
+			username, password, ok := req.BasicAuth()
+			if !ok || username != "user" || password != "pass" || req.URL.Query().Get("scope") != "repository:configs/app:pull" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"token":"secret"}`)
+			return
+		}
+		if req.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:configs/app:pull"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if strings.HasPrefix(req.URL.Path, "/v2/configs/app/manifests/") {
+			if manifest, ok := manifests[strings.TrimPrefix(req.URL.Path, "/v2/configs/app/manifests/")]; ok {
+				w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+				fmt.Fprint(w, manifest)
+				return
+			}
+		}
+		if strings.HasPrefix(req.URL.Path, "/v2/configs/app/blobs/") {
+			if blob, ok := blobs[strings.TrimPrefix(req.URL.Path, "/v2/configs/app/blobs/")]; ok {
+				fmt.Fprint(w, blob)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	authFile := filepath.Join(t.TempDir(), "auth.json")
+	auth := base64.StdEncoding.EncodeToString([]byte("user:pass"))
+	if err := os.WriteFile(authFile, []byte(fmt.Sprintf(`{"auths":{"%s":{"auth":"%s"}}}`, registry, auth)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("REGISTRY_AUTH_FILE", authFile)
+
+	tests := []struct {
+		ref     string
+		digest  string
+		maxSize int64 // 0 for no limit
+		out     string
+		err     error
+	}{
+		// single layer by tag
+		{
+			ref: registry + "/configs/app:single",
+			out: "single layer\n",
+		},
+		// single layer by manifest digest, with layer digest
+		{
+			ref:    registry + "/configs/app@" + singleManifest,
+			digest: single,
+			out:    "single layer\n",
+		},
+		// layer selected by digest
+		{
+			ref:    registry + "/configs/app:multi",
+			digest: second,
+			out:    "second\n",
+		},
+		{
+			ref:    registry + "/configs/app:multi",
+			digest: first,
+			out:    "first\n",
+		},
+		// several layers without digest
+		{
+			ref: registry + "/configs/app:multi",
+			err: common.ErrOCILayerAmbiguous,
+		},
+		// no layers
+		{
+			ref: registry + "/configs/app:empty",
+			err: common.ErrOCINoLayers,
+		},
+		// digest not in artifact
+		{
+			ref:    registry + "/configs/app:single",
+			digest: first,
+			err:    common.ErrOCILayerNotFound,
+		},
+		// wrong contents
+		{
+			ref: registry + "/configs/app:corrupt",
+			err: common.ErrOCIDigestMismatch,
+		},
+		// within the size limit
+		{
+			ref:     registry + "/configs/app:single",
+			maxSize: 13,
+			out:     "single layer\n",
+		},
+		// larger than the size limit
+		{
+			ref:     registry + "/configs/app:single",
+			maxSize: 12,
+			err:     common.ErrEmbeddedSizeExceeded,
+		},
+		// the read is bounded by the limit, not the manifest
+		{
+			ref:     registry + "/configs/app:understated",
+			maxSize: 5,
+			err:     common.ErrOCIFetch,
+		},
+		// missing tag
+		{
+			ref: registry + "/configs/app:missing",
+			err: common.ErrOCIFetch,
+		},
+		// implied registry
+		{
+			ref: "configs/app:single",
+			err: common.ErrOCIRefInvalid,
+		},
+		// invalid manifest digest
+		{
+			ref: registry + "/configs/app@sha256:abc",
+			err: common.ErrOCIRefInvalid,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("read %d", i), func(t *testing.T) {
+			maxSize := test.maxSize
+			if maxSize == 0 {
+				maxSize = -1
+			}
+			out, err := ReadOCIBlob(context.Background(), test.ref, test.digest, maxSize)
+			if test.err != nil {
+				assert.True(t, errors.Is(err, test.err), "bad error: %v", err)
+				return
+			}
+			assert.NoError(t, err, "reading blob")
+			assert.Equal(t, test.out, string(out), "bad contents")
+		})
+	}
+
+	// without credentials, fetching a token fails
+	t.Setenv("REGISTRY_AUTH_FILE", filepath.Join(t.TempDir(), "missing.json"))
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("DOCKER_CONFIG", "")
+	_, err := ReadOCIBlob(context.Background(), registry+"/configs/app:single", "", -1)
+	assert.True(t, errors.Is(err, common.ErrOCIFetch), "bad error: %v", err)
+}
+
+// TestReadOCIBlobInsecureRealm checks that credentials aren't sent to a
+// remote token server over plain HTTP.
+func TestReadOCIBlobInsecureRealm(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="http://auth.example.com/token",service="test"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	authFile := filepath.Join(t.TempDir(), "auth.json")
+	auth := base64.StdEncoding.EncodeToString([]byte("user:pass"))
+	if err := os.WriteFile(authFile, []byte(fmt.Sprintf(`{"auths":{"%s":{"auth":"%s"}}}`, registry, auth)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("REGISTRY_AUTH_FILE", authFile)
+
+	_, err := ReadOCIBlob(context.Background(), registry+"/configs/app:single", "", -1)
+	assert.True(t, errors.Is(err, common.ErrOCIFetch), "bad error: %v", err)
+	assert.Contains(t, err.Error(), "doesn't use https", "bad error")
+}
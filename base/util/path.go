@@ -0,0 +1,46 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	slashpath "path"
+	"strings"
+)
+
+// PathWithin returns true if the slash-separated absolute path p is dir
+// or a descendant of dir.  Both paths are cleaned first.
+func PathWithin(p, dir string) bool {
+	p = slashpath.Clean(p)
+	dir = slashpath.Clean(dir)
+	if dir == "/" || p == dir {
+		return true
+	}
+	return strings.HasPrefix(p, dir+"/")
+}
+
+// InnermostMount returns the index of the longest mount point in
+// mountPoints containing path p, or -1 if none do.
+func InnermostMount(p string, mountPoints []string) int {
+	best := -1
+	for i, mountPoint := range mountPoints {
+		if !PathWithin(p, mountPoint) {
+			continue
+		}
+		if best == -1 || len(slashpath.Clean(mountPoint)) > len(slashpath.Clean(mountPoints[best])) {
+			best = i
+		}
+	}
+	return best
+}
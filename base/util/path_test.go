@@ -0,0 +1,65 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathWithin(t *testing.T) {
+	tests := []struct {
+		path string
+		dir  string
+		out  bool
+	}{
+		{"/var/lib/foo", "/var", true},
+		{"/var", "/var", true},
+		{"/var/", "/var", true},
+		{"/var/lib", "/var/lib/", true},
+		{"/etc/foo", "/", true},
+		{"/variable", "/var", false},
+		{"/var", "/var/lib", false},
+		{"/var/../etc/foo", "/var", false},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("within %d", i), func(t *testing.T) {
+			assert.Equal(t, test.out, PathWithin(test.path, test.dir))
+		})
+	}
+}
+
+func TestInnermostMount(t *testing.T) {
+	mountPoints := []string{"/var", "/var/lib/data/", "/srv"}
+	tests := []struct {
+		path string
+		out  int
+	}{
+		{"/var/log/foo", 0},
+		{"/var/lib/data/foo", 1},
+		{"/var/lib/data", 1},
+		{"/srv/foo", 2},
+		{"/etc/foo", -1},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("innermost %d", i), func(t *testing.T) {
+			assert.Equal(t, test.out, InnermostMount(test.path, mountPoints))
+		})
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/coreos/butane/config/common"
+)
+
+var (
+	// well-known secret formats, by description
+	secretPatterns = []struct {
+		desc string
+		re   *regexp.Regexp
+	}{
+		{"PEM private key", regexp.MustCompile(`-----BEGIN ([A-Z0-9]+ )*PRIVATE KEY-----`)},
+		{"AWS access key ID", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+		{"GitHub token", regexp.MustCompile(`\bgh[oprsu]_[A-Za-z0-9]{36,}\b`)},
+		{"Slack token", regexp.MustCompile(`\bxox[abeoprs]-[A-Za-z0-9-]{10,}`)},
+	}
+	// candidates for the entropy check
+	secretTokenRe = regexp.MustCompile(`[A-Za-z0-9+/=_-]{32,}`)
+	pemBeginRe    = regexp.MustCompile(`^-----BEGIN [A-Z0-9 ]+-----`)
+)
+
+// minimum Shannon entropy, in bits per character, of a token reported
+// as a possible secret.  Nearly all random base64 tokens of 32 characters
+// exceed it; hex digests can't exceed 4.
+const secretTokenEntropy = 4.2
+
+// FindSecrets returns descriptions of the likely plaintext secrets in
+// contents: well-known secret formats, matches for any of patterns, and
+// long high-entropy strings.  Each kind of finding is reported once, at
+// its first line.  Binary contents aren't scanned.  The secrets
+// themselves are never included in the descriptions.
+func FindSecrets(contents []byte, patterns []string) ([]string, error) {
+	var extra []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", common.ErrSecretPattern, err)
+		}
+		extra = append(extra, re)
+	}
+	if !utf8.Valid(contents) {
+		return nil, nil
+	}
+
+	var findings []string
+	found := make(map[string]bool)
+	add := func(desc string, line int) {
+		if !found[desc] {
+			found[desc] = true
+			findings = append(findings, fmt.Sprintf("%s on line %d", desc, line))
+		}
+	}
+	// certificates and public keys are long base64 blocks, but not
+	// secret
+	inPEM := false
+	for i, line := range strings.Split(string(contents), "\n") {
+		matched := false
+		for _, p := range secretPatterns {
+			if p.re.MatchString(line) {
+				add(p.desc, i+1)
+				matched = true
+			}
+		}
+		for j, re := range extra {
+			if re.MatchString(line) {
+				add(fmt.Sprintf("match for secret pattern %d", j+1), i+1)
+				matched = true
+			}
+		}
+		trimmed := strings.TrimSpace(line)
+		if pemBeginRe.MatchString(trimmed) {
+			inPEM = true
+			continue
+		} else if strings.HasPrefix(trimmed, "-----END ") {
+			inPEM = false
+			continue
+		}
+		// the entropy check would only repeat a finding on this line
+		if inPEM || matched {
+			continue
+		}
+		for _, token := range secretTokenRe.FindAllString(line, -1) {
+			// SSH public key blobs start with the length of the
+			// key type, encoding to AAAA
+			if !strings.HasPrefix(token, "AAAA") && shannonEntropy(token) >= secretTokenEntropy {
+				add("high-entropy string", i+1)
+			}
+		}
+	}
+	return findings, nil
+}
+
+// LooksLikePasswordHash returns true if hash could be a crypt(3) hash,
+// optionally prefixed with ! to lock the account, or a locked password
+// such as *.
+func LooksLikePasswordHash(hash string) bool {
+	hash = strings.TrimLeft(hash, "!")
+	switch {
+	case hash == "" || hash == "*":
+		return true
+	case strings.HasPrefix(hash, "$"):
+		return true
+	case len(hash) == 13:
+		// traditional DES crypt
+		return strings.Trim(hash, "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz") == ""
+	}
+	return false
+}
+
+func shannonEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, c := range s {
+		counts[c]++
+	}
+	var entropy float64
+	for _, count := range counts {
+		p := float64(count) / float64(len(s))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
@@ -18,12 +18,22 @@ import (
 	"bytes"
 	"compress/gzip"
 	"encoding/base64"
+	"fmt"
+	"io"
 	"net/url"
+	"strings"
+
+	"github.com/coreos/butane/config/common"
 
 	"github.com/coreos/ignition/v2/config/util"
 	"github.com/vincent-petithory/dataurl"
 )
 
+const (
+	// largest contents MakeReadableDataURL will encode
+	maxReadableDataURLContents = 4096
+)
+
 func MakeDataURL(contents []byte, currentCompression *string, allowCompression bool) (uri string, compression *string, err error) {
 	// try three different encodings, and select the smallest one
 
@@ -56,18 +66,11 @@ func MakeDataURL(contents []byte, currentCompression *string, allowCompression b
 	// We don't try base64-encoded URL-escaped because gzipped data is
 	// binary and URL escaping is unlikely to be efficient.
 	if util.NilOrEmpty(currentCompression) && allowCompression {
-		var buf bytes.Buffer
-		var compressor *gzip.Writer
-		if compressor, err = gzip.NewWriterLevel(&buf, gzip.BestCompression); err != nil {
-			return
-		}
-		if _, err = compressor.Write(contents); err != nil {
+		var compressed []byte
+		if compressed, err = gzipBytes(contents); err != nil {
 			return
 		}
-		if err = compressor.Close(); err != nil {
-			return
-		}
-		gz := ";base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+		gz := ";base64," + base64.StdEncoding.EncodeToString(compressed)
 		// Account for space needed by the compression value
 		if len(gz)+len("gzip") < len(opaque) {
 			opaque = gz
@@ -81,3 +84,88 @@ func MakeDataURL(contents []byte, currentCompression *string, allowCompression b
 	}).String()
 	return
 }
+
+// MakeGzipDataURL returns a base64-encoded data URL of the gzipped
+// contents, regardless of whether compression reduces their size.
+func MakeGzipDataURL(contents []byte) (string, error) {
+	compressed, err := gzipBytes(contents)
+	if err != nil {
+		return "", err
+	}
+	return (&url.URL{
+		Scheme: "data",
+		Opaque: ";base64," + base64.StdEncoding.EncodeToString(compressed),
+	}).String(), nil
+}
+
+// GunzipBytes returns the decompressed gzip data contents.
+func GunzipBytes(contents []byte) ([]byte, error) {
+	decompressor, err := gzip.NewReader(bytes.NewReader(contents))
+	if err != nil {
+		return nil, err
+	}
+	defer decompressor.Close()
+	return io.ReadAll(decompressor)
+}
+
+// VerifyDataURL decodes the data URL uri, decompressing it according to
+// compression, and returns an error if the result doesn't match contents.
+func VerifyDataURL(uri string, compression *string, contents []byte) error {
+	decoded, err := dataurl.DecodeString(uri)
+	if err != nil {
+		return fmt.Errorf("%w: %v", common.ErrCompressionVerify, err)
+	}
+	data := decoded.Data
+	if !util.NilOrEmpty(compression) {
+		if *compression != "gzip" {
+			return fmt.Errorf("%w: unknown compression %q", common.ErrCompressionVerify, *compression)
+		}
+		if data, err = GunzipBytes(data); err != nil {
+			return fmt.Errorf("%w: %v", common.ErrCompressionVerify, err)
+		}
+	}
+	if !bytes.Equal(data, contents) {
+		return common.ErrCompressionVerify
+	}
+	return nil
+}
+
+func gzipBytes(contents []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	compressor, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := compressor.Write(contents); err != nil {
+		return nil, err
+	}
+	if err := compressor.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MakeReadableDataURL returns a percent-encoded data URL of contents if
+// they're short enough and consist only of printable ASCII, tabs, and
+// newlines, so the URL remains legible.  Otherwise it returns false.
+func MakeReadableDataURL(contents []byte) (string, bool) {
+	if len(contents) > maxReadableDataURLContents {
+		return "", false
+	}
+	var escaped strings.Builder
+	for _, c := range contents {
+		if (c < 0x20 || c > 0x7e) && c != '\t' && c != '\n' {
+			return "", false
+		}
+		// escape only what data URL parsers reject
+		if c <= 0x20 || strings.IndexByte(`"#%<>[\]^`+"`"+`{|}`, c) != -1 {
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		} else {
+			escaped.WriteByte(c)
+		}
+	}
+	return (&url.URL{
+		Scheme: "data",
+		Opaque: "," + escaped.String(),
+	}).String(), true
+}
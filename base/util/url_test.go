@@ -0,0 +1,130 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/coreos/ignition/v2/config/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/vincent-petithory/dataurl"
+)
+
+func TestMakeReadableDataURL(t *testing.T) {
+	var allPrintable strings.Builder
+	for c := byte(0x20); c <= 0x7e; c++ {
+		allPrintable.WriteByte(c)
+	}
+
+	tests := []struct {
+		in  string
+		out string
+		ok  bool
+	}{
+		{
+			"",
+			"data:,",
+			true,
+		},
+		{
+			"[Service]\n\tExecStart=/bin/echo 100%\n",
+			"data:,%5BService%5D%0A%09ExecStart=/bin/echo%20100%25%0A",
+			true,
+		},
+		{
+			allPrintable.String(),
+			"",
+			true,
+		},
+		{
+			strings.Repeat("z", maxReadableDataURLContents),
+			"",
+			true,
+		},
+		{
+			strings.Repeat("z", maxReadableDataURLContents+1),
+			"",
+			false,
+		},
+		{
+			"caf\xc3\xa9",
+			"",
+			false,
+		},
+		{
+			"a\rb",
+			"",
+			false,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("readable %d", i), func(t *testing.T) {
+			actual, ok := MakeReadableDataURL([]byte(test.in))
+			assert.Equal(t, test.ok, ok, "bad ok")
+			if !ok {
+				return
+			}
+			if test.out != "" {
+				assert.Equal(t, test.out, actual, "bad URL")
+			}
+			// round-trip the way Ignition does
+			u, err := url.Parse(actual)
+			assert.NoError(t, err, "parsing URL")
+			decoded, err := dataurl.DecodeString(u.String())
+			assert.NoError(t, err, "decoding URL")
+			assert.Equal(t, test.in, string(decoded.Data), "contents changed")
+		})
+	}
+}
+
+func TestVerifyDataURL(t *testing.T) {
+	gzipped, err := MakeGzipDataURL([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		uri         string
+		compression *string
+		contents    string
+		ok          bool
+	}{
+		{"data:,hello", nil, "hello", true},
+		{"data:,hello", util.StrToPtr(""), "hello", true},
+		{"data:,hello", nil, "goodbye", false},
+		{gzipped, util.StrToPtr("gzip"), "hello", true},
+		{gzipped, util.StrToPtr("gzip"), "goodbye", false},
+		// not gzipped
+		{"data:,hello", util.StrToPtr("gzip"), "hello", false},
+		{gzipped, util.StrToPtr("xz"), "hello", false},
+		{"data:;base64,!", nil, "", false},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("verify %d", i), func(t *testing.T) {
+			err := VerifyDataURL(test.uri, test.compression, []byte(test.contents))
+			if test.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, common.ErrCompressionVerify)
+			}
+		})
+	}
+}
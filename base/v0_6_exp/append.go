@@ -0,0 +1,220 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package v0_6_exp
+
+import (
+	"github.com/coreos/butane/config/common"
+	"github.com/coreos/butane/translate"
+
+	"github.com/coreos/ignition/v2/config/util"
+	"github.com/coreos/ignition/v2/config/v3_5_experimental/types"
+	"github.com/coreos/vcontext/path"
+	"github.com/coreos/vcontext/report"
+	"github.com/coreos/vcontext/validate"
+)
+
+// The Append functions validate and translate a single storage entry and
+// append it to an already-translated config, adding its translations to
+// ts.  fromPath is the location of the entry in the caller's Butane
+// config, such as $.storage.files.3, and prefixes the paths in the
+// translations and the report.  The config is left unchanged if the
+// report is fatal.
+//
+// Nodes which ToIgn3_5Unvalidated derives from the whole config, such as
+// parent directories, are not added, and options which apply to the whole
+// config, such as PathPrefix, PathRewriter, and the Warn* checks, are
+// ignored.
+
+// AppendFile appends a files entry to config.  Its path must not be
+// used by an existing node.
+func AppendFile(config *types.Config, ts *translate.TranslationSet, from File, fromPath path.ContextPath, options common.TranslateOptions) report.Report {
+	r := prepareAppend(*config, ts, from, fromPath, from.Path)
+	if r.IsFatal() {
+		return r
+	}
+	to, tm, r2 := translateFile(from, options.TrackEmbeddedBytes())
+	r.Merge(reparentReport(r2, path.New("yaml"), fromPath))
+	if r.IsFatal() {
+		return r
+	}
+	if from.Contents.Local != nil && to.Contents.Source == nil {
+		// skipped with SkipMissingLocalFiles
+		return r
+	}
+	i := len(config.Storage.Files)
+	config.Storage.Files = append(config.Storage.Files, to)
+	mergeAppended(ts, tm, fromPath, path.New("json", "storage", "files", i))
+	return r
+}
+
+// AppendDirectory appends a directories entry to config.  Its path must
+// not be used by an existing node.
+func AppendDirectory(config *types.Config, ts *translate.TranslationSet, from Directory, fromPath path.ContextPath, options common.TranslateOptions) report.Report {
+	r := prepareAppend(*config, ts, from, fromPath, from.Path)
+	if r.IsFatal() {
+		return r
+	}
+	to, tm, r2 := translateDirectory(from, options)
+	r.Merge(reparentReport(r2, path.New("yaml"), fromPath))
+	if r.IsFatal() {
+		return r
+	}
+	i := len(config.Storage.Directories)
+	config.Storage.Directories = append(config.Storage.Directories, to)
+	mergeAppended(ts, tm, fromPath, path.New("json", "storage", "directories", i))
+	return r
+}
+
+// AppendLink appends a links entry to config.  Its path must not be used
+// by an existing node.
+func AppendLink(config *types.Config, ts *translate.TranslationSet, from Link, fromPath path.ContextPath, options common.TranslateOptions) report.Report {
+	r := prepareAppend(*config, ts, from, fromPath, from.Path)
+	if r.IsFatal() {
+		return r
+	}
+	to, tm, r2 := translateLink(from, options)
+	r.Merge(reparentReport(r2, path.New("yaml"), fromPath))
+	if r.IsFatal() {
+		return r
+	}
+	i := len(config.Storage.Links)
+	config.Storage.Links = append(config.Storage.Links, to)
+	mergeAppended(ts, tm, fromPath, path.New("json", "storage", "links", i))
+	return r
+}
+
+// AppendFilesystem appends a filesystems entry to config, along with its
+// mount unit and resize service if requested.  Its device must not have
+// an existing filesystem entry, and the generated units must not have
+// the same names as existing units.  Mount units for filesystems on
+// LUKS volumes wait for volumes already in config.  Mount units are
+// generated even if options.MountStyle is fstab.
+func AppendFilesystem(config *types.Config, ts *translate.TranslationSet, from Filesystem, fromPath path.ContextPath, options common.TranslateOptions) report.Report {
+	r := prepareAppend(*config, ts, from, fromPath, "")
+	for _, fs := range config.Storage.Filesystems {
+		if fs.Device == from.Device {
+			r.AddOnError(fromPath.Append("device"), common.ErrFilesystemExists)
+		}
+	}
+	if r.IsFatal() {
+		return r
+	}
+	var to types.Filesystem
+	tm, r2 := newTranslator(options.TrackEmbeddedBytes()).Translate(&from, &to)
+	r.Merge(reparentReport(r2, path.New("yaml"), fromPath))
+
+	// render units separately, as if the filesystem were the only one
+	var units types.Config
+	unitTranslations := translate.NewTranslationSet("yaml", "json")
+	if util.IsTrue(from.WithMountUnit) {
+		var luks []Luks
+		for _, l := range config.Storage.Luks {
+			// only the fields which affect the mount unit
+			luks = append(luks, Luks{
+				Name:   l.Name,
+				Device: l.Device,
+				Clevis: Clevis{
+					Tang: make([]Tang, len(l.Clevis.Tang)),
+				},
+			})
+		}
+		c := Config{
+			Storage: Storage{
+				Filesystems: []Filesystem{from},
+				Luks:        luks,
+			},
+		}
+		unitOptions := options
+		unitOptions.PathPrefix = ""
+		// the fstab is written once per translation
+		unitOptions.MountStyle = ""
+		r2 := c.addMountUnits(&units, &unitTranslations, unitOptions)
+		r.Merge(reparentReport(r2, path.New("yaml", "storage", "filesystems", 0), fromPath))
+		for _, newUnit := range units.Systemd.Units {
+			for _, unit := range config.Systemd.Units {
+				if unit.Name == newUnit.Name {
+					r.AddOnError(fromPath.Append("with_mount_unit"), common.ErrMountUnitExists)
+				}
+			}
+		}
+	}
+	if r.IsFatal() {
+		return r
+	}
+
+	i := len(config.Storage.Filesystems)
+	config.Storage.Filesystems = append(config.Storage.Filesystems, to)
+	mergeAppended(ts, tm, fromPath, path.New("json", "storage", "filesystems", i))
+	for j, unit := range units.Systemd.Units {
+		k := len(config.Systemd.Units)
+		config.Systemd.Units = append(config.Systemd.Units, unit)
+		unitTm := translate.NewTranslationSet("yaml", "json")
+		for _, t := range unitTranslations.Descend(path.New("json", "systemd", "units", j)).Set {
+			unitTm.AddTranslation(reparentPath(t.From, path.New("yaml", "storage", "filesystems", 0), path.New("yaml")), t.To)
+		}
+		mergeAppended(ts, unitTm, fromPath, path.New("json", "systemd", "units", k))
+	}
+	return r
+}
+
+// prepareAppend initializes ts if necessary, and validates the entry
+// from and checks that nodePath, if any, is unused.
+func prepareAppend(config types.Config, ts *translate.TranslationSet, from interface{}, fromPath path.ContextPath, nodePath string) report.Report {
+	if ts.Set == nil {
+		*ts = translate.NewTranslationSet("yaml", "json")
+	}
+	r := reparentReport(validate.Validate(from, "yaml"), path.New("yaml"), fromPath)
+	if nodePath != "" && newNodeTracker(&config).Exists(nodePath) {
+		r.AddOnError(fromPath.Append("path"), common.ErrNodePathExists)
+	}
+	return r
+}
+
+// mergeAppended adds the translations tm of an entry appended at toPath
+// to ts, along with translations for toPath and any of its parents which
+// ts doesn't have yet.
+func mergeAppended(ts *translate.TranslationSet, tm translate.TranslationSet, fromPath, toPath path.ContextPath) {
+	ts.Merge(tm.PrefixPaths(fromPath, toPath))
+	for p := toPath; len(p.Path) > 0; p = path.New(p.Tag, p.Path[:len(p.Path)-1]...) {
+		if _, ok := ts.Lookup(p); !ok {
+			ts.AddTranslation(fromPath, p)
+		}
+	}
+}
+
+// reparentReport returns a copy of r with the prefix oldPrefix of entry
+// paths replaced by newPrefix.
+func reparentReport(r report.Report, oldPrefix, newPrefix path.ContextPath) report.Report {
+	var ret report.Report
+	for _, entry := range r.Entries {
+		entry.Context = reparentPath(entry.Context, oldPrefix, newPrefix)
+		ret.Entries = append(ret.Entries, entry)
+	}
+	return ret
+}
+
+// reparentPath replaces the prefix oldPrefix of p with newPrefix.  p is
+// returned unchanged if it doesn't start with oldPrefix.
+func reparentPath(p, oldPrefix, newPrefix path.ContextPath) path.ContextPath {
+	if p.Tag != oldPrefix.Tag || len(p.Path) < len(oldPrefix.Path) {
+		return p
+	}
+	for i, e := range oldPrefix.Path {
+		if p.Path[i] != e {
+			return p
+		}
+	}
+	return newPrefix.Append(p.Path[len(oldPrefix.Path):]...)
+}
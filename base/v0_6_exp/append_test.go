@@ -0,0 +1,166 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package v0_6_exp
+
+import (
+	"testing"
+
+	"github.com/coreos/butane/config/common"
+	"github.com/coreos/butane/translate"
+
+	"github.com/coreos/ignition/v2/config/util"
+	"github.com/coreos/ignition/v2/config/v3_5_experimental/types"
+	"github.com/coreos/vcontext/path"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAppend checks that appending entries one at a time produces the
+// same config as translating them together.
+func TestAppend(t *testing.T) {
+	file := File{
+		Path: "/etc/motd",
+		Contents: Resource{
+			Inline: util.StrToPtr("hello"),
+		},
+	}
+	dir := Directory{
+		Path: "/etc/d",
+		Mode: util.IntToPtr(0700),
+	}
+	link := Link{
+		Path:   "/etc/l",
+		Target: util.StrToPtr("motd"),
+	}
+	fs := Filesystem{
+		Device:        "/dev/mapper/data",
+		Format:        util.StrToPtr("xfs"),
+		Path:          util.StrToPtr("/var/data"),
+		WithMountUnit: util.BoolToPtr(true),
+		Resize:        util.BoolToPtr(true),
+	}
+	luks := Luks{
+		Name:   "data",
+		Device: util.StrToPtr("/dev/vdb"),
+		Clevis: Clevis{
+			Tang: []Tang{
+				{
+					URL:        "https://tang.example.com",
+					Thumbprint: util.StrToPtr("x"),
+				},
+			},
+		},
+	}
+	options := common.TranslateOptions{
+		NoResourceAutoCompression: true,
+	}
+
+	full := Config{
+		Storage: Storage{
+			Files:       []File{file},
+			Directories: []Directory{dir},
+			Links:       []Link{link},
+			Filesystems: []Filesystem{fs},
+			Luks:        []Luks{luks},
+		},
+	}
+	expected, expectedTs, r := full.ToIgn3_5Unvalidated(options)
+	assert.Empty(t, r.Entries, "full translation failed")
+
+	base := Config{
+		Storage: Storage{
+			Luks: []Luks{luks},
+		},
+	}
+	actual, ts, r := base.ToIgn3_5Unvalidated(options)
+	assert.Empty(t, r.Entries, "base translation failed")
+	r = AppendFile(&actual, &ts, file, path.New("yaml", "storage", "files", 0), options)
+	assert.Empty(t, r.Entries, "appending file failed")
+	r = AppendDirectory(&actual, &ts, dir, path.New("yaml", "storage", "directories", 0), options)
+	assert.Empty(t, r.Entries, "appending directory failed")
+	r = AppendLink(&actual, &ts, link, path.New("yaml", "storage", "links", 0), options)
+	assert.Empty(t, r.Entries, "appending link failed")
+	r = AppendFilesystem(&actual, &ts, fs, path.New("yaml", "storage", "filesystems", 0), options)
+	assert.Empty(t, r.Entries, "appending filesystem failed")
+
+	assert.Equal(t, expected, actual, "bad config")
+	assert.NoError(t, ts.DebugVerifyCoverage(actual), "incomplete TranslationSet coverage")
+	for _, p := range []path.ContextPath{
+		path.New("json", "storage", "files", 0, "contents", "source"),
+		path.New("json", "storage", "directories", 0, "mode"),
+		path.New("json", "storage", "links", 0, "target"),
+		path.New("json", "storage", "filesystems", 0, "device"),
+		path.New("json", "systemd", "units", 0, "contents"),
+		path.New("json", "systemd", "units", 1, "contents"),
+	} {
+		expectedFrom, ok := expectedTs.Lookup(p)
+		assert.True(t, ok, "missing expected translation for %s", p)
+		from, ok := ts.Lookup(p)
+		if assert.True(t, ok, "missing translation for %s", p) {
+			assert.Equal(t, expectedFrom.From, from.From, "bad translation for %s", p)
+		}
+	}
+}
+
+// TestAppendConflicts checks that appended entries can't collide with
+// existing ones.
+func TestAppendConflicts(t *testing.T) {
+	config := types.Config{
+		Storage: types.Storage{
+			Directories: []types.Directory{
+				{
+					Node: types.Node{
+						Path: "/etc/d",
+					},
+				},
+			},
+			Filesystems: []types.Filesystem{
+				{
+					Device: "/dev/vdb",
+				},
+			},
+		},
+		Systemd: types.Systemd{
+			Units: []types.Unit{
+				{
+					Name: "var-data.mount",
+				},
+			},
+		},
+	}
+	var ts translate.TranslationSet
+	fromPath := path.New("yaml", "storage", "files", 3)
+
+	r := AppendFile(&config, &ts, File{Path: "/etc/d"}, fromPath, common.TranslateOptions{})
+	assert.Equal(t, "error at $.storage.files.3.path: "+common.ErrNodePathExists.Error()+"\n", r.String(), "bad file report")
+	r = AppendLink(&config, &ts, Link{Path: "/etc/d", Target: util.StrToPtr("x")}, fromPath, common.TranslateOptions{})
+	assert.Equal(t, "error at $.storage.files.3.path: "+common.ErrNodePathExists.Error()+"\n", r.String(), "bad link report")
+	r = AppendFilesystem(&config, &ts, Filesystem{Device: "/dev/vdb"}, fromPath, common.TranslateOptions{})
+	assert.Equal(t, "error at $.storage.files.3.device: "+common.ErrFilesystemExists.Error()+"\n", r.String(), "bad filesystem report")
+	r = AppendFilesystem(&config, &ts, Filesystem{
+		Device:        "/dev/vdc",
+		Format:        util.StrToPtr("xfs"),
+		Path:          util.StrToPtr("/var/data"),
+		WithMountUnit: util.BoolToPtr(true),
+	}, fromPath, common.TranslateOptions{})
+	assert.Equal(t, "error at $.storage.files.3.with_mount_unit: "+common.ErrMountUnitExists.Error()+"\n", r.String(), "bad mount unit report")
+	// validation
+	r = AppendFile(&config, &ts, File{Path: "/etc/f", Mode: util.IntToPtr(644)}, fromPath, common.TranslateOptions{})
+	assert.Equal(t, "warning at $.storage.files.3.mode: "+common.ErrDecimalMode.Error()+"\n", r.String(), "bad validation report")
+
+	assert.Len(t, config.Storage.Files, 1, "bad files")
+	assert.Len(t, config.Storage.Links, 0, "bad links")
+	assert.Len(t, config.Storage.Filesystems, 1, "bad filesystems")
+	assert.Len(t, config.Systemd.Units, 1, "bad units")
+}
@@ -0,0 +1,90 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package v0_6_exp
+
+import (
+	slashpath "path"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/coreos/ignition/v2/config/util"
+)
+
+// Mount describes how a storage.filesystems entry relates to its mount
+// point, the LUKS volume containing it, and the unit Butane generates for
+// it.
+type Mount struct {
+	Index      int    // index of the entry in storage.filesystems
+	Device     string // device containing the filesystem
+	Format     string // filesystem type, or swap
+	Path       string // mount point, including any PathPrefix; empty if unspecified
+	Luks       string // name of the LUKS volume Device refers to or underlies, if any
+	LuksDevice string // device underlying the LUKS volume
+	Remote     bool   // whether the LUKS volume is unlocked with Tang, so mounting waits for the network
+	Unit       string // name of the generated mount or swap unit; empty if none is generated
+	Fstab      bool   // whether an /etc/fstab entry is generated instead of a unit
+}
+
+// Mounts returns the relationships between the filesystems of c and their
+// mount points and LUKS volumes, as translation with options would see
+// them: devices are rewritten to options.DevicePathForm, filesystems on
+// the device underlying a LUKS volume are reported with that volume, and
+// mount points are prefixed with options.PathPrefix.  As in translation,
+// options.PathRewriter applies only to storage nodes.  It doesn't
+// validate c, so it can be used to check policy before translating;
+// entries missing fields required for a mount unit are reported without
+// one.
+func (c Config) Mounts(options common.TranslateOptions) []Mount {
+	if options.DevicePathForm != "" {
+		// problems are reported by translation
+		c, _ = c.normalizeDevicePaths(options.DevicePathForm)
+	}
+	var ret []Mount
+	for i, fs := range c.Storage.Filesystems {
+		m := Mount{
+			Index:  i,
+			Device: fs.Device,
+		}
+		if fs.Format != nil {
+			m.Format = *fs.Format
+		}
+		if util.NotEmpty(fs.Path) {
+			if options.PathPrefix != "" {
+				fs.Path = util.StrToPtr(slashpath.Join(options.PathPrefix, *fs.Path))
+			}
+			m.Path = *fs.Path
+		}
+		luks, ok := c.luksVolume(fs.Device)
+		if !ok {
+			luks, ok = c.luksBackingVolume(fs.Device)
+		}
+		if ok {
+			m.Luks = luks.Name
+			if luks.Device != nil {
+				m.LuksDevice = *luks.Device
+			}
+			m.Remote = len(luks.Clevis.Tang) > 0
+		}
+		if util.IsTrue(fs.WithMountUnit) && (m.Format == "swap" || (m.Format != "" && m.Path != "")) {
+			if options.MountStyle == "fstab" {
+				m.Fstab = true
+			} else {
+				m.Unit = mountUnitName(fs)
+			}
+		}
+		ret = append(ret, m)
+	}
+	return ret
+}
@@ -0,0 +1,244 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package v0_6_exp
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/coreos/ignition/v2/config/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMounts checks the relationships reported for filesystems, LUKS
+// volumes, and generated units.
+func TestMounts(t *testing.T) {
+	in := Config{
+		Storage: Storage{
+			Luks: []Luks{
+				{
+					Name:   "data",
+					Device: util.StrToPtr("/dev/vdb"),
+				},
+				{
+					Name:   "net",
+					Device: util.StrToPtr("/dev/vdc"),
+					Clevis: Clevis{
+						Tang: []Tang{
+							{
+								URL: "http://example.com",
+							},
+						},
+					},
+				},
+			},
+			Filesystems: []Filesystem{
+				{
+					Device:        "/dev/mapper/data",
+					Format:        util.StrToPtr("xfs"),
+					Path:          util.StrToPtr("/var/data"),
+					WithMountUnit: util.BoolToPtr(true),
+				},
+				{
+					Device:        "/dev/disk/by-id/dm-name-net",
+					Format:        util.StrToPtr("ext4"),
+					Path:          util.StrToPtr("/var/net"),
+					WithMountUnit: util.BoolToPtr(true),
+				},
+				{
+					Device:        "/dev/disk/by-label/swap",
+					Format:        util.StrToPtr("swap"),
+					WithMountUnit: util.BoolToPtr(true),
+				},
+				{
+					Device: "/dev/disk/by-label/other",
+					Format: util.StrToPtr("xfs"),
+					Path:   util.StrToPtr("/var/other"),
+				},
+				// incomplete
+				{
+					Device:        "/dev/disk/by-label/nopath",
+					Format:        util.StrToPtr("xfs"),
+					WithMountUnit: util.BoolToPtr(true),
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		options common.TranslateOptions
+		out     []Mount
+	}{
+		{
+			common.TranslateOptions{},
+			[]Mount{
+				{
+					Index:      0,
+					Device:     "/dev/mapper/data",
+					Format:     "xfs",
+					Path:       "/var/data",
+					Luks:       "data",
+					LuksDevice: "/dev/vdb",
+					Unit:       "var-data.mount",
+				},
+				{
+					Index:      1,
+					Device:     "/dev/disk/by-id/dm-name-net",
+					Format:     "ext4",
+					Path:       "/var/net",
+					Luks:       "net",
+					LuksDevice: "/dev/vdc",
+					Remote:     true,
+					Unit:       "var-net.mount",
+				},
+				{
+					Index:  2,
+					Device: "/dev/disk/by-label/swap",
+					Format: "swap",
+					Unit:   "dev-disk-by\\x2dlabel-swap.swap",
+				},
+				{
+					Index:  3,
+					Device: "/dev/disk/by-label/other",
+					Format: "xfs",
+					Path:   "/var/other",
+				},
+				{
+					Index:  4,
+					Device: "/dev/disk/by-label/nopath",
+					Format: "xfs",
+				},
+			},
+		},
+		{
+			common.TranslateOptions{
+				MountStyle: "fstab",
+				PathPrefix: "/sysroot",
+			},
+			[]Mount{
+				{
+					Index:      0,
+					Device:     "/dev/mapper/data",
+					Format:     "xfs",
+					Path:       "/sysroot/var/data",
+					Luks:       "data",
+					LuksDevice: "/dev/vdb",
+					Fstab:      true,
+				},
+				{
+					Index:      1,
+					Device:     "/dev/disk/by-id/dm-name-net",
+					Format:     "ext4",
+					Path:       "/sysroot/var/net",
+					Luks:       "net",
+					LuksDevice: "/dev/vdc",
+					Remote:     true,
+					Fstab:      true,
+				},
+				{
+					Index:  2,
+					Device: "/dev/disk/by-label/swap",
+					Format: "swap",
+					Fstab:  true,
+				},
+				{
+					Index:  3,
+					Device: "/dev/disk/by-label/other",
+					Format: "xfs",
+					Path:   "/sysroot/var/other",
+				},
+				{
+					Index:  4,
+					Device: "/dev/disk/by-label/nopath",
+					Format: "xfs",
+				},
+			},
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("mounts %d", i), func(t *testing.T) {
+			assert.Equal(t, test.out, in.Mounts(test.options), "bad mounts")
+		})
+	}
+}
+
+// TestMountsDevices checks that devices are reported as translation
+// rewrites them.
+func TestMountsDevices(t *testing.T) {
+	in := Config{
+		Storage: Storage{
+			Disks: []Disk{
+				{
+					Device: "/dev/vda",
+					Partitions: []Partition{
+						{
+							Label:  util.StrToPtr("crypt"),
+							Number: 4,
+						},
+						{
+							Label:  util.StrToPtr("var"),
+							Number: 5,
+						},
+					},
+				},
+			},
+			Luks: []Luks{
+				{
+					Name:   "crypt",
+					Device: util.StrToPtr("/dev/disk/by-partlabel/crypt"),
+				},
+			},
+			Filesystems: []Filesystem{
+				// on the LUKS backing partition
+				{
+					Device:        "/dev/vda4",
+					Format:        util.StrToPtr("xfs"),
+					Path:          util.StrToPtr("/var/crypt"),
+					WithMountUnit: util.BoolToPtr(true),
+				},
+				{
+					Device:        "/dev/vda5",
+					Format:        util.StrToPtr("xfs"),
+					Path:          util.StrToPtr("/var"),
+					WithMountUnit: util.BoolToPtr(true),
+				},
+			},
+		},
+	}
+	expected := []Mount{
+		{
+			Index:      0,
+			Device:     "/dev/disk/by-partlabel/crypt",
+			Format:     "xfs",
+			Path:       "/var/crypt",
+			Luks:       "crypt",
+			LuksDevice: "/dev/disk/by-partlabel/crypt",
+			Unit:       "var-crypt.mount",
+		},
+		{
+			Index:  1,
+			Device: "/dev/disk/by-partlabel/var",
+			Format: "xfs",
+			Path:   "/var",
+			Unit:   "var.mount",
+		},
+	}
+	assert.Equal(t, expected, in.Mounts(common.TranslateOptions{
+		DevicePathForm: "partlabel",
+	}), "bad mounts")
+}
@@ -34,9 +34,24 @@ type Config struct {
 	KernelArguments KernelArguments `yaml:"kernel_arguments"`
 	Passwd          Passwd          `yaml:"passwd"`
 	Storage         Storage         `yaml:"storage"`
+	System          System          `yaml:"system" butane:"auto_skip"` // Added, not in Ignition spec
 	Systemd         Systemd         `yaml:"systemd"`
 }
 
+type Container struct {
+	Environment []string `yaml:"environment"`
+	Image       string   `yaml:"image"`
+	Name        string   `yaml:"name"`
+	Ports       []string `yaml:"ports"`
+	UserID      *int     `yaml:"user_id"`
+	Volumes     []string `yaml:"volumes"`
+}
+
+type DefaultOwner struct {
+	Group NodeGroup `yaml:"group"`
+	User  NodeUser  `yaml:"user"`
+}
+
 type Device string
 
 type Directory struct {
@@ -45,6 +60,7 @@ type Directory struct {
 	Path      string    `yaml:"path"`
 	User      NodeUser  `yaml:"user"`
 	Mode      *int      `yaml:"mode"`
+	Recursive *bool     `yaml:"recursive"`
 }
 
 type Disk struct {
@@ -59,6 +75,13 @@ type Dropin struct {
 	Name          string  `yaml:"name"`
 }
 
+type Extension struct {
+	Contents  Resource `yaml:"contents"`
+	Directory *string  `yaml:"directory"`
+	Name      string   `yaml:"name"`
+	Type      *string  `yaml:"type"`
+}
+
 type File struct {
 	Group     NodeGroup  `yaml:"group"`
 	Overwrite *bool      `yaml:"overwrite"`
@@ -79,6 +102,14 @@ type Filesystem struct {
 	UUID           *string  `yaml:"uuid"`
 	WipeFilesystem *bool    `yaml:"wipe_filesystem"`
 	WithMountUnit  *bool    `yaml:"with_mount_unit" butane:"auto_skip"` // Added, not in Ignition spec
+	MountType      *string  `yaml:"mount_type" butane:"auto_skip"`      // Added, not in Ignition spec
+	Resize         *bool    `yaml:"resize" butane:"auto_skip"`          // Added, not in Ignition spec
+
+	MountInstallRequires *bool    `yaml:"mount_install_requires" butane:"auto_skip"` // Added, not in Ignition spec
+	MountBefore          []string `yaml:"mount_before" butane:"auto_skip"`           // Added, not in Ignition spec
+	MountAfter           []string `yaml:"mount_after" butane:"auto_skip"`            // Added, not in Ignition spec
+
+	SystemdMountOptions map[string]string `yaml:"systemd_mount_options" butane:"auto_skip"` // Added, not in Ignition spec
 }
 
 type Group string
@@ -98,8 +129,13 @@ type Ignition struct {
 }
 
 type IgnitionConfig struct {
-	Merge   []Resource `yaml:"merge"`
-	Replace Resource   `yaml:"replace"`
+	Merge      []Resource   `yaml:"merge"`
+	MergeTrees []ConfigTree `yaml:"merge_trees" butane:"auto_skip"` // Added, not in ignition spec
+	Replace    Resource     `yaml:"replace"`
+}
+
+type ConfigTree struct {
+	Local string `yaml:"local"`
 }
 
 type KernelArgument string
@@ -131,6 +167,13 @@ type Luks struct {
 	WipeVolume  *bool    `yaml:"wipe_volume"`
 }
 
+type Network struct {
+	Addresses []string `yaml:"addresses"`
+	DNS       []string `yaml:"dns"`
+	Gateway   *string  `yaml:"gateway"`
+	Name      string   `yaml:"name"`
+}
+
 type NodeGroup struct {
 	ID   *int    `yaml:"id"`
 	Name *string `yaml:"name"`
@@ -175,6 +218,7 @@ type PasswdUser struct {
 	NoLogInit              *bool              `yaml:"no_log_init"`
 	NoUserGroup            *bool              `yaml:"no_user_group"`
 	PasswordHash           *string            `yaml:"password_hash"`
+	PasswordHashLocal      *string            `yaml:"password_hash_local"`
 	PrimaryGroup           *string            `yaml:"primary_group"`
 	ShouldExist            *bool              `yaml:"should_exist"`
 	SSHAuthorizedKeys      []SSHAuthorizedKey `yaml:"ssh_authorized_keys"`
@@ -184,6 +228,11 @@ type PasswdUser struct {
 	UID                    *int               `yaml:"uid"`
 }
 
+type Presets struct {
+	Disabled []string `yaml:"disabled"`
+	Enabled  []string `yaml:"enabled"`
+}
+
 type Proxy struct {
 	HTTPProxy  *string  `yaml:"http_proxy"`
 	HTTPSProxy *string  `yaml:"https_proxy"`
@@ -199,12 +248,33 @@ type Raid struct {
 }
 
 type Resource struct {
-	Compression  *string      `yaml:"compression"`
-	HTTPHeaders  HTTPHeaders  `yaml:"http_headers"`
-	Source       *string      `yaml:"source"`
-	Inline       *string      `yaml:"inline"` // Added, not in ignition spec
-	Local        *string      `yaml:"local"`  // Added, not in ignition spec
-	Verification Verification `yaml:"verification"`
+	Compression  *string       `yaml:"compression"`
+	HTTPHeaders  HTTPHeaders   `yaml:"http_headers"`
+	Source       *string       `yaml:"source"`
+	Inline       *string       `yaml:"inline"` // Added, not in ignition spec
+	Local        *string       `yaml:"local"`  // Added, not in ignition spec
+	Git          *GitResource  `yaml:"git"`    // Added, not in ignition spec
+	Exec         *ExecResource `yaml:"exec"`   // Added, not in ignition spec
+	OCI          *OCIResource  `yaml:"oci"`    // Added, not in ignition spec
+	Verification Verification  `yaml:"verification"`
+	LineEndings  *string       `yaml:"line_endings"` // Added, not in ignition spec
+	AddBOM       *bool         `yaml:"add_bom"`      // Added, not in ignition spec
+}
+
+type ExecResource struct {
+	Args    []string `yaml:"args"`
+	Command string   `yaml:"command"`
+}
+
+type OCIResource struct {
+	Digest *string `yaml:"digest"`
+	Ref    string  `yaml:"ref"`
+}
+
+type GitResource struct {
+	Path string  `yaml:"path"`
+	Ref  *string `yaml:"ref"`
+	URL  string  `yaml:"url"`
 }
 
 type SSHAuthorizedKey string
@@ -214,18 +284,30 @@ type Security struct {
 }
 
 type Storage struct {
-	Directories []Directory  `yaml:"directories"`
-	Disks       []Disk       `yaml:"disks"`
-	Files       []File       `yaml:"files"`
-	Filesystems []Filesystem `yaml:"filesystems"`
-	Links       []Link       `yaml:"links"`
-	Luks        []Luks       `yaml:"luks"`
-	Raid        []Raid       `yaml:"raid"`
-	Trees       []Tree       `yaml:"trees" butane:"auto_skip"` // Added, not in ignition spec
+	CreateParentDirsMode *int          `yaml:"create_parent_dirs_mode" butane:"auto_skip"` // Added, not in ignition spec
+	DefaultOwner         *DefaultOwner `yaml:"default_owner" butane:"auto_skip"`           // Added, not in ignition spec
+	Directories          []Directory   `yaml:"directories"`
+	Disks                []Disk        `yaml:"disks"`
+	Extensions           []Extension   `yaml:"extensions" butane:"auto_skip"` // Added, not in ignition spec
+	Files                []File        `yaml:"files"`
+	Filesystems          []Filesystem  `yaml:"filesystems"`
+	Links                []Link        `yaml:"links"`
+	Luks                 []Luks        `yaml:"luks"`
+	Raid                 []Raid        `yaml:"raid"`
+	Trees                []Tree        `yaml:"trees" butane:"auto_skip"` // Added, not in ignition spec
+}
+
+type System struct {
+	Issue *Resource `yaml:"issue"`
+	Motd  *Resource `yaml:"motd"`
 }
 
 type Systemd struct {
-	Units []Unit `yaml:"units"`
+	Containers []Container `yaml:"containers" butane:"auto_skip"` // Added, not in Ignition spec
+	Networks   []Network   `yaml:"networks" butane:"auto_skip"`   // Added, not in Ignition spec
+	Presets    Presets     `yaml:"presets" butane:"auto_skip"`    // Added, not in Ignition spec
+	Timers     []Timer     `yaml:"timers" butane:"auto_skip"`     // Added, not in Ignition spec
+	Units      []Unit      `yaml:"units"`
 }
 
 type Tang struct {
@@ -243,9 +325,25 @@ type Timeouts struct {
 	HTTPTotal           *int `yaml:"http_total"`
 }
 
+type Timer struct {
+	ExecStart  string `yaml:"exec_start"`
+	Name       string `yaml:"name"`
+	OnCalendar string `yaml:"on_calendar"`
+}
+
 type Tree struct {
-	Local string  `yaml:"local"`
-	Path  *string `yaml:"path"`
+	Compression   *string   `yaml:"compression"`
+	Flatten       *bool     `yaml:"flatten"`
+	Group         NodeGroup `yaml:"group"`
+	Local         string    `yaml:"local"`
+	ModeFilter    *string   `yaml:"mode_filter"`
+	ModesFile     *string   `yaml:"modes_file"`
+	OnSpecialFile *string   `yaml:"on_special_file"`
+	Overwrite     *bool     `yaml:"overwrite"`
+	Path          *string   `yaml:"path"`
+	PreserveModes *bool     `yaml:"preserve_modes"`
+	SkipHidden    *bool     `yaml:"skip_hidden"`
+	User          NodeUser  `yaml:"user"`
 }
 
 type Unit struct {
@@ -15,13 +15,23 @@
 package v0_6_exp
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
+	"io/fs"
+	"net/http"
 	slashpath "path"
-	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
+	"time"
+	"unicode/utf8"
 
 	baseutil "github.com/coreos/butane/base/util"
 	"github.com/coreos/butane/config/common"
@@ -32,9 +42,16 @@ import (
 	"github.com/coreos/ignition/v2/config/v3_5_experimental/types"
 	"github.com/coreos/vcontext/path"
 	"github.com/coreos/vcontext/report"
+	"github.com/vincent-petithory/dataurl"
 )
 
 var (
+	utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+	// characters allowed in unit names, other than the template
+	// instance separator
+	generatedUnitPrefixRe = regexp.MustCompile(`^[A-Za-z0-9:_.\\-]*$`)
+
 	mountUnitTemplate = template.Must(template.New("unit").Parse(`
 {{- define "options" }}
   {{- if or .MountOptions .Remote }}
@@ -47,32 +64,157 @@ Options=
   {{- end }}
 {{- end -}}
 
-# Generated by Butane
-{{- if .Swap }}
+{{- define "install" }}{{ if .Wanted }}WantedBy{{ else }}RequiredBy{{ end }}{{ end -}}
+
+{{- define "order" }}
+  {{- range .MountBefore }}
+Before={{.}}
+  {{- end }}
+  {{- range .MountAfter }}
+After={{.}}
+  {{- end }}
+{{- end -}}
+
+{{ if not .NoUnitComments }}# Generated by Butane
+{{ end -}}
+{{ if .Swap -}}
+{{ if or .CryptsetupUnit .FormatUnit .MountBefore .MountAfter -}}
+[Unit]
+{{- if .CryptsetupUnit }}
+Requires={{.CryptsetupUnit}}
+After={{.CryptsetupUnit}}
+{{- end }}
+{{- if .FormatUnit }}
+After={{.FormatUnit}}
+{{- end }}
+{{- template "order" . }}
+
+{{ end -}}
 [Swap]
-What={{.Device}}
+What={{.What}}
 {{- template "options" . }}
 
 [Install]
-RequiredBy=swap.target
-{{- else }}
+{{ template "install" . }}=swap.target
+{{- else -}}
 [Unit]
+{{- if .CryptsetupUnit }}
+Requires={{.CryptsetupUnit}}
+After={{.CryptsetupUnit}}
+{{- end }}
+{{- if .FormatUnit }}
+After={{.FormatUnit}}
+{{- end }}
 Requires=systemd-fsck@{{.EscapedDevice}}.service
 After=systemd-fsck@{{.EscapedDevice}}.service
+{{- template "order" . }}
 
 [Mount]
-Where={{.Path}}
-What={{.Device}}
-Type={{.Format}}
+Where={{.Where}}
+What={{.What}}
+Type={{.Type}}
 {{- template "options" . }}
 
 [Install]
 {{- if .Remote }}
-RequiredBy=remote-fs.target
+{{ template "install" . }}=remote-fs.target
 {{- else }}
-RequiredBy=local-fs.target
+{{ template "install" . }}=local-fs.target
+{{- end }}
+{{- end }}`))
+
+	resizeUnitTemplate = template.Must(template.New("unit").Parse(`
+{{- if not .NoUnitComments }}# Generated by Butane
+{{ end -}}
+[Unit]
+Description=Grow filesystem at {{.MountPoint}}
+Requires={{.MountUnit}}
+After={{.MountUnit}}
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart={{.Command}}
+
+[Install]
+WantedBy={{.MountUnit}}`))
+
+	timerServiceTemplate = template.Must(template.New("unit").Parse(`
+{{- if not .NoUnitComments }}# Generated by Butane
+{{ end -}}
+[Unit]
+Description=Run {{.Name}}
+
+[Service]
+Type=oneshot
+ExecStart={{.ExecStart}}`))
+
+	timerTemplate = template.Must(template.New("unit").Parse(`
+{{- if not .NoUnitComments }}# Generated by Butane
+{{ end -}}
+[Unit]
+Description=Schedule {{.Name}}
+
+[Timer]
+OnCalendar={{.OnCalendar}}
+Unit={{.Name}}.service
+
+[Install]
+WantedBy=timers.target`))
+
+	networkTemplate = template.Must(template.New("network").Parse(`
+{{- if not .NoUnitComments }}# Generated by Butane
+{{ end -}}
+[Match]
+Name={{.Name}}
+
+[Network]
+{{- range .Addresses }}
+Address={{.}}
+{{- end }}
+{{- if .Gateway }}
+Gateway={{.Gateway}}
 {{- end }}
+{{- range .DNS }}
+DNS={{.}}
 {{- end }}`))
+
+	treeMtimesTemplate = template.Must(template.New("unit").Parse(`
+{{- if not .NoUnitComments }}# Generated by Butane
+{{ end -}}
+[Unit]
+Description=Restore modification times of files from Butane trees
+ConditionFirstBoot=yes
+
+[Service]
+Type=oneshot
+{{- range .Commands }}
+ExecStart={{.}}
+{{- end }}
+
+[Install]
+WantedBy=multi-user.target`))
+
+	containerTemplate = template.Must(template.New("container").Parse(`
+{{- if not .NoUnitComments }}# Generated by Butane
+{{ end -}}
+[Unit]
+Description=Run container {{.Name}}
+
+[Container]
+Image={{.Image}}
+{{- range .Ports }}
+PublishPort={{.}}
+{{- end }}
+{{- range .Volumes }}
+Volume={{.}}
+{{- end }}
+{{- range .Environment }}
+Environment={{.}}
+{{- end }}
+
+[Install]
+WantedBy=default.target`))
 )
 
 // ToIgn3_5Unvalidated translates the config to an Ignition config. It also returns the set of translations
@@ -81,52 +223,320 @@ RequiredBy=local-fs.target
 func (c Config) ToIgn3_5Unvalidated(options common.TranslateOptions) (types.Config, translate.TranslationSet, report.Report) {
 	ret := types.Config{}
 
-	tr := translate.NewTranslator("yaml", "json", options)
-	tr.AddCustomTranslator(translateIgnition)
-	tr.AddCustomTranslator(translateFile)
-	tr.AddCustomTranslator(translateDirectory)
-	tr.AddCustomTranslator(translateLink)
-	tr.AddCustomTranslator(translateResource)
-	tr.AddCustomTranslator(translatePasswdUser)
-	tr.AddCustomTranslator(translateUnit)
+	if options.PathPrefix != "" && !slashpath.IsAbs(options.PathPrefix) {
+		var r report.Report
+		r.AddOnError(path.New("yaml"), common.ErrPathPrefixNotAbsolute)
+		return ret, translate.TranslationSet{}, r
+	}
+
+	if options.MountStyle != "" && options.MountStyle != "units" && options.MountStyle != "fstab" {
+		var r report.Report
+		r.AddOnError(path.New("yaml"), common.ErrUnknownMountStyle)
+		return ret, translate.TranslationSet{}, r
+	}
+
+	if !generatedUnitPrefixRe.MatchString(options.GeneratedUnitPrefix) {
+		var r report.Report
+		r.AddOnError(path.New("yaml"), common.ErrGeneratedUnitPrefix)
+		return ret, translate.TranslationSet{}, r
+	}
+	for _, pattern := range options.SecretPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			var r report.Report
+			r.AddOnError(path.New("yaml"), fmt.Errorf("%w: %v", common.ErrSecretPattern, err))
+			return ret, translate.TranslationSet{}, r
+		}
+	}
+	if options.MaxDataURLSize < 0 || (options.MaxDataURLSize > 0 && dataURLChunkSize(options.MaxDataURLSize) <= 0) {
+		var r report.Report
+		r.AddOnError(path.New("yaml"), common.ErrMaxDataURLSizeTooSmall)
+		return ret, translate.TranslationSet{}, r
+	}
+
+	var normalizeReport report.Report
+	if options.DevicePathForm != "" {
+		c, normalizeReport = c.normalizeDevicePaths(options.DevicePathForm)
+		if normalizeReport.IsFatal() {
+			return ret, translate.TranslationSet{}, normalizeReport
+		}
+	}
+
+	// shared by all resources and trees in this config
+	options = options.TrackEmbeddedBytes()
 
+	tr := newTranslator(options)
 	tm, r := translate.Prefixed(tr, "ignition", &c.Ignition, &ret.Ignition)
+	r.Merge(normalizeReport)
 	tm.AddTranslation(path.New("yaml", "version"), path.New("json", "ignition", "version"))
 	tm.AddTranslation(path.New("yaml", "ignition"), path.New("json", "ignition"))
 	translate.MergeP2(tr, tm, &r, "kernel_arguments", &c.KernelArguments, "kernelArguments", &ret.KernelArguments)
 	translate.MergeP(tr, tm, &r, "passwd", &c.Passwd, &ret.Passwd)
-	translate.MergeP(tr, tm, &r, "storage", &c.Storage, &ret.Storage)
-	translate.MergeP(tr, tm, &r, "systemd", &c.Systemd, &ret.Systemd)
+	storage := c.Storage
+	if options.SkipMissingLocalFiles {
+		// translated separately below
+		storage.Files = nil
+	}
+	translate.MergeP(tr, tm, &r, "storage", &storage, &ret.Storage)
+	if options.SkipMissingLocalFiles {
+		translateFilesSkippingMissing(tr, c.Storage.Files, &ret.Storage.Files, tm, &r, options)
+	}
+	systemd := c.Systemd
+	if options.SkipMissingLocalFiles {
+		// translated separately below
+		systemd.Units = nil
+	}
+	translate.MergeP(tr, tm, &r, "systemd", &systemd, &ret.Systemd)
+	if options.SkipMissingLocalFiles {
+		translateUnitsSkippingMissing(tr, c.Systemd.Units, &ret.Systemd.Units, tm, &r)
+	}
+	c.applyPresets(&ret, &tm)
 
-	c.addMountUnits(&ret, &tm)
+	r.Merge(c.addMountUnits(&ret, &tm, options))
+	r.Merge(c.addTimerUnits(&ret, &tm, options))
+	r.Merge(c.addNetworkFiles(&ret, &tm, options))
+	r.Merge(c.addContainerFiles(&ret, &tm, options))
+	r.Merge(c.addExtensions(&ret, &tm, options))
+	r.Merge(c.addBannerFiles(&ret, &tm, options))
+
+	// before trees, so tree nodes are checked against the expanded directories
+	tm2, r2 := c.addRecursiveDirs(&ret)
+	tm.Merge(tm2)
+	r.Merge(r2)
 
-	tm2, r2 := c.processTrees(&ret, options)
+	tm2, r2, mtimes := c.processTrees(&ret, options)
 	tm.Merge(tm2)
 	r.Merge(r2)
 
+	tm.Merge(c.addParentDirs(&ret))
+	// after trees and parent directories, so only nodes without an owner
+	// from their entry or tree are affected
+	tm.Merge(c.applyDefaultOwner(&ret, tm))
+
+	if options.RequireExplicitModes {
+		r.Merge(checkExplicitModes(ret, tm))
+	}
+	if options.WarnReadOnlyMounts {
+		r.Merge(checkReadOnlyMounts(ret))
+	}
+	if options.WarnSharedCredentials {
+		r.Merge(checkSharedCredentials(ret.Passwd))
+	}
+	if options.WarnUnknownDropinParents {
+		r.Merge(checkDropinParents(ret, options.KnownUnits))
+	}
+	if options.WarnDanglingLinks {
+		r.Merge(checkLinkTargets(ret, options.KnownPaths))
+	}
+	if options.CheckUnitContents {
+		r.Merge(checkUnitContents(ret, tm, options.FailOnUnitContents))
+	}
+
+	// after trees, so conflicts are detected against the original paths
+	r.Merge(RewriteNodePaths(&ret, tm, options))
+	// after rewriting, so the unit touches the paths Ignition writes
+	if len(mtimes) > 0 {
+		addTreeMtimesUnit(&ret, &tm, &r, mtimes, options)
+	}
+
+	// contents written to the resource store after validation aren't
+	// embedded, so there's nothing to split
+	if options.MaxDataURLSize > 0 && options.ResourceStoreDir == "" && !r.IsFatal() {
+		r.Merge(splitDataURLs(&ret, &tm, options))
+	}
+	// last, so units added by every step are included
+	if options.SortUnits && !r.IsFatal() {
+		tm = sortUnits(&ret, tm)
+	}
+
 	if r.IsFatal() {
 		return types.Config{}, translate.TranslationSet{}, r
 	}
 	return ret, tm, r
 }
 
+// newTranslator returns a translator for the sections of a config.
+func newTranslator(options common.TranslateOptions) translate.Translator {
+	tr := translate.NewTranslator("yaml", "json", options)
+	tr.AddCustomTranslator(translateIgnition)
+	tr.AddCustomTranslator(translateFile)
+	tr.AddCustomTranslator(translateDirectory)
+	tr.AddCustomTranslator(translateLink)
+	tr.AddCustomTranslator(translateResource)
+	tr.AddCustomTranslator(translatePasswdUser)
+	tr.AddCustomTranslator(translateUnit)
+	return tr
+}
+
+// TranslateIgnition translates the ignition section of a config on its
+// own.  Paths in the TranslationSet and report are relative to the
+// section, and the spec version is attributed to the section as a whole.
+func TranslateIgnition(from Ignition, options common.TranslateOptions) (to types.Ignition, tm translate.TranslationSet, r report.Report) {
+	tm, r = newTranslator(options.TrackEmbeddedBytes()).Translate(&from, &to)
+	tm.AddTranslation(path.New("yaml"), path.New("json", "version"))
+	return
+}
+
+// TranslatePasswd translates the passwd section of a config on its own.
+// Paths in the TranslationSet and report are relative to the section.
+func TranslatePasswd(from Passwd, options common.TranslateOptions) (to types.Passwd, tm translate.TranslationSet, r report.Report) {
+	tm, r = newTranslator(options.TrackEmbeddedBytes()).Translate(&from, &to)
+	return
+}
+
+// TranslateStorage translates the storage section of a config on its
+// own.  Nodes and units which ToIgn3_5Unvalidated derives from the
+// section, such as those for trees, mount units, and parent directories,
+// are not added.  Paths in the TranslationSet and report are relative
+// to the section.
+func TranslateStorage(from Storage, options common.TranslateOptions) (to types.Storage, tm translate.TranslationSet, r report.Report) {
+	tm, r = newTranslator(options.TrackEmbeddedBytes()).Translate(&from, &to)
+	return
+}
+
+// TranslateSystemd translates the systemd section of a config on its
+// own.  Units which ToIgn3_5Unvalidated derives from the section, such
+// as those for timers, are not added.  Paths in the TranslationSet and
+// report are relative to the section.
+func TranslateSystemd(from Systemd, options common.TranslateOptions) (to types.Systemd, tm translate.TranslationSet, r report.Report) {
+	tm, r = newTranslator(options.TrackEmbeddedBytes()).Translate(&from, &to)
+	return
+}
+
 func translateIgnition(from Ignition, options common.TranslateOptions) (to types.Ignition, tm translate.TranslationSet, r report.Report) {
 	tr := translate.NewTranslator("yaml", "json", options)
 	tr.AddCustomTranslator(translateResource)
 	to.Version = types.MaxVersion.String()
 	tm, r = translate.Prefixed(tr, "config", &from.Config, &to.Config)
+	translateConfigTrees(from.Config.MergeTrees, &to.Config.Merge, tm, &r, options)
 	translate.MergeP(tr, tm, &r, "proxy", &from.Proxy, &to.Proxy)
 	translate.MergeP(tr, tm, &r, "security", &from.Security, &to.Security)
 	translate.MergeP(tr, tm, &r, "timeouts", &from.Timeouts, &to.Timeouts)
 	return
 }
 
+// translateConfigTrees appends the configs in each directory in trees to
+// the config merge list to, in sorted order.  Ignition configs (*.ign)
+// are embedded as-is and Butane configs (*.bu) are translated first.
+func translateConfigTrees(trees []ConfigTree, to *[]types.Resource, tm translate.TranslationSet, r *report.Report, options common.TranslateOptions) {
+	if len(trees) == 0 {
+		return
+	}
+	fsys, err := baseutil.LocalFS(options)
+	if err != nil {
+		r.AddOnError(path.New("yaml", "config", "merge_trees", 0), err)
+		return
+	}
+	added := false
+	for i, tree := range trees {
+		c := path.New("yaml", "config", "merge_trees", i)
+		dir, err := baseutil.LocalFSPath(tree.Local)
+		if err != nil {
+			r.AddOnError(c, err)
+			continue
+		}
+		entries, err := fs.ReadDir(fsys, dir)
+		if err != nil {
+			r.AddOnError(c, err)
+			continue
+		}
+		for _, entry := range entries {
+			name := slashpath.Join(dir, entry.Name())
+			contents, err := readConfigTreeFile(fsys, name, entry, c, r, options)
+			if err != nil {
+				r.AddOnError(c, fmt.Errorf("%s: %w", name, err))
+				continue
+			}
+			if contents == nil {
+				// errors already reported
+				continue
+			}
+			if err := options.AddEmbeddedBytes(len(contents)); err != nil {
+				r.AddOnError(c, err)
+				return
+			}
+			src, compression, err := makeDataURL(contents, nil, options)
+			if err != nil {
+				r.AddOnError(c, err)
+				continue
+			}
+			jsonPath := path.New("json", "config", "merge", len(*to))
+			*to = append(*to, types.Resource{
+				Source:      &src,
+				Compression: compression,
+			})
+			tm.AddTranslation(c, jsonPath)
+			tm.AddTranslation(c, jsonPath.Append("source"))
+			if compression != nil {
+				tm.AddTranslation(c, jsonPath.Append("compression"))
+			}
+			added = true
+		}
+	}
+	if added {
+		tm.AddTranslation(path.New("yaml", "config", "merge_trees"), path.New("json", "config", "merge"))
+	}
+}
+
+// readConfigTreeFile returns the Ignition config for the file name in a
+// config tree.  If a Butane config fails to translate, its report is
+// added to r at c and nil is returned.
+func readConfigTreeFile(fsys fs.FS, name string, entry fs.DirEntry, c path.ContextPath, r *report.Report, options common.TranslateOptions) ([]byte, error) {
+	if !entry.Type().IsRegular() {
+		return nil, common.ErrConfigTreeFileType
+	}
+	switch slashpath.Ext(name) {
+	case ".ign":
+		contents, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		var header struct {
+			Ignition struct {
+				Version string `json:"version"`
+			} `json:"ignition"`
+		}
+		if err := json.Unmarshal(contents, &header); err != nil || header.Ignition.Version == "" {
+			return nil, common.ErrConfigTreeNotIgnition
+		}
+		return contents, nil
+	case ".bu":
+		if options.TranslateButaneFragment == nil {
+			return nil, common.ErrConfigTreeButaneUnsupported
+		}
+		contents, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		out, fragmentReport, err := options.TranslateButaneFragment(contents)
+		for _, e := range fragmentReport.Entries {
+			r.Entries = append(r.Entries, report.Entry{
+				Kind:    e.Kind,
+				Message: fmt.Sprintf("%s at %s: %s", name, e.Context, e.Message),
+				Context: c,
+			})
+		}
+		if err != nil {
+			if fragmentReport.IsFatal() {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return out, nil
+	default:
+		return nil, common.ErrConfigTreeFileType
+	}
+}
+
 func translateFile(from File, options common.TranslateOptions) (to types.File, tm translate.TranslationSet, r report.Report) {
 	tr := translate.NewTranslator("yaml", "json", options)
-	tr.AddCustomTranslator(translateResource)
+	tr.AddCustomTranslator(translateFileResource)
 	tm, r = translate.Prefixed(tr, "group", &from.Group, &to.Group)
 	translate.MergeP(tr, tm, &r, "user", &from.User, &to.User)
-	translate.MergeP(tr, tm, &r, "append", &from.Append, &to.Append)
+	if options.SkipMissingLocalFiles || options.MergeInlineAppends {
+		translateAppends(tr, from.Append, &to.Append, tm, &r, options)
+	} else {
+		translate.MergeP(tr, tm, &r, "append", &from.Append, &to.Append)
+	}
 	translate.MergeP(tr, tm, &r, "contents", &from.Contents, &to.Contents)
 	translate.MergeP(tr, tm, &r, "overwrite", &from.Overwrite, &to.Overwrite)
 	translate.MergeP(tr, tm, &r, "path", &from.Path, &to.Path)
@@ -134,78 +544,433 @@ func translateFile(from File, options common.TranslateOptions) (to types.File, t
 	return
 }
 
-func translateResource(from Resource, options common.TranslateOptions) (to types.Resource, tm translate.TranslationSet, r report.Report) {
+// translateFilesSkippingMissing translates storage.files, omitting files
+// whose local contents were skipped by translateFileResource because they
+// don't exist.  Translations are recorded against the original index of
+// each file.
+func translateFilesSkippingMissing(tr translate.Translator, from []File, to *[]types.File, tm translate.TranslationSet, r *report.Report, options common.TranslateOptions) {
+	for i := range from {
+		yamlPath := path.New("yaml", "storage", "files", i)
+		var file types.File
+		translations, translationReport := tr.Translate(&from[i], &file)
+		r.Merge(prefixReportPath(translationReport, yamlPath))
+		if from[i].Contents.Local != nil && file.Contents.Source == nil {
+			continue
+		}
+		tm.Merge(translations.PrefixPaths(yamlPath, path.New("json", "storage", "files", len(*to))))
+		*to = append(*to, file)
+	}
+	if len(*to) > 0 {
+		tm.AddTranslation(path.New("yaml", "storage", "files"), path.New("json", "storage", "files"))
+		tm.AddTranslation(path.New("yaml", "storage"), path.New("json", "storage"))
+	}
+}
+
+// translateAppends translates the append entries of a file.  With
+// SkipMissingLocalFiles, it is the equivalent of
+// translateFilesSkippingMissing.  With MergeInlineAppends, consecutive
+// entries with only inline contents are concatenated into the first of
+// them, joined by InlineAppendSeparator.
+func translateAppends(tr translate.Translator, from []Resource, to *[]types.Resource, tm translate.TranslationSet, r *report.Report, options common.TranslateOptions) {
+	for i := 0; i < len(from); i++ {
+		yamlPath := path.New("yaml", "append", i)
+		res := from[i]
+		if options.MergeInlineAppends && isPlainInline(res) {
+			var contents strings.Builder
+			contents.WriteString(*res.Inline)
+			for i+1 < len(from) && isPlainInline(from[i+1]) {
+				i++
+				contents.WriteString(options.InlineAppendSeparator)
+				contents.WriteString(*from[i].Inline)
+			}
+			res.Inline = util.StrToPtr(contents.String())
+		}
+		var resource types.Resource
+		translations, translationReport := tr.Translate(&res, &resource)
+		r.Merge(prefixReportPath(translationReport, yamlPath))
+		if res.Local != nil && resource.Source == nil {
+			// skipped
+			continue
+		}
+		tm.Merge(translations.PrefixPaths(yamlPath, path.New("json", "append", len(*to))))
+		*to = append(*to, resource)
+	}
+	if len(*to) > 0 {
+		tm.AddTranslation(path.New("yaml", "append"), path.New("json", "append"))
+	}
+}
+
+// translateUnitsSkippingMissing translates systemd.units, omitting units
+// whose local contents were skipped by translateUnit because they don't
+// exist.  Translations are recorded against the original index of each
+// unit.
+func translateUnitsSkippingMissing(tr translate.Translator, from []Unit, to *[]types.Unit, tm translate.TranslationSet, r *report.Report) {
+	for i := range from {
+		yamlPath := path.New("yaml", "systemd", "units", i)
+		var unit types.Unit
+		translations, translationReport := tr.Translate(&from[i], &unit)
+		r.Merge(prefixReportPath(translationReport, yamlPath))
+		if util.NotEmpty(from[i].ContentsLocal) && unit.Contents == nil {
+			continue
+		}
+		tm.Merge(translations.PrefixPaths(yamlPath, path.New("json", "systemd", "units", len(*to))))
+		*to = append(*to, unit)
+	}
+	if len(*to) > 0 {
+		tm.AddTranslation(path.New("yaml", "systemd", "units"), path.New("json", "systemd", "units"))
+		tm.AddTranslation(path.New("yaml", "systemd"), path.New("json", "systemd"))
+	}
+}
+
+// skipMissingLocal returns true, after warning at c, if skip is set and
+// err shows that a local file or directory doesn't exist.  The caller
+// should then leave out the entry which referred to it.
+func skipMissingLocal(r *report.Report, c path.ContextPath, err error, skip bool) bool {
+	if skip && errors.Is(err, fs.ErrNotExist) {
+		r.AddOnWarn(c, common.ErrLocalFileSkipped)
+		return true
+	}
+	return false
+}
+
+// isPlainInline returns true if res has inline contents and no fields
+// which would prevent concatenating it with another inline resource.
+func isPlainInline(res Resource) bool {
+	return res.Inline != nil && res.Source == nil && res.Local == nil && res.Git == nil && res.Exec == nil && res.OCI == nil &&
+		res.Compression == nil && res.Verification.Hash == nil && len(res.HTTPHeaders) == 0 &&
+		res.LineEndings == nil && res.AddBOM == nil
+}
+
+// prefixReportPath returns a copy of the report with its context paths
+// reparented under prefix.
+func prefixReportPath(r report.Report, prefix path.ContextPath) report.Report {
+	var ret report.Report
+	ret.Merge(r)
+	for i := range ret.Entries {
+		ret.Entries[i].Context = prefix.Append(ret.Entries[i].Context.Path...)
+	}
+	return ret
+}
+
+func translateResource(from Resource, options common.TranslateOptions) (types.Resource, translate.TranslationSet, report.Report) {
+	return translateResourceSkipping(from, options, false)
+}
+
+// translateFileResource translates the contents and appends of files.
+// With SkipMissingLocalFiles, a missing local file is reported as a
+// warning and the resource is left without a source, so that the caller
+// can omit it.
+func translateFileResource(from Resource, options common.TranslateOptions) (types.Resource, translate.TranslationSet, report.Report) {
+	return translateResourceSkipping(from, options, options.SkipMissingLocalFiles)
+}
+
+func translateResourceSkipping(from Resource, options common.TranslateOptions, skipMissing bool) (to types.Resource, tm translate.TranslationSet, r report.Report) {
 	tr := translate.NewTranslator("yaml", "json", options)
 	tm, r = translate.Prefixed(tr, "verification", &from.Verification, &to.Verification)
 	translate.MergeP2(tr, tm, &r, "http_headers", &from.HTTPHeaders, "httpHeaders", &to.HTTPHeaders)
 	translate.MergeP(tr, tm, &r, "source", &from.Source, &to.Source)
 	translate.MergeP(tr, tm, &r, "compression", &from.Compression, &to.Compression)
 
+	if from.Source != nil && from.Local == nil && from.Inline == nil && from.Git == nil && from.Exec == nil && from.OCI == nil && util.NotEmpty(from.Compression) {
+		r.AddOnWarn(path.New("yaml", "compression"), common.ErrCompressionRemote)
+	}
+
 	if from.Local != nil {
 		c := path.New("yaml", "local")
-		contents, err := baseutil.ReadLocalFile(*from.Local, options.FilesDir)
+		contents, err := baseutil.ReadLocalFSFile(*from.Local, options)
+		if skipMissingLocal(&r, c, err, skipMissing) {
+			return
+		}
 		if err != nil {
 			r.AddOnError(c, err)
 			return
 		}
-		src, compression, err := baseutil.MakeDataURL(contents, to.Compression, !options.NoResourceAutoCompression)
+		embedContents(&to, tm, &r, c, contents, from, options)
+	}
+
+	if from.Git != nil {
+		c := path.New("yaml", "git")
+		if !options.AllowGit {
+			r.AddOnError(c, common.ErrGitNotAllowed)
+			return
+		}
+		ref := "HEAD"
+		if util.NotEmpty(from.Git.Ref) {
+			ref = *from.Git.Ref
+		}
+		contents, err := baseutil.ReadGitFile(options.Context(), from.Git.URL, ref, from.Git.Path)
 		if err != nil {
 			r.AddOnError(c, err)
 			return
 		}
-		to.Source = &src
-		tm.AddTranslation(c, path.New("json", "source"))
-		if compression != nil {
-			to.Compression = compression
-			tm.AddTranslation(c, path.New("json", "compression"))
-		}
+		embedContents(&to, tm, &r, c, contents, from, options)
 	}
 
-	if from.Inline != nil {
-		c := path.New("yaml", "inline")
+	if from.Exec != nil {
+		c := path.New("yaml", "exec")
+		if !options.AllowExec {
+			r.AddOnError(c, common.ErrExecNotAllowed)
+			return
+		}
+		command, err := baseutil.ResolveCommand(from.Exec.Command, options.FilesDir)
+		if err != nil {
+			r.AddOnError(c.Append("command"), err)
+			return
+		}
+		if !execCommandAllowed(command, options) {
+			r.AddOnError(c.Append("command"), common.ErrExecCommandNotAllowed)
+			return
+		}
+		contents, err := baseutil.RunCommand(options.Context(), command, from.Exec.Args, options.FilesDir)
+		if err != nil {
+			r.AddOnError(c, err)
+			return
+		}
+		embedContents(&to, tm, &r, c, contents, from, options)
+	}
 
-		src, compression, err := baseutil.MakeDataURL([]byte(*from.Inline), to.Compression, !options.NoResourceAutoCompression)
+	if from.OCI != nil {
+		c := path.New("yaml", "oci")
+		if !options.AllowOCI {
+			r.AddOnError(c, common.ErrOCINotAllowed)
+			return
+		}
+		var digest string
+		if from.OCI.Digest != nil {
+			digest = *from.OCI.Digest
+		}
+		contents, err := baseutil.ReadOCIBlob(options.Context(), from.OCI.Ref, digest, int64(options.EmbeddedBytesRemaining()))
 		if err != nil {
 			r.AddOnError(c, err)
 			return
 		}
-		to.Source = &src
-		tm.AddTranslation(c, path.New("json", "source"))
-		if compression != nil {
-			to.Compression = compression
-			tm.AddTranslation(c, path.New("json", "compression"))
+		embedContents(&to, tm, &r, c, contents, from, options)
+	}
+
+	if from.Inline != nil {
+		c := path.New("yaml", "inline")
+		if options.InlineWarnBytes > 0 && len(*from.Inline) > options.InlineWarnBytes {
+			r.AddOnWarn(c, common.ErrInlineTooLarge)
 		}
+		embedContents(&to, tm, &r, c, []byte(*from.Inline), from, options)
 	}
-	return
-}
 
-func translateDirectory(from Directory, options common.TranslateOptions) (to types.Directory, tm translate.TranslationSet, r report.Report) {
-	tr := translate.NewTranslator("yaml", "json", options)
-	tm, r = translate.Prefixed(tr, "group", &from.Group, &to.Group)
-	translate.MergeP(tr, tm, &r, "user", &from.User, &to.User)
-	translate.MergeP(tr, tm, &r, "overwrite", &from.Overwrite, &to.Overwrite)
-	translate.MergeP(tr, tm, &r, "path", &from.Path, &to.Path)
-	translate.MergeP(tr, tm, &r, "mode", &from.Mode, &to.Mode)
+	if options.WarnRedundantCompression && from.Compression != nil && from.Source == nil && to.Source != nil {
+		if err := checkRedundantCompression(to, *from.Compression, options); err != nil {
+			r.AddOnWarn(path.New("yaml", "compression"), err)
+		}
+	}
 	return
 }
 
-func translateLink(from Link, options common.TranslateOptions) (to types.Link, tm translate.TranslationSet, r report.Report) {
-	tr := translate.NewTranslator("yaml", "json", options)
-	tm, r = translate.Prefixed(tr, "group", &from.Group, &to.Group)
-	translate.MergeP(tr, tm, &r, "user", &from.User, &to.User)
-	translate.MergeP(tr, tm, &r, "target", &from.Target, &to.Target)
-	translate.MergeP(tr, tm, &r, "hard", &from.Hard, &to.Hard)
-	translate.MergeP(tr, tm, &r, "overwrite", &from.Overwrite, &to.Overwrite)
-	translate.MergeP(tr, tm, &r, "path", &from.Path, &to.Path)
-	return
+// embedContents sets the source of to to a data URL of contents, read
+// from the field of from at c, after applying the text transformations
+// of from and checking them against its verification hash, the embedded
+// size limit, and secret detection.  Problems are reported at c, except
+// for hash mismatches, which are reported at the hash.
+func embedContents(to *types.Resource, tm translate.TranslationSet, r *report.Report, c path.ContextPath, contents []byte, from Resource, options common.TranslateOptions) {
+	contents, err := transformText(contents, from)
+	if err != nil {
+		r.AddOnError(c, err)
+		return
+	}
+	if from.Verification.Hash != nil {
+		if err := checkHash(contents, *from.Verification.Hash); err != nil {
+			r.AddOnError(path.New("yaml", "verification", "hash"), err)
+			return
+		}
+	}
+	if err := options.AddEmbeddedBytes(len(contents)); err != nil {
+		r.AddOnError(c, err)
+		return
+	}
+	checkSecrets(r, c, contents, options)
+	src, compression, err := makeDataURL(contents, to.Compression, options)
+	if err != nil {
+		r.AddOnError(c, err)
+		return
+	}
+	to.Source = &src
+	tm.AddTranslation(c, path.New("json", "source"))
+	if compression != nil {
+		to.Compression = compression
+		tm.AddTranslation(c, path.New("json", "compression"))
+	}
 }
 
-func translatePasswdUser(from PasswdUser, options common.TranslateOptions) (to types.PasswdUser, tm translate.TranslationSet, r report.Report) {
-	tr := translate.NewTranslator("yaml", "json", options)
-	tm, r = translate.Prefixed(tr, "gecos", &from.Gecos, &to.Gecos)
-	translate.MergeP(tr, tm, &r, "groups", &from.Groups, &to.Groups)
-	translate.MergeP2(tr, tm, &r, "home_dir", &from.HomeDir, "homeDir", &to.HomeDir)
-	translate.MergeP(tr, tm, &r, "name", &from.Name, &to.Name)
+// checkSecrets reports the likely plaintext secrets in contents at c, if
+// secret detection is enabled.
+func checkSecrets(r *report.Report, c path.ContextPath, contents []byte, options common.TranslateOptions) {
+	if !options.DetectSecrets {
+		return
+	}
+	findings, err := baseutil.FindSecrets(contents, options.SecretPatterns)
+	if err != nil {
+		r.AddOnError(c, err)
+		return
+	}
+	for _, finding := range findings {
+		err := fmt.Errorf("%w: %s", common.ErrSecretDetected, finding)
+		if options.FailOnSecrets {
+			r.AddOnError(c, err)
+		} else {
+			r.AddOnWarn(c, err)
+		}
+	}
+}
+
+// checkRedundantCompression returns an error if compression, the explicit
+// compression of the embedded contents of res, matches the compression
+// Butane would choose for the uncompressed contents.
+func checkRedundantCompression(res types.Resource, compression string, options common.TranslateOptions) error {
+	if compression != "" && compression != "gzip" {
+		return nil
+	}
+	decoded, err := dataurl.DecodeString(*res.Source)
+	if err != nil {
+		return nil
+	}
+	contents := decoded.Data
+	if util.NotEmpty(res.Compression) && *res.Compression == "gzip" {
+		// malformed contents are reported by VerifyCompression or
+		// Ignition
+		if contents, err = baseutil.GunzipBytes(contents); err != nil {
+			return nil
+		}
+	}
+	_, auto, err := encodeDataURL(contents, nil, options)
+	if err != nil || auto == nil || *auto != compression {
+		return nil
+	}
+	if compression == "gzip" {
+		return common.ErrCompressionRedundantGzip
+	}
+	return common.ErrCompressionRedundant
+}
+
+// transformText applies the line_endings and add_bom settings of res to
+// contents, which must be UTF-8 text if either is set.
+func transformText(contents []byte, res Resource) ([]byte, error) {
+	if res.LineEndings == nil && !util.IsTrue(res.AddBOM) {
+		return contents, nil
+	}
+	if !utf8.Valid(contents) {
+		return nil, common.ErrTextTransformBinary
+	}
+	if res.LineEndings != nil {
+		contents = bytes.ReplaceAll(contents, []byte("\r\n"), []byte("\n"))
+		if *res.LineEndings == "crlf" {
+			contents = bytes.ReplaceAll(contents, []byte("\n"), []byte("\r\n"))
+		}
+	}
+	if util.IsTrue(res.AddBOM) && !bytes.HasPrefix(contents, utf8BOM) {
+		contents = append(append([]byte(nil), utf8BOM...), contents...)
+	}
+	return contents, nil
+}
+
+// checkHash returns an error if hash, in the Ignition verification
+// format, doesn't match contents.  Malformed hashes are left to
+// validation.
+func checkHash(contents []byte, hash string) error {
+	function, sum, _ := strings.Cut(hash, "-")
+	var actual []byte
+	switch function {
+	case "sha256":
+		digest := sha256.Sum256(contents)
+		actual = digest[:]
+	case "sha512":
+		digest := sha512.Sum512(contents)
+		actual = digest[:]
+	default:
+		return nil
+	}
+	expected, err := hex.DecodeString(sum)
+	if err != nil || len(expected) != len(actual) {
+		return nil
+	}
+	if !bytes.Equal(expected, actual) {
+		return fmt.Errorf("%w: expected %s, found %s-%s", common.ErrHashMismatch, hash, function, hex.EncodeToString(actual))
+	}
+	return nil
+}
+
+// execCommandAllowed returns true if command, a path returned by
+// baseutil.ResolveCommand, is the executable named by an entry of
+// options.AllowedExecCommands.  Entries are resolved against the current
+// directory and PATH, so an allowed name can't be satisfied by a
+// different executable found via the files directory or later in PATH.
+func execCommandAllowed(command string, options common.TranslateOptions) bool {
+	for _, allowed := range options.AllowedExecCommands {
+		if resolved, err := baseutil.ResolveCommand(allowed, ""); err == nil && resolved == command {
+			return true
+		}
+	}
+	return false
+}
+
+// makeDataURL is like baseutil.MakeDataURL, but prefers a readable
+// encoding if enabled in options, lets options.CompressionPolicy decide
+// whether to compress, and checks the result if options.VerifyCompression
+// is set.
+func makeDataURL(contents []byte, currentCompression *string, options common.TranslateOptions) (string, *string, error) {
+	uri, compression, err := encodeDataURL(contents, currentCompression, options)
+	if err != nil {
+		return "", nil, err
+	}
+	if options.VerifyCompression {
+		// if the contents were already compressed by the user,
+		// compression is nil and we only check the encoding
+		if err := baseutil.VerifyDataURL(uri, compression, contents); err != nil {
+			return "", nil, err
+		}
+	}
+	return uri, compression, nil
+}
+
+func encodeDataURL(contents []byte, currentCompression *string, options common.TranslateOptions) (string, *string, error) {
+	if options.ReadableDataURLs && util.NilOrEmpty(currentCompression) {
+		if uri, ok := baseutil.MakeReadableDataURL(contents); ok {
+			return uri, util.StrToPtr(""), nil
+		}
+	}
+	if options.CompressionPolicy != nil && util.NilOrEmpty(currentCompression) && !options.NoResourceAutoCompression {
+		if options.CompressionPolicy(len(contents), http.DetectContentType(contents)) {
+			uri, err := baseutil.MakeGzipDataURL(contents)
+			return uri, util.StrToPtr("gzip"), err
+		}
+		return baseutil.MakeDataURL(contents, currentCompression, false)
+	}
+	return baseutil.MakeDataURL(contents, currentCompression, !options.NoResourceAutoCompression)
+}
+
+func translateDirectory(from Directory, options common.TranslateOptions) (to types.Directory, tm translate.TranslationSet, r report.Report) {
+	tr := translate.NewTranslator("yaml", "json", options)
+	tm, r = translate.Prefixed(tr, "group", &from.Group, &to.Group)
+	translate.MergeP(tr, tm, &r, "user", &from.User, &to.User)
+	translate.MergeP(tr, tm, &r, "overwrite", &from.Overwrite, &to.Overwrite)
+	translate.MergeP(tr, tm, &r, "path", &from.Path, &to.Path)
+	translate.MergeP(tr, tm, &r, "mode", &from.Mode, &to.Mode)
+	return
+}
+
+func translateLink(from Link, options common.TranslateOptions) (to types.Link, tm translate.TranslationSet, r report.Report) {
+	tr := translate.NewTranslator("yaml", "json", options)
+	tm, r = translate.Prefixed(tr, "group", &from.Group, &to.Group)
+	translate.MergeP(tr, tm, &r, "user", &from.User, &to.User)
+	translate.MergeP(tr, tm, &r, "target", &from.Target, &to.Target)
+	translate.MergeP(tr, tm, &r, "hard", &from.Hard, &to.Hard)
+	translate.MergeP(tr, tm, &r, "overwrite", &from.Overwrite, &to.Overwrite)
+	translate.MergeP(tr, tm, &r, "path", &from.Path, &to.Path)
+	return
+}
+
+func translatePasswdUser(from PasswdUser, options common.TranslateOptions) (to types.PasswdUser, tm translate.TranslationSet, r report.Report) {
+	tr := translate.NewTranslator("yaml", "json", options)
+	tm, r = translate.Prefixed(tr, "gecos", &from.Gecos, &to.Gecos)
+	translate.MergeP(tr, tm, &r, "groups", &from.Groups, &to.Groups)
+	translate.MergeP2(tr, tm, &r, "home_dir", &from.HomeDir, "homeDir", &to.HomeDir)
+	translate.MergeP(tr, tm, &r, "name", &from.Name, &to.Name)
 	translate.MergeP2(tr, tm, &r, "no_create_home", &from.NoCreateHome, "noCreateHome", &to.NoCreateHome)
 	translate.MergeP2(tr, tm, &r, "no_log_init", &from.NoLogInit, "noLogInit", &to.NoLogInit)
 	translate.MergeP2(tr, tm, &r, "no_user_group", &from.NoUserGroup, "noUserGroup", &to.NoUserGroup)
@@ -217,17 +982,46 @@ func translatePasswdUser(from PasswdUser, options common.TranslateOptions) (to t
 	translate.MergeP(tr, tm, &r, "system", &from.System, &to.System)
 	translate.MergeP(tr, tm, &r, "uid", &from.UID, &to.UID)
 
+	if from.PasswordHashLocal != nil {
+		c := path.New("yaml", "password_hash_local")
+		contents, err := baseutil.ReadLocalFSFile(*from.PasswordHashLocal, options)
+		if skipMissingLocal(&r, c, err, options.SkipMissingLocalFiles) {
+			// leave the password hash unset
+		} else if err != nil {
+			r.AddOnError(c, err)
+		} else if hash := strings.TrimSpace(string(contents)); hash == "" || strings.ContainsAny(hash, "\r\n") {
+			r.AddOnError(c, common.ErrPasswordHashLocalLines)
+		} else {
+			to.PasswordHash = &hash
+			tm.AddTranslation(c, path.New("json", "passwordHash"))
+		}
+	}
+	if options.DetectSecrets && util.NotEmpty(to.PasswordHash) && !baseutil.LooksLikePasswordHash(*to.PasswordHash) {
+		c := path.New("yaml", "password_hash")
+		if from.PasswordHashLocal != nil {
+			c = path.New("yaml", "password_hash_local")
+		}
+		if options.FailOnSecrets {
+			r.AddOnError(c, common.ErrPasswordHashPlaintext)
+		} else {
+			r.AddOnWarn(c, common.ErrPasswordHashPlaintext)
+		}
+	}
+
 	if len(from.SSHAuthorizedKeysLocal) > 0 {
 		c := path.New("yaml", "ssh_authorized_keys_local")
 		tm.AddTranslation(c, path.New("json", "sshAuthorizedKeys"))
 
-		if options.FilesDir == "" {
-			r.AddOnError(c, common.ErrNoFilesDir)
+		if _, err := baseutil.LocalFS(options); err != nil {
+			r.AddOnError(c, err)
 			return
 		}
 
 		for keyFileIndex, sshKeyFile := range from.SSHAuthorizedKeysLocal {
-			sshKeys, err := baseutil.ReadLocalFile(sshKeyFile, options.FilesDir)
+			sshKeys, err := baseutil.ReadLocalFSFile(sshKeyFile, options)
+			if skipMissingLocal(&r, c.Append(keyFileIndex), err, options.SkipMissingLocalFiles) {
+				continue
+			}
 			if err != nil {
 				r.AddOnError(c.Append(keyFileIndex), err)
 				continue
@@ -242,6 +1036,84 @@ func translatePasswdUser(from PasswdUser, options common.TranslateOptions) (to t
 		}
 	}
 
+	if options.WarnDuplicateSSHKeys || options.DedupeSSHKeys {
+		checkDuplicateSSHKeys(&to, tm, &r, options)
+	}
+	return
+}
+
+// checkDuplicateSSHKeys warns about SSH keys listed more than once for
+// user, at the path each repeat came from, and drops the repeats if
+// requested.
+func checkDuplicateSSHKeys(user *types.PasswdUser, tm translate.TranslationSet, r *report.Report, options common.TranslateOptions) {
+	seen := make(map[string]struct{})
+	var keys []types.SSHAuthorizedKey
+	var froms []path.ContextPath
+	for i, key := range user.SSHAuthorizedKeys {
+		keyPath := path.New("json", "sshAuthorizedKeys", i)
+		from := path.New("yaml")
+		if t, ok := tm.Lookup(keyPath); ok {
+			from = t.From
+		}
+		id := sshKeyIdentity(key)
+		if _, ok := seen[id]; ok {
+			if options.WarnDuplicateSSHKeys {
+				r.AddOnWarn(from, common.ErrSSHKeyDuplicate)
+			}
+			if options.DedupeSSHKeys {
+				continue
+			}
+		}
+		seen[id] = struct{}{}
+		keys = append(keys, key)
+		froms = append(froms, from)
+	}
+	if len(keys) == len(user.SSHAuthorizedKeys) {
+		return
+	}
+	for i := range user.SSHAuthorizedKeys {
+		delete(tm.Set, path.New("json", "sshAuthorizedKeys", i).String())
+	}
+	for i, from := range froms {
+		tm.AddTranslation(from, path.New("json", "sshAuthorizedKeys", i))
+	}
+	user.SSHAuthorizedKeys = keys
+}
+
+// sshKeyIdentity returns the key type and data of an authorized key,
+// ignoring any options and comment, so that copies of a key with
+// different comments compare equal.
+func sshKeyIdentity(key types.SSHAuthorizedKey) string {
+	fields := strings.Fields(string(key))
+	for i := 0; i+1 < len(fields); i++ {
+		if strings.HasPrefix(fields[i], "ssh-") || strings.HasPrefix(fields[i], "ecdsa-") || strings.HasPrefix(fields[i], "sk-") {
+			return fields[i] + " " + fields[i+1]
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// checkSharedCredentials warns about SSH keys and password hashes used
+// by more than one user, at each user after the first.
+func checkSharedCredentials(passwd types.Passwd) (r report.Report) {
+	keyUsers := make(map[string]int)
+	hashes := make(map[string]struct{})
+	for i, user := range passwd.Users {
+		for j, key := range user.SSHAuthorizedKeys {
+			id := sshKeyIdentity(key)
+			if first, ok := keyUsers[id]; !ok {
+				keyUsers[id] = i
+			} else if first != i {
+				r.AddOnWarn(path.New("json", "passwd", "users", i, "sshAuthorizedKeys", j), common.ErrSSHKeyShared)
+			}
+		}
+		if util.NotEmpty(user.PasswordHash) {
+			if _, ok := hashes[*user.PasswordHash]; ok {
+				r.AddOnWarn(path.New("json", "passwd", "users", i, "passwordHash"), common.ErrPasswordHashShared)
+			}
+			hashes[*user.PasswordHash] = struct{}{}
+		}
+	}
 	return
 }
 
@@ -249,14 +1121,21 @@ func translateUnit(from Unit, options common.TranslateOptions) (to types.Unit, t
 	tr := translate.NewTranslator("yaml", "json", options)
 	tr.AddCustomTranslator(translateDropin)
 	tm, r = translate.Prefixed(tr, "contents", &from.Contents, &to.Contents)
-	translate.MergeP(tr, tm, &r, "dropins", &from.Dropins, &to.Dropins)
+	if options.SkipMissingLocalFiles {
+		translateDropinsSkippingMissing(tr, from.Dropins, &to.Dropins, tm, &r)
+	} else {
+		translate.MergeP(tr, tm, &r, "dropins", &from.Dropins, &to.Dropins)
+	}
 	translate.MergeP(tr, tm, &r, "enabled", &from.Enabled, &to.Enabled)
 	translate.MergeP(tr, tm, &r, "mask", &from.Mask, &to.Mask)
 	translate.MergeP(tr, tm, &r, "name", &from.Name, &to.Name)
 
 	if util.NotEmpty(from.ContentsLocal) {
 		c := path.New("yaml", "contents_local")
-		contents, err := baseutil.ReadLocalFile(*from.ContentsLocal, options.FilesDir)
+		contents, err := baseutil.ReadLocalFSFile(*from.ContentsLocal, options)
+		if skipMissingLocal(&r, c, err, options.SkipMissingLocalFiles) {
+			return
+		}
 		if err != nil {
 			r.AddOnError(c, err)
 			return
@@ -268,6 +1147,26 @@ func translateUnit(from Unit, options common.TranslateOptions) (to types.Unit, t
 	return
 }
 
+// translateDropinsSkippingMissing translates the dropins of a unit,
+// omitting dropins whose local contents were skipped because they don't
+// exist.
+func translateDropinsSkippingMissing(tr translate.Translator, from []Dropin, to *[]types.Dropin, tm translate.TranslationSet, r *report.Report) {
+	for i := range from {
+		yamlPath := path.New("yaml", "dropins", i)
+		var dropin types.Dropin
+		translations, translationReport := tr.Translate(&from[i], &dropin)
+		r.Merge(prefixReportPath(translationReport, yamlPath))
+		if util.NotEmpty(from[i].ContentsLocal) && dropin.Contents == nil {
+			continue
+		}
+		tm.Merge(translations.PrefixPaths(yamlPath, path.New("json", "dropins", len(*to))))
+		*to = append(*to, dropin)
+	}
+	if len(*to) > 0 {
+		tm.AddTranslation(path.New("yaml", "dropins"), path.New("json", "dropins"))
+	}
+}
+
 func translateDropin(from Dropin, options common.TranslateOptions) (to types.Dropin, tm translate.TranslationSet, r report.Report) {
 	tr := translate.NewTranslator("yaml", "json", options)
 	tm, r = translate.Prefixed(tr, "contents", &from.Contents, &to.Contents)
@@ -275,7 +1174,10 @@ func translateDropin(from Dropin, options common.TranslateOptions) (to types.Dro
 
 	if util.NotEmpty(from.ContentsLocal) {
 		c := path.New("yaml", "contents_local")
-		contents, err := baseutil.ReadLocalFile(*from.ContentsLocal, options.FilesDir)
+		contents, err := baseutil.ReadLocalFSFile(*from.ContentsLocal, options)
+		if skipMissingLocal(&r, c, err, options.SkipMissingLocalFiles) {
+			return
+		}
 		if err != nil {
 			r.AddOnError(c, err)
 			return
@@ -287,29 +1189,37 @@ func translateDropin(from Dropin, options common.TranslateOptions) (to types.Dro
 	return
 }
 
-func (c Config) processTrees(ret *types.Config, options common.TranslateOptions) (translate.TranslationSet, report.Report) {
+// processTrees adds the nodes of storage.trees to ret.  If
+// options.PreserveTreeMtimes is set, it also returns the modification
+// times of the files, by destination path.
+func (c Config) processTrees(ret *types.Config, options common.TranslateOptions) (translate.TranslationSet, report.Report, map[string]time.Time) {
 	ts := translate.NewTranslationSet("yaml", "json")
 	var r report.Report
 	if len(c.Storage.Trees) == 0 {
-		return ts, r
+		return ts, r, nil
 	}
 	t := newNodeTracker(ret)
+	fsys, err := baseutil.LocalFS(options)
+	if err != nil {
+		r.AddOnError(path.New("yaml", "storage", "trees", 0), err)
+		return ts, r, nil
+	}
 
+	var trees []resolvedTree
 	for i, tree := range c.Storage.Trees {
 		yamlPath := path.New("yaml", "storage", "trees", i)
-		if options.FilesDir == "" {
-			r.AddOnError(yamlPath, common.ErrNoFilesDir)
-			return ts, r
-		}
 
-		// calculate base path within FilesDir and check for
-		// path traversal
-		srcBaseDir := filepath.Join(options.FilesDir, filepath.FromSlash(tree.Local))
-		if err := baseutil.EnsurePathWithinFilesDir(srcBaseDir, options.FilesDir); err != nil {
+		// calculate base path within the files filesystem and check
+		// for path traversal
+		srcBaseDir, err := baseutil.LocalFSPath(tree.Local)
+		if err != nil {
 			r.AddOnError(yamlPath, err)
 			continue
 		}
-		info, err := os.Stat(srcBaseDir)
+		info, err := fs.Stat(fsys, srcBaseDir)
+		if skipMissingLocal(&r, yamlPath.Append("local"), err, options.SkipMissingLocalFiles) {
+			continue
+		}
 		if err != nil {
 			r.AddOnError(yamlPath, err)
 			continue
@@ -322,37 +1232,390 @@ func (c Config) processTrees(ret *types.Config, options common.TranslateOptions)
 		if util.NotEmpty(tree.Path) {
 			destBaseDir = *tree.Path
 		}
+		var modes map[string]int
+		if util.NotEmpty(tree.ModesFile) {
+			modes, err = readTreeModes(fsys, *tree.ModesFile)
+			if err != nil {
+				r.AddOnError(yamlPath.Append("modes_file"), err)
+				continue
+			}
+		}
+		trees = append(trees, resolvedTree{
+			yamlPath:    yamlPath,
+			srcBaseDir:  srcBaseDir,
+			destBaseDir: destBaseDir,
+			tree:        tree,
+			modes:       modes,
+		})
+	}
+
+	// Conflicts between trees would otherwise be found partway through
+	// the walk and reported as ErrNodeExists without naming the other
+	// tree.  Don't walk trees which conflict with an earlier one.
+	conflicting := checkTreeOverlaps(fsys, trees, &r)
 
-		walkTree(yamlPath, &ts, &r, t, srcBaseDir, destBaseDir, options)
+	var mtimes map[string]time.Time
+	if options.PreserveTreeMtimes {
+		mtimes = make(map[string]time.Time)
 	}
-	return ts, r
+	var links map[string]treeLink
+	if options.WarnTreeLinkCycles {
+		links = make(map[string]treeLink)
+	}
+	for k, rt := range trees {
+		if conflicting[k] {
+			continue
+		}
+		walkTree(rt.yamlPath, &ts, &r, t, fsys, rt.srcBaseDir, rt.destBaseDir, rt.tree, rt.modes, mtimes, links, options)
+	}
+	if len(links) > 0 {
+		checkTreeLinkCycles(&r, links)
+	}
+	return ts, r, mtimes
+}
+
+// treeLink is a symlink created from a tree.
+type treeLink struct {
+	yamlPath path.ContextPath
+	srcPath  string
+	target   string
+}
+
+// checkTreeLinkCycles warns about cycles among the symlinks created from
+// trees, in which each link's target is the next link.  Only the full
+// target path is resolved; links among its parent directories aren't
+// followed.
+func checkTreeLinkCycles(r *report.Report, links map[string]treeLink) {
+	dests := make([]string, 0, len(links))
+	for dest := range links {
+		dests = append(dests, dest)
+	}
+	sort.Strings(dests)
+	done := make(map[string]bool)
+	for _, start := range dests {
+		// follow the chain from start until it leaves the set of
+		// tree links, reaches a link already checked, or repeats
+		positions := make(map[string]int)
+		var chain []string
+		for cur := start; !done[cur]; {
+			if i, ok := positions[cur]; ok {
+				reportTreeLinkCycle(r, links, chain[i:])
+				break
+			}
+			link, ok := links[cur]
+			if !ok {
+				break
+			}
+			positions[cur] = len(chain)
+			chain = append(chain, cur)
+			cur = link.target
+			if !slashpath.IsAbs(cur) {
+				cur = slashpath.Join(slashpath.Dir(chain[len(chain)-1]), cur)
+			}
+		}
+		for _, dest := range chain {
+			done[dest] = true
+		}
+	}
+}
+
+// reportTreeLinkCycle reports the links in cycle, listed by source path
+// starting from the lowest destination path.
+func reportTreeLinkCycle(r *report.Report, links map[string]treeLink, cycle []string) {
+	first := 0
+	for i, dest := range cycle {
+		if dest < cycle[first] {
+			first = i
+		}
+	}
+	var members []string
+	for i := range cycle {
+		members = append(members, links[cycle[(first+i)%len(cycle)]].srcPath)
+	}
+	members = append(members, members[0])
+	r.AddOnWarn(links[cycle[first]].yamlPath, fmt.Errorf("%w: %s", common.ErrTreeLinkCycle, strings.Join(members, " -> ")))
+}
+
+const treeMtimesUnit = "butane-tree-mtimes.service"
+
+// addTreeMtimesUnit adds an enabled service which sets the modification
+// times of files to those in mtimes on first boot, since Ignition
+// doesn't preserve them.  mtimes is keyed by tree destination path; the
+// unit touches each path as rewritten by RewriteNodePaths, which has
+// already reported any rewriting errors.
+func addTreeMtimesUnit(config *types.Config, ts *translate.TranslationSet, r *report.Report, mtimes map[string]time.Time, options common.TranslateOptions) {
+	name := generatedUnitName(treeMtimesUnit, options)
+	for _, unit := range config.Systemd.Units {
+		if unit.Name == name {
+			r.AddOnError(path.New("yaml", "storage", "trees"), common.ErrTreeMtimesUnitExists)
+			return
+		}
+	}
+	rewritten := make(map[string]time.Time, len(mtimes))
+	for p, mtime := range mtimes {
+		if p, err := rewriteNodePath(p, options); err == nil {
+			rewritten[p] = mtime
+		}
+	}
+	mtimes = rewritten
+	paths := make([]string, 0, len(mtimes))
+	for p := range mtimes {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	context := struct {
+		Commands       []string
+		NoUnitComments bool
+	}{
+		NoUnitComments: options.NoUnitComments,
+	}
+	for _, p := range paths {
+		mtime := mtimes[p]
+		context.Commands = append(context.Commands, fmt.Sprintf("/usr/bin/touch --no-create --date=@%d.%09d %s", mtime.Unix(), mtime.Nanosecond(), execArgument(p)))
+	}
+	var contents strings.Builder
+	if err := treeMtimesTemplate.Execute(&contents, context); err != nil {
+		panic(err)
+	}
+	i := len(config.Systemd.Units)
+	config.Systemd.Units = append(config.Systemd.Units, types.Unit{
+		Name:     name,
+		Contents: util.StrToPtr(contents.String()),
+		Enabled:  util.BoolToPtr(true),
+	})
+	yamlPath := path.New("yaml", "storage", "trees")
+	ts.AddFromCommonSource(yamlPath, path.New("json", "systemd", "units", i), config.Systemd.Units[i])
+	if i == 0 {
+		ts.AddTranslation(yamlPath, path.New("json", "systemd"))
+		ts.AddTranslation(yamlPath, path.New("json", "systemd", "units"))
+	}
+}
+
+// execArgument quotes s as a single argument of an ExecStart= command
+// line, escaping systemd specifiers and environment variable expansion.
+func execArgument(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$").Replace(s) + `"`
+}
+
+type resolvedTree struct {
+	yamlPath    path.ContextPath
+	srcBaseDir  string
+	destBaseDir string
+	tree        Tree
+	// file modes from the modes_file, by path relative to srcBaseDir
+	modes map[string]int
+}
+
+// readTreeModes reads a modes_file from the files filesystem.  Each
+// non-empty line which isn't a comment contains a path relative to the
+// root of the tree, followed by whitespace and an octal mode.  The path
+// may contain spaces.
+func readTreeModes(fsys fs.FS, modesFile string) (map[string]int, error) {
+	name, err := baseutil.LocalFSPath(modesFile)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	modes := make(map[string]int)
+	for i, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sep := strings.LastIndexAny(line, " \t")
+		if sep == -1 {
+			return nil, fmt.Errorf("line %d: %w", i+1, common.ErrTreeModesFileEntry)
+		}
+		relPath := strings.TrimSpace(line[:sep])
+		if slashpath.IsAbs(relPath) || relPath != slashpath.Clean(relPath) || relPath == "." || relPath == ".." || strings.HasPrefix(relPath, "../") {
+			return nil, fmt.Errorf("line %d: %w", i+1, common.ErrTreeModesFilePath)
+		}
+		mode, err := strconv.ParseUint(line[sep+1:], 8, 32)
+		if err != nil || mode > 07777 {
+			return nil, fmt.Errorf("line %d: %w", i+1, common.ErrTreeModesFileMode)
+		}
+		if _, ok := modes[relPath]; ok {
+			return nil, fmt.Errorf("line %d: %s: %w", i+1, relPath, common.ErrTreeModesFileDuplicate)
+		}
+		modes[relPath] = int(mode)
+	}
+	return modes, nil
+}
+
+// checkTreeOverlaps reports an error for each tree whose destination
+// overlaps that of an earlier tree, if both would create a node other
+// than a directory at the same path.  It returns whether each tree
+// conflicts with an earlier one.
+func checkTreeOverlaps(fsys fs.FS, trees []resolvedTree, r *report.Report) []bool {
+	conflicting := make([]bool, len(trees))
+	nodes := make([]map[string]bool, len(trees))
+	for j := range trees {
+		for i := 0; i < j && !conflicting[j]; i++ {
+			if !baseutil.PathWithin(trees[j].destBaseDir, trees[i].destBaseDir) && !baseutil.PathWithin(trees[i].destBaseDir, trees[j].destBaseDir) {
+				continue
+			}
+			for _, k := range []int{i, j} {
+				if nodes[k] == nil {
+					var err error
+					nodes[k], err = treeNodes(fsys, trees[k])
+					if err != nil {
+						// report it during the walk
+						nodes[k] = map[string]bool{}
+					}
+				}
+			}
+			var conflicts []string
+			for p, isDir := range nodes[j] {
+				if otherIsDir, exists := nodes[i][p]; exists && !(isDir && otherIsDir) {
+					conflicts = append(conflicts, p)
+				}
+			}
+			if len(conflicts) > 0 {
+				sort.Strings(conflicts)
+				r.AddOnError(trees[j].yamlPath, fmt.Errorf("%w: %s at %s", common.ErrTreeOverlap, trees[i].yamlPath, conflicts[0]))
+				conflicting[j] = true
+			}
+		}
+	}
+	return conflicting
+}
+
+// treeNodes returns the destination paths of the nodes in the tree at
+// srcBaseDir, mapped to whether each is a directory.
+func treeNodes(fsys fs.FS, rt resolvedTree) (map[string]bool, error) {
+	ret := make(map[string]bool)
+	err := fs.WalkDir(fsys, rt.srcBaseDir, func(srcPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if srcPath != rt.srcBaseDir && treeSkips(rt.tree, entry) {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if !treeIncludes(rt.tree, info) {
+			return nil
+		}
+		if entry.IsDir() && util.IsTrue(rt.tree.Flatten) {
+			// only the destination directory itself
+			return nil
+		}
+		relPath := srcPath
+		if rt.srcBaseDir != "." {
+			relPath = strings.TrimPrefix(srcPath, rt.srcBaseDir)
+		}
+		ret[treeDestPath(rt.tree, rt.destBaseDir, relPath)] = entry.IsDir()
+		return nil
+	})
+	return ret, err
+}
+
+// treeDestPath returns the destination path of the node at relPath
+// within a tree rooted at destBaseDir.  Flattened trees place every node
+// directly in destBaseDir.
+func treeDestPath(tree Tree, destBaseDir, relPath string) string {
+	if util.IsTrue(tree.Flatten) {
+		return slashpath.Join(destBaseDir, slashpath.Base(relPath))
+	}
+	return slashpath.Join(destBaseDir, relPath)
+}
+
+// treeIncludes returns false if info describes a regular file excluded
+// by the mode_filter of tree.
+func treeIncludes(tree Tree, info fs.FileInfo) bool {
+	if !info.Mode().IsRegular() || tree.ModeFilter == nil {
+		return true
+	}
+	executable := info.Mode()&0111 != 0
+	switch *tree.ModeFilter {
+	case "executable":
+		return executable
+	case "non-executable":
+		return !executable
+	}
+	return true
+}
+
+// treeSkips returns true if entry, and its contents if it's a directory,
+// are excluded by the skip_hidden setting of tree.  It must not be called
+// on the root of the tree.
+func treeSkips(tree Tree, entry fs.DirEntry) bool {
+	return util.IsTrue(tree.SkipHidden) && strings.HasPrefix(entry.Name(), ".")
 }
 
-func walkTree(yamlPath path.ContextPath, ts *translate.TranslationSet, r *report.Report, t *nodeTracker, srcBaseDir, destBaseDir string, options common.TranslateOptions) {
-	// The strategy for errors within WalkFunc is to add an error to
+// walkTree adds the files and symlinks in a tree to the config.  Files
+// listed in modes take their modes from it.  If mtimes is non-nil, the
+// modification time of each file is recorded in it; if links is non-nil,
+// each symlink is recorded in it by destination path.
+func walkTree(yamlPath path.ContextPath, ts *translate.TranslationSet, r *report.Report, t *nodeTracker, fsys fs.FS, srcBaseDir, destBaseDir string, tree Tree, modes map[string]int, mtimes map[string]time.Time, links map[string]treeLink, options common.TranslateOptions) {
+	// The strategy for errors within WalkDirFunc is to add an error to
 	// the report and return nil, so walking continues but translation
 	// will fail afterward.
-	err := filepath.Walk(srcBaseDir, func(srcPath string, info os.FileInfo, err error) error {
+	empty := true
+	// source paths of the nodes of a flattened tree, by destination
+	flattened := make(map[string]string)
+	// modes_file entries which matched a file
+	modesUsed := make(map[string]bool)
+	err := fs.WalkDir(fsys, srcBaseDir, func(srcPath string, entry fs.DirEntry, err error) error {
 		if err != nil {
+			empty = false
 			r.AddOnError(yamlPath, err)
 			return nil
 		}
-		relPath, err := filepath.Rel(srcBaseDir, srcPath)
+		if srcPath != srcBaseDir && treeSkips(tree, entry) {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		info, err := entry.Info()
 		if err != nil {
 			r.AddOnError(yamlPath, err)
 			return nil
 		}
-		destPath := slashpath.Join(destBaseDir, filepath.ToSlash(relPath))
+		relPath := srcPath
+		if srcBaseDir != "." {
+			relPath = strings.TrimPrefix(srcPath, srcBaseDir)
+		}
+		destPath := treeDestPath(tree, destBaseDir, relPath)
 
-		if info.Mode().IsDir() {
+		if info.Mode().IsDir() || !treeIncludes(tree, info) {
 			return nil
-		} else if info.Mode().IsRegular() {
+		}
+		if util.IsTrue(tree.Flatten) && (info.Mode().IsRegular() || info.Mode()&fs.ModeType == fs.ModeSymlink) {
+			if other, ok := flattened[destPath]; ok {
+				empty = false
+				r.AddOnError(yamlPath, fmt.Errorf("%w: %s and %s", common.ErrNodeExists, other, srcPath))
+				return nil
+			}
+			flattened[destPath] = srcPath
+		}
+		if info.Mode().IsRegular() {
+			empty = false
+			modesUsed[strings.TrimPrefix(relPath, "/")] = true
 			i, file := t.GetFile(destPath)
-			if file != nil {
+			declared := file != nil
+			if declared {
+				// explicit contents take precedence; trees are
+				// walked after files, so this doesn't depend on order
 				if util.NotEmpty(file.Contents.Source) {
-					r.AddOnError(yamlPath, common.ErrNodeExists)
+					r.AddOnWarn(yamlPath, fmt.Errorf("%s: %w", srcPath, common.ErrTreeNodeSuppressed))
 					return nil
 				}
+				// the compression of a partial entry can't describe
+				// the tree contents, so encode them from scratch
+				if util.NotEmpty(file.Contents.Compression) {
+					r.AddOnWarn(yamlPath, fmt.Errorf("%s: %w", srcPath, common.ErrTreeCompressionIgnored))
+				}
+				file.Contents.Compression = nil
 			} else {
 				if t.Exists(destPath) {
 					r.AddOnError(yamlPath, common.ErrNodeExists)
@@ -368,12 +1631,34 @@ func walkTree(yamlPath path.ContextPath, ts *translate.TranslationSet, r *report
 					ts.AddTranslation(yamlPath, path.New("json", "storage", "files"))
 				}
 			}
-			contents, err := os.ReadFile(srcPath)
+			contents, err := fs.ReadFile(fsys, srcPath)
 			if err != nil {
 				r.AddOnError(yamlPath, err)
 				return nil
 			}
-			url, compression, err := baseutil.MakeDataURL(contents, file.Contents.Compression, !options.NoResourceAutoCompression)
+			if err := options.AddEmbeddedBytes(len(contents)); err != nil {
+				r.AddOnError(yamlPath, fmt.Errorf("%s: %w", srcPath, err))
+				return nil
+			}
+			var url string
+			var compression *string
+			compressionPath := yamlPath
+			switch {
+			case tree.Compression != nil && *tree.Compression == "gzip":
+				url, err = baseutil.MakeGzipDataURL(contents)
+				compression = util.StrToPtr("gzip")
+				compressionPath = yamlPath.Append("compression")
+				if err == nil && options.VerifyCompression {
+					err = baseutil.VerifyDataURL(url, compression, contents)
+				}
+			case tree.Compression != nil && *tree.Compression == "none":
+				noCompressOptions := options
+				noCompressOptions.NoResourceAutoCompression = true
+				url, compression, err = makeDataURL(contents, nil, noCompressOptions)
+				compressionPath = yamlPath.Append("compression")
+			default:
+				url, compression, err = makeDataURL(contents, nil, options)
+			}
 			if err != nil {
 				r.AddOnError(yamlPath, err)
 				return nil
@@ -382,22 +1667,44 @@ func walkTree(yamlPath path.ContextPath, ts *translate.TranslationSet, r *report
 			ts.AddTranslation(yamlPath, path.New("json", "storage", "files", i, "contents", "source"))
 			if compression != nil {
 				file.Contents.Compression = compression
-				ts.AddTranslation(yamlPath, path.New("json", "storage", "files", i, "contents", "compression"))
+				ts.AddTranslation(compressionPath, path.New("json", "storage", "files", i, "contents", "compression"))
 			}
 			ts.AddTranslation(yamlPath, path.New("json", "storage", "files", i, "contents"))
 			if file.Mode == nil {
 				mode := 0644
-				if info.Mode()&0111 != 0 {
+				modePath := yamlPath
+				listedMode, listed := modes[strings.TrimPrefix(relPath, "/")]
+				switch {
+				case listed:
+					mode = listedMode
+					modePath = yamlPath.Append("modes_file")
+				case util.IsTrue(tree.PreserveModes):
+					mode = int(info.Mode().Perm())
+					modePath = yamlPath.Append("preserve_modes")
+				case options.RequireExplicitModes:
+					// including declared entries, which get
+					// their mode from the tree
+					r.AddOnError(yamlPath, fmt.Errorf("%s: %w", srcPath, common.ErrModeNotExplicit))
+				case info.Mode()&0111 != 0:
 					mode = 0755
 				}
 				file.Mode = &mode
-				ts.AddTranslation(yamlPath, path.New("json", "storage", "files", i, "mode"))
+				ts.AddTranslation(modePath, path.New("json", "storage", "files", i, "mode"))
+			}
+			if tree.Overwrite != nil && file.Overwrite == nil {
+				file.Overwrite = util.BoolToPtr(*tree.Overwrite)
+				ts.AddTranslation(yamlPath.Append("overwrite"), path.New("json", "storage", "files", i, "overwrite"))
 			}
-		} else if info.Mode()&os.ModeType == os.ModeSymlink {
+			applyTreeOwner(yamlPath, path.New("json", "storage", "files", i), ts, &file.Node, tree)
+			if mtimes != nil {
+				mtimes[destPath] = info.ModTime()
+			}
+		} else if info.Mode()&fs.ModeType == fs.ModeSymlink {
+			empty = false
 			i, link := t.GetLink(destPath)
 			if link != nil {
 				if util.NotEmpty(link.Target) {
-					r.AddOnError(yamlPath, common.ErrNodeExists)
+					r.AddOnWarn(yamlPath, fmt.Errorf("%s: %w", srcPath, common.ErrTreeNodeSuppressed))
 					return nil
 				}
 			} else {
@@ -415,87 +1722,1554 @@ func walkTree(yamlPath path.ContextPath, ts *translate.TranslationSet, r *report
 					ts.AddTranslation(yamlPath, path.New("json", "storage", "links"))
 				}
 			}
-			target, err := os.Readlink(srcPath)
+			target, err := baseutil.ReadLink(fsys, srcPath)
 			if err != nil {
 				r.AddOnError(yamlPath, err)
 				return nil
 			}
-			link.Target = util.StrToPtr(filepath.ToSlash(target))
+			link.Target = util.StrToPtr(target)
 			ts.AddTranslation(yamlPath, path.New("json", "storage", "links", i, "target"))
-		} else {
-			r.AddOnError(yamlPath, common.ErrFileType)
+			if links != nil {
+				links[destPath] = treeLink{
+					yamlPath: yamlPath,
+					srcPath:  srcPath,
+					target:   target,
+				}
+			}
+			if tree.Overwrite != nil && link.Overwrite == nil {
+				link.Overwrite = util.BoolToPtr(*tree.Overwrite)
+				ts.AddTranslation(yamlPath.Append("overwrite"), path.New("json", "storage", "links", i, "overwrite"))
+			}
+			applyTreeOwner(yamlPath, path.New("json", "storage", "links", i), ts, &link.Node, tree)
+		} else if tree.OnSpecialFile != nil && *tree.OnSpecialFile == "skip" {
+			r.AddOnWarn(yamlPath.Append("on_special_file"), fmt.Errorf("%s: %w", srcPath, common.ErrSpecialFileSkipped))
+		} else {
+			empty = false
+			r.AddOnError(yamlPath, common.ErrFileType)
 			return nil
 		}
 		return nil
 	})
 	r.AddOnError(yamlPath, err)
+	if err == nil && empty {
+		// probably a wrong path or an unbuilt artifact, but empty
+		// trees are sometimes intentional
+		r.AddOnWarn(yamlPath, common.ErrTreeEmpty)
+	}
+	var unknown []string
+	for relPath := range modes {
+		if !modesUsed[relPath] {
+			unknown = append(unknown, relPath)
+		}
+	}
+	sort.Strings(unknown)
+	for _, relPath := range unknown {
+		r.AddOnWarn(yamlPath.Append("modes_file"), fmt.Errorf("%s: %w", relPath, common.ErrTreeModesFileUnknown))
+	}
+}
+
+// applyTreeOwner sets the user and group of a node created from tree,
+// unless the node's corresponding entry already specifies them.
+func applyTreeOwner(yamlPath, nodePath path.ContextPath, ts *translate.TranslationSet, node *types.Node, tree Tree) {
+	if (tree.User.ID != nil || tree.User.Name != nil) && node.User.ID == nil && node.User.Name == nil {
+		node.User = types.NodeUser{
+			ID:   tree.User.ID,
+			Name: tree.User.Name,
+		}
+		ts.AddFromCommonObject(yamlPath.Append("user"), nodePath.Append("user"), node.User)
+	}
+	if (tree.Group.ID != nil || tree.Group.Name != nil) && node.Group.ID == nil && node.Group.Name == nil {
+		node.Group = types.NodeGroup{
+			ID:   tree.Group.ID,
+			Name: tree.Group.Name,
+		}
+		ts.AddFromCommonObject(yamlPath.Append("group"), nodePath.Append("group"), node.Group)
+	}
 }
 
-func (c Config) addMountUnits(config *types.Config, ts *translate.TranslationSet) {
+func (c Config) addMountUnits(config *types.Config, ts *translate.TranslationSet, options common.TranslateOptions) (r report.Report) {
 	if len(c.Storage.Filesystems) == 0 {
 		return
 	}
+	// Different paths can escape to the same unit name (e.g. /var/lib
+	// and /var//lib/).  User units with a generated name are merged
+	// intentionally, but generated units must not collide.
+	unitNames := make(map[string]struct{})
 	var rendered types.Config
 	renderedTranslations := translate.NewTranslationSet("yaml", "json")
 	renderedTranslations.AddTranslation(path.New("yaml", "storage", "filesystems"), path.New("json", "systemd"))
 	renderedTranslations.AddTranslation(path.New("yaml", "storage", "filesystems"), path.New("json", "systemd", "units"))
+	fstab := options.MountStyle == "fstab"
+	var fstabLines []string
 	for i, fs := range c.Storage.Filesystems {
+		// a filesystem on the device underlying a LUKS volume would
+		// overwrite the volume
+		backing, onBacking := c.luksBackingVolume(fs.Device)
+		if onBacking {
+			r.AddOnWarn(path.New("yaml", "storage", "filesystems", i, "device"), common.ErrFilesystemOnLuksBackingDevice)
+		}
 		if !util.IsTrue(fs.WithMountUnit) {
 			continue
 		}
 		fromPath := path.New("yaml", "storage", "filesystems", i, "with_mount_unit")
 		remote := false
-		// check filesystems targeting /dev/mapper devices against LUKS to determine if a
-		// remote mount is needed
-		if strings.HasPrefix(fs.Device, "/dev/mapper/") || strings.HasPrefix(fs.Device, "/dev/disk/by-id/dm-name-") {
-			for _, luks := range c.Storage.Luks {
-				// LUKS devices are opened with their name specified
-				if fs.Device == fmt.Sprintf("/dev/mapper/%s", luks.Name) || fs.Device == fmt.Sprintf("/dev/disk/by-id/dm-name-%s", luks.Name) {
-					if len(luks.Clevis.Tang) > 0 {
-						remote = true
-						break
-					}
+		luksName := ""
+		// the mount must wait for the LUKS device, and for the network
+		// if the device is unlocked with Tang
+		if luks, ok := c.luksVolume(fs.Device); ok {
+			luksName = luks.Name
+			remote = len(luks.Clevis.Tang) > 0
+		} else if onBacking {
+			// the device is only usable once the volume is opened
+			luksName = backing.Name
+			remote = len(backing.Clevis.Tang) > 0
+		}
+		if options.PathPrefix != "" && fs.Path != nil {
+			fs.Path = util.StrToPtr(slashpath.Join(options.PathPrefix, *fs.Path))
+		}
+		unitName := mountUnitName(fs)
+		if _, ok := unitNames[unitName]; ok {
+			field := "path"
+			if *fs.Format == "swap" {
+				field = "device"
+			}
+			r.AddOnError(path.New("yaml", "storage", "filesystems", i, field), common.ErrMountUnitNameCollision)
+			continue
+		}
+		unitNames[unitName] = struct{}{}
+		if fstab {
+			// systemd-fstab-generator derives the dependency from nofail
+			if fs.MountInstallRequires != nil {
+				r.AddOnWarn(path.New("yaml", "storage", "filesystems", i, "mount_install_requires"), common.ErrFstabInstallRequires)
+			}
+			fstabLines = append(fstabLines, fstabLine(fs, remote, luksName, options))
+		} else {
+			newUnit := mountUnitFromFS(fs, remote, luksName, options)
+			unitPath := path.New("json", "systemd", "units", len(rendered.Systemd.Units))
+			rendered.Systemd.Units = append(rendered.Systemd.Units, newUnit)
+			renderedTranslations.AddFromCommonSource(fromPath, unitPath, newUnit)
+		}
+		if util.IsTrue(fs.Resize) {
+			resizeUnit := resizeUnitFromFS(fs, unitName, options)
+			resizePath := path.New("json", "systemd", "units", len(rendered.Systemd.Units))
+			rendered.Systemd.Units = append(rendered.Systemd.Units, resizeUnit)
+			renderedTranslations.AddFromCommonSource(path.New("yaml", "storage", "filesystems", i, "resize"), resizePath, resizeUnit)
+		}
+	}
+	if len(fstabLines) > 0 {
+		r.Merge(addFstabFile(&rendered, &renderedTranslations, config.Storage.Files, fstabLines, options))
+	}
+	retConfig, retTranslations := baseutil.MergeTranslatedConfigs(rendered, renderedTranslations, *config, *ts)
+	*config = retConfig.(types.Config)
+	*ts = retTranslations
+	return
+}
+
+const fstabPath = "/etc/fstab"
+
+// addFstabFile adds a file appending lines to /etc/fstab, which the OS
+// image may already have populated.  existing are the files already in
+// the config.
+func addFstabFile(config *types.Config, ts *translate.TranslationSet, existing []types.File, lines []string, options common.TranslateOptions) (r report.Report) {
+	for _, file := range existing {
+		if file.Path == fstabPath {
+			r.AddOnError(path.New("yaml", "storage", "filesystems"), common.ErrFstabFileExists)
+			return
+		}
+	}
+	var contents strings.Builder
+	if !options.NoUnitComments {
+		contents.WriteString("# Generated by Butane\n")
+	}
+	for _, line := range lines {
+		contents.WriteString(line)
+	}
+	url, compression, err := makeDataURL([]byte(contents.String()), nil, options)
+	if err != nil {
+		r.AddOnError(path.New("yaml", "storage", "filesystems"), err)
+		return
+	}
+	file := types.File{
+		Node: types.Node{
+			Path: fstabPath,
+		},
+		FileEmbedded1: types.FileEmbedded1{
+			Append: []types.Resource{
+				{
+					Source:      util.StrToPtr(url),
+					Compression: compression,
+				},
+			},
+			Mode: util.IntToPtr(0644),
+		},
+	}
+	filePath := path.New("json", "storage", "files", len(config.Storage.Files))
+	config.Storage.Files = append(config.Storage.Files, file)
+	ts.AddTranslation(path.New("yaml", "storage", "filesystems"), path.New("json", "storage"))
+	ts.AddTranslation(path.New("yaml", "storage", "filesystems"), path.New("json", "storage", "files"))
+	ts.AddFromCommonSource(path.New("yaml", "storage", "filesystems"), filePath, file)
+	return
+}
+
+// normalizeDevicePaths returns a copy of the config with filesystem and
+// LUKS devices which reference partitions declared in storage.disks
+// rewritten to form, which is "partlabel" for
+// /dev/disk/by-partlabel/<label> or "disk" for the partition number
+// appended to the disk device.  Devices which can't be rewritten are
+// left unchanged with a warning, except for filesystem label and UUID
+// links, which don't name a partition and are left unchanged silently.
+func (c Config) normalizeDevicePaths(form string) (Config, report.Report) {
+	var r report.Report
+	if form != "partlabel" && form != "disk" {
+		r.AddOnError(path.New("yaml"), common.ErrUnknownDevicePathForm)
+		return c, r
+	}
+	filesystems := append([]Filesystem(nil), c.Storage.Filesystems...)
+	for i := range filesystems {
+		if device, ok := c.normalizeDevice(filesystems[i].Device, form); ok {
+			filesystems[i].Device = device
+		} else {
+			r.AddOnWarn(path.New("yaml", "storage", "filesystems", i, "device"), common.ErrDeviceNotNormalized)
+		}
+	}
+	luks := append([]Luks(nil), c.Storage.Luks...)
+	for i := range luks {
+		if luks[i].Device == nil {
+			continue
+		}
+		if device, ok := c.normalizeDevice(*luks[i].Device, form); ok {
+			luks[i].Device = util.StrToPtr(device)
+		} else {
+			r.AddOnWarn(path.New("yaml", "storage", "luks", i, "device"), common.ErrDeviceNotNormalized)
+		}
+	}
+	c.Storage.Filesystems = filesystems
+	c.Storage.Luks = luks
+	return c, r
+}
+
+// normalizeDevice returns device rewritten to form.  Devices which don't
+// name a partition, such as LUKS and RAID devices and filesystem label
+// and UUID links, are returned unchanged.  If device names a partition which can't be rewritten, ok
+// is false.
+func (c Config) normalizeDevice(device, form string) (string, bool) {
+	for _, prefix := range []string{"/dev/mapper/", "/dev/disk/by-id/dm-name-", "/dev/md/", "/dev/disk/by-label/", "/dev/disk/by-uuid/"} {
+		if strings.HasPrefix(device, prefix) {
+			return device, true
+		}
+	}
+	for _, disk := range c.Storage.Disks {
+		if device == disk.Device {
+			return device, true
+		}
+	}
+	d, p, ok := c.declaredPartition(device)
+	if !ok {
+		return device, false
+	}
+	partition := c.Storage.Disks[d].Partitions[p]
+	switch form {
+	case "partlabel":
+		if partition.Label == nil {
+			return device, false
+		}
+		return "/dev/disk/by-partlabel/" + *partition.Label, true
+	default:
+		if partition.Number == 0 {
+			return device, false
+		}
+		return partitionDevice(c.Storage.Disks[d].Device, partition.Number), true
+	}
+}
+
+// partitionDevice returns the device path of partition number of disk,
+// following udev and kernel naming conventions.
+func partitionDevice(disk string, number int) string {
+	switch {
+	case strings.HasPrefix(disk, "/dev/disk/"):
+		return disk + "-part" + strconv.Itoa(number)
+	case disk != "" && disk[len(disk)-1] >= '0' && disk[len(disk)-1] <= '9':
+		return disk + "p" + strconv.Itoa(number)
+	default:
+		return disk + strconv.Itoa(number)
+	}
+}
+
+// sameDevice returns true if device paths a and b are the same, or
+// reference the same partition declared in storage.disks.
+func (c Config) sameDevice(a, b string) bool {
+	if a == b {
+		return true
+	}
+	diskA, partA, okA := c.declaredPartition(a)
+	diskB, partB, okB := c.declaredPartition(b)
+	return okA && okB && diskA == diskB && partA == partB
+}
+
+// declaredPartition returns the indexes of the disk and partition in
+// storage.disks referenced by device, either by partition label
+// (/dev/disk/by-partlabel/<label>) or by partition number on the disk
+// device (e.g. /dev/vda4, /dev/nvme0n1p4, or
+// /dev/disk/by-id/<id>-part4).  If no declared partition matches, ok is
+// false.
+func (c Config) declaredPartition(device string) (disk int, partition int, ok bool) {
+	byLabel := strings.HasPrefix(device, "/dev/disk/by-partlabel/")
+	label := strings.TrimPrefix(device, "/dev/disk/by-partlabel/")
+	for i, d := range c.Storage.Disks {
+		for j, p := range d.Partitions {
+			if byLabel {
+				if p.Label != nil && *p.Label == label {
+					return i, j, true
+				}
+				continue
+			}
+			if p.Number == 0 {
+				continue
+			}
+			number := strconv.Itoa(p.Number)
+			if device == d.Device+number || device == d.Device+"p"+number || device == d.Device+"-part"+number {
+				return i, j, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// applyPresets sets the enablement of units in systemd.presets, unless
+// the unit sets enabled itself.
+func (c Config) applyPresets(config *types.Config, ts *translate.TranslationSet) {
+	apply := func(names []string, key string, enabled bool) {
+		for i, name := range names {
+			for j := range config.Systemd.Units {
+				unit := &config.Systemd.Units[j]
+				if unit.Name != name || unit.Enabled != nil {
+					continue
 				}
+				unit.Enabled = util.BoolToPtr(enabled)
+				ts.AddTranslation(path.New("yaml", "systemd", "presets", key, i), path.New("json", "systemd", "units", j, "enabled"))
+			}
+		}
+	}
+	apply(c.Systemd.Presets.Enabled, "enabled", true)
+	apply(c.Systemd.Presets.Disabled, "disabled", false)
+}
+
+// addTimerUnits generates a oneshot service and a timer which starts it for
+// each systemd.timers entry.
+func (c Config) addTimerUnits(config *types.Config, ts *translate.TranslationSet, options common.TranslateOptions) (r report.Report) {
+	if len(c.Systemd.Timers) == 0 {
+		return
+	}
+	existing := make(map[string]struct{})
+	for _, unit := range config.Systemd.Units {
+		existing[unit.Name] = struct{}{}
+	}
+	var rendered types.Config
+	renderedTranslations := translate.NewTranslationSet("yaml", "json")
+	renderedTranslations.AddTranslation(path.New("yaml", "systemd", "timers"), path.New("json", "systemd"))
+	renderedTranslations.AddTranslation(path.New("yaml", "systemd", "timers"), path.New("json", "systemd", "units"))
+	for i, timer := range c.Systemd.Timers {
+		fromPath := path.New("yaml", "systemd", "timers", i)
+		for _, unit := range timerUnits(timer, options) {
+			if _, ok := existing[unit.Name]; ok {
+				r.AddOnWarn(fromPath.Append("name"), common.ErrTimerUnitExists)
 			}
+			unitPath := path.New("json", "systemd", "units", len(rendered.Systemd.Units))
+			rendered.Systemd.Units = append(rendered.Systemd.Units, unit)
+			renderedTranslations.AddFromCommonSource(fromPath, unitPath, unit)
 		}
-		newUnit := mountUnitFromFS(fs, remote)
-		unitPath := path.New("json", "systemd", "units", len(rendered.Systemd.Units))
-		rendered.Systemd.Units = append(rendered.Systemd.Units, newUnit)
-		renderedTranslations.AddFromCommonSource(fromPath, unitPath, newUnit)
 	}
 	retConfig, retTranslations := baseutil.MergeTranslatedConfigs(rendered, renderedTranslations, *config, *ts)
 	*config = retConfig.(types.Config)
 	*ts = retTranslations
+	return
 }
 
-func mountUnitFromFS(fs Filesystem, remote bool) types.Unit {
+// timerUnits returns the service and timer units for a timer.  Only the
+// timer is enabled; it starts the service.
+func timerUnits(timer Timer, options common.TranslateOptions) []types.Unit {
+	context := struct {
+		Timer
+		NoUnitComments bool
+	}{
+		Timer:          timer,
+		NoUnitComments: options.NoUnitComments,
+	}
+	var service, timerContents strings.Builder
+	if err := timerServiceTemplate.Execute(&service, context); err != nil {
+		panic(err)
+	}
+	if err := timerTemplate.Execute(&timerContents, context); err != nil {
+		panic(err)
+	}
+	return []types.Unit{
+		{
+			Name:     timer.Name + ".service",
+			Contents: util.StrToPtr(service.String()),
+		},
+		{
+			Name:     timer.Name + ".timer",
+			Enabled:  util.BoolToPtr(true),
+			Contents: util.StrToPtr(timerContents.String()),
+		},
+	}
+}
+
+// addNetworkFiles adds a systemd-networkd .network file for each
+// systemd.networks entry.
+func (c Config) addNetworkFiles(config *types.Config, ts *translate.TranslationSet, options common.TranslateOptions) (r report.Report) {
+	var files []generatedFile
+	for i, network := range c.Systemd.Networks {
+		fromPath := path.New("yaml", "systemd", "networks", i)
+		file, err := networkFile(network, options)
+		if err != nil {
+			r.AddOnError(fromPath, err)
+			continue
+		}
+		files = append(files, generatedFile{
+			from:         fromPath,
+			file:         file,
+			translations: namedFileTranslations(),
+			collision:    common.ErrNetworkFileExists,
+		})
+	}
+	r.Merge(addGeneratedFiles(config, ts, path.New("yaml", "systemd", "networks"), files))
+	return
+}
+
+// networkFile returns the .network file for a network.
+func networkFile(network Network, options common.TranslateOptions) (types.File, error) {
+	context := struct {
+		Network
+		NoUnitComments bool
+	}{
+		Network:        network,
+		NoUnitComments: options.NoUnitComments,
+	}
+	var contents strings.Builder
+	if err := networkTemplate.Execute(&contents, context); err != nil {
+		panic(err)
+	}
+	src, compression, err := makeDataURL([]byte(contents.String()), nil, options)
+	if err != nil {
+		return types.File{}, err
+	}
+	return types.File{
+		Node: types.Node{
+			Path: "/etc/systemd/network/" + generatedUnitName("10-"+network.Name+".network", options),
+		},
+		FileEmbedded1: types.FileEmbedded1{
+			Contents: types.Resource{
+				Source:      util.StrToPtr(src),
+				Compression: compression,
+			},
+			Mode: util.IntToPtr(0644),
+		},
+	}, nil
+}
+
+// addContainerFiles adds a Podman quadlet .container file for each
+// systemd.containers entry.
+func (c Config) addContainerFiles(config *types.Config, ts *translate.TranslationSet, options common.TranslateOptions) (r report.Report) {
+	var files []generatedFile
+	for i, ctr := range c.Systemd.Containers {
+		fromPath := path.New("yaml", "systemd", "containers", i)
+		file, err := containerFile(ctr, options)
+		if err != nil {
+			r.AddOnError(fromPath, err)
+			continue
+		}
+		files = append(files, generatedFile{
+			from:         fromPath,
+			file:         file,
+			translations: namedFileTranslations(),
+			collision:    common.ErrContainerFileExists,
+		})
+	}
+	r.Merge(addGeneratedFiles(config, ts, path.New("yaml", "systemd", "containers"), files))
+	return
+}
+
+// containerPath returns the path of the quadlet file for a container,
+// with prefix prepended to its name.  Rootless containers are configured
+// in the directory Podman reads for the user with their ID.
+func containerPath(ctr Container, prefix string) string {
+	dir := "/etc/containers/systemd"
+	if ctr.UserID != nil {
+		dir += "/users/" + strconv.Itoa(*ctr.UserID)
+	}
+	return dir + "/" + prefix + ctr.Name + ".container"
+}
+
+// containerFile returns the quadlet file for a container.
+func containerFile(ctr Container, options common.TranslateOptions) (types.File, error) {
+	context := struct {
+		Container
+		NoUnitComments bool
+	}{
+		Container:      ctr,
+		NoUnitComments: options.NoUnitComments,
+	}
+	context.Volumes = nil
+	for _, volume := range ctr.Volumes {
+		context.Volumes = append(context.Volumes, unitPathValue(volume))
+	}
+	context.Environment = nil
+	for _, env := range ctr.Environment {
+		context.Environment = append(context.Environment, environmentValue(env))
+	}
+	var contents strings.Builder
+	if err := containerTemplate.Execute(&contents, context); err != nil {
+		panic(err)
+	}
+	src, compression, err := makeDataURL([]byte(contents.String()), nil, options)
+	if err != nil {
+		return types.File{}, err
+	}
+	return types.File{
+		Node: types.Node{
+			Path: containerPath(ctr, options.GeneratedUnitPrefix),
+		},
+		FileEmbedded1: types.FileEmbedded1{
+			Contents: types.Resource{
+				Source:      util.StrToPtr(src),
+				Compression: compression,
+			},
+			Mode: util.IntToPtr(0644),
+		},
+	}, nil
+}
+
+// environmentValue returns a NAME=value assignment quoted for an
+// Environment= line if it contains whitespace, quotes, or backslashes,
+// with systemd specifiers escaped.
+func environmentValue(env string) string {
+	env = strings.ReplaceAll(env, "%", "%%")
+	if !strings.ContainsAny(env, " \t\"'\\") {
+		return env
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(env) + `"`
+}
+
+// addExtensions adds a file for each storage.extensions image, and enables
+// the service which merges extensions of its type at boot.
+func (c Config) addExtensions(config *types.Config, ts *translate.TranslationSet, options common.TranslateOptions) (r report.Report) {
+	if len(c.Storage.Extensions) == 0 {
+		return
+	}
+	var files []generatedFile
+	var rendered types.Config
+	renderedTranslations := translate.NewTranslationSet("yaml", "json")
+	renderedTranslations.AddTranslation(path.New("yaml", "storage", "extensions"), path.New("json", "systemd"))
+	renderedTranslations.AddTranslation(path.New("yaml", "storage", "extensions"), path.New("json", "systemd", "units"))
+	services := make(map[string]struct{})
+	for i, ext := range c.Storage.Extensions {
+		yamlPath := path.New("yaml", "storage", "extensions", i)
+		contents, contentsTranslations, contentsReport := translateResource(ext.Contents, options)
+		r.Merge(prefixReportPath(contentsReport, yamlPath.Append("contents")))
+		translations := namedFileTranslations()
+		translations.AddTranslation(path.New("yaml", "contents"), path.New("json", "contents"))
+		translations.Merge(contentsTranslations.PrefixPaths(path.New("yaml", "contents"), path.New("json", "contents")))
+		files = append(files, generatedFile{
+			from: yamlPath,
+			file: types.File{
+				Node: types.Node{
+					Path: slashpath.Join(extensionDir(ext), ext.Name+".raw"),
+				},
+				FileEmbedded1: types.FileEmbedded1{
+					Contents: contents,
+					Mode:     util.IntToPtr(0644),
+				},
+			},
+			translations: translations,
+			collision:    common.ErrExtensionFileExists,
+		})
+
+		service := "systemd-" + extensionType(ext) + ".service"
+		if _, ok := services[service]; !ok {
+			services[service] = struct{}{}
+			unit := types.Unit{
+				Name:    service,
+				Enabled: util.BoolToPtr(true),
+			}
+			renderedTranslations.AddFromCommonSource(yamlPath, path.New("json", "systemd", "units", len(rendered.Systemd.Units)), unit)
+			rendered.Systemd.Units = append(rendered.Systemd.Units, unit)
+		}
+	}
+	r.Merge(addGeneratedFiles(config, ts, path.New("yaml", "storage", "extensions"), files))
+	retConfig, retTranslations := baseutil.MergeTranslatedConfigs(rendered, renderedTranslations, *config, *ts)
+	*config = retConfig.(types.Config)
+	*ts = retTranslations
+	return
+}
+
+// addBannerFiles adds the login banner files for system.motd and
+// system.issue.
+func (c Config) addBannerFiles(config *types.Config, ts *translate.TranslationSet, options common.TranslateOptions) (r report.Report) {
+	banners := []struct {
+		name     string
+		resource *Resource
+		path     string
+	}{
+		{"issue", c.System.Issue, "/etc/issue.d/50-butane.issue"},
+		{"motd", c.System.Motd, "/etc/motd.d/50-butane.motd"},
+	}
+	var files []generatedFile
+	for _, banner := range banners {
+		if banner.resource == nil {
+			continue
+		}
+		yamlPath := path.New("yaml", "system", banner.name)
+		contents, contentsTranslations, contentsReport := translateResource(*banner.resource, options)
+		r.Merge(prefixReportPath(contentsReport, yamlPath))
+		files = append(files, generatedFile{
+			from: yamlPath,
+			file: types.File{
+				Node: types.Node{
+					Path: banner.path,
+				},
+				FileEmbedded1: types.FileEmbedded1{
+					Contents: contents,
+					Mode:     util.IntToPtr(0644),
+				},
+			},
+			translations: contentsTranslations.PrefixPaths(path.New("yaml"), path.New("json", "contents")),
+			collision:    common.ErrBannerFileExists,
+		})
+	}
+	r.Merge(addGeneratedFiles(config, ts, path.New("yaml", "system"), files))
+	return
+}
+
+// generatedFile is a file produced from the Butane config entry at
+// from.
+type generatedFile struct {
+	from path.ContextPath
+	file types.File
+	// translations from fields of the entry to fields of the file,
+	// relative to each; other fields are attributed to the whole entry
+	translations translate.TranslationSet
+	// reported at the source of the file's path if it's already in use
+	collision error
+}
+
+// namedFileTranslations returns the translations of a generatedFile whose
+// path is derived from the name field of its entry.
+func namedFileTranslations() translate.TranslationSet {
+	ts := translate.NewTranslationSet("yaml", "json")
+	ts.AddTranslation(path.New("yaml", "name"), path.New("json", "path"))
+	return ts
+}
+
+// addGeneratedFiles adds files to config, attributing storage.files to
+// parent.  A file whose path is already used by config or by an earlier
+// file is skipped with its collision error.
+func addGeneratedFiles(config *types.Config, ts *translate.TranslationSet, parent path.ContextPath, files []generatedFile) (r report.Report) {
+	existing := make(map[string]struct{})
+	for _, file := range config.Storage.Files {
+		existing[file.Path] = struct{}{}
+	}
+	var rendered types.Config
+	renderedTranslations := translate.NewTranslationSet("yaml", "json")
+	for _, gen := range files {
+		filePath := path.New("json", "storage", "files", len(rendered.Storage.Files))
+		fileTranslations := translate.NewTranslationSet("yaml", "json")
+		fileTranslations.AddFromCommonSource(gen.from, filePath, gen.file)
+		fileTranslations.Merge(gen.translations.PrefixPaths(gen.from, filePath))
+		if _, ok := existing[gen.file.Path]; ok {
+			from, _ := fileTranslations.Lookup(filePath.Append("path"))
+			r.AddOnError(from.From, gen.collision)
+			continue
+		}
+		existing[gen.file.Path] = struct{}{}
+		rendered.Storage.Files = append(rendered.Storage.Files, gen.file)
+		renderedTranslations.Merge(fileTranslations)
+	}
+	if len(rendered.Storage.Files) == 0 {
+		return
+	}
+	renderedTranslations.AddTranslation(parent, path.New("json", "storage"))
+	renderedTranslations.AddTranslation(parent, path.New("json", "storage", "files"))
+	retConfig, retTranslations := baseutil.MergeTranslatedConfigs(rendered, renderedTranslations, *config, *ts)
+	*config = retConfig.(types.Config)
+	*ts = retTranslations
+	return
+}
+
+// extensionType returns the type of an extension, defaulting to sysext.
+func extensionType(ext Extension) string {
+	if util.NotEmpty(ext.Type) {
+		return *ext.Type
+	}
+	return "sysext"
+}
+
+// extensionDir returns the directory of an extension's image.
+func extensionDir(ext Extension) string {
+	if util.NotEmpty(ext.Directory) {
+		return slashpath.Clean(*ext.Directory)
+	}
+	// unchecked index ok, ext would fail validation otherwise
+	return extensionDirs[extensionType(ext)][0]
+}
+
+// resizeUnitFromFS returns a unit which grows the filesystem to fill its
+// device after mountUnit mounts it.  Growing is online, and a no-op if the
+// filesystem already fills the device.
+func resizeUnitFromFS(fs Filesystem, mountUnit string, options common.TranslateOptions) types.Unit {
+	// unchecked derefs ok, fs would fail validation otherwise
+	command, _ := resizeCommand(*fs.Format, fs.Device, *fs.Path)
 	context := struct {
 		*Filesystem
-		EscapedDevice string
-		Remote        bool
-		Swap          bool
+		Command        string
+		MountPoint     string
+		MountUnit      string
+		NoUnitComments bool
 	}{
-		Filesystem:    &fs,
-		EscapedDevice: unit.UnitNamePathEscape(fs.Device),
-		Remote:        remote,
+		Filesystem:     &fs,
+		Command:        command,
+		MountPoint:     unitPathValue(*fs.Path),
+		MountUnit:      mountUnit,
+		NoUnitComments: options.NoUnitComments,
+	}
+	contents := strings.Builder{}
+	err := resizeUnitTemplate.Execute(&contents, context)
+	if err != nil {
+		panic(err)
+	}
+	return types.Unit{
+		Name:     generatedUnitName(strings.TrimSuffix(mountUnit, ".mount")+"-growfs.service", options),
+		Enabled:  util.BoolToPtr(true),
+		Contents: util.StrToPtr(contents.String()),
+	}
+}
+
+// generatedUnitName returns name with options.GeneratedUnitPrefix.  It's
+// used for the growfs and tree mtimes services, .network files, and
+// quadlet files, from which Podman names the container services.  Mount
+// and swap units don't use this, since systemd derives their names from
+// their paths, and timer units are named by their entries.
+func generatedUnitName(name string, options common.TranslateOptions) string {
+	return options.GeneratedUnitPrefix + name
+}
+
+var plainExecArgumentRe = regexp.MustCompile(`^[A-Za-z0-9/_.,:@+=-]+$`)
+
+// resizeCommand returns the ExecStart= command line to grow the mounted
+// filesystem, or false if the format doesn't support it.  The device or
+// mount point is quoted and escaped if it contains characters systemd
+// would interpret.
+func resizeCommand(format, device, mountPoint string) (string, bool) {
+	arg := func(s string) string {
+		if plainExecArgumentRe.MatchString(s) {
+			return s
+		}
+		return execArgument(s)
+	}
+	switch format {
+	case "btrfs":
+		return "/usr/sbin/btrfs filesystem resize max " + arg(mountPoint), true
+	case "ext4":
+		return "/usr/sbin/resize2fs " + arg(device), true
+	case "xfs":
+		return "/usr/sbin/xfs_growfs " + arg(mountPoint), true
+	}
+	return "", false
+}
+
+// addParentDirs adds directories with storage.create_parent_dirs_mode for
+// the undeclared ancestors of every file.  / and top-level directories are
+// skipped since they always exist.
+func (c Config) addParentDirs(config *types.Config) translate.TranslationSet {
+	ts := translate.NewTranslationSet("yaml", "json")
+	if c.Storage.CreateParentDirsMode == nil {
+		return ts
+	}
+	yamlPath := path.New("yaml", "storage", "create_parent_dirs_mode")
+	t := newNodeTracker(config)
+	for _, file := range config.Storage.Files {
+		var parents []string
+		for dir := slashpath.Dir(slashpath.Clean(file.Path)); strings.Count(dir, "/") > 1; dir = slashpath.Dir(dir) {
+			parents = append(parents, dir)
+		}
+		// add from the top down
+		for j := len(parents) - 1; j >= 0; j-- {
+			if t.Exists(parents[j]) {
+				continue
+			}
+			i, dir := t.AddDir(types.Directory{
+				Node: types.Node{
+					Path: parents[j],
+				},
+				DirectoryEmbedded1: types.DirectoryEmbedded1{
+					Mode: util.IntToPtr(*c.Storage.CreateParentDirsMode),
+				},
+			})
+			ts.AddFromCommonSource(yamlPath, path.New("json", "storage", "directories", i), *dir)
+			if i == 0 {
+				ts.AddTranslation(yamlPath, path.New("json", "storage", "directories"))
+			}
+		}
+	}
+	return ts
+}
+
+// applyDefaultOwner sets the user and group of nodes without them to
+// storage.default_owner.  Only nodes from files, directories, links, and
+// trees entries are affected; nodes Butane generates for other sections,
+// such as parent directories and network configs, keep the Ignition
+// default.
+func (c Config) applyDefaultOwner(config *types.Config, ts translate.TranslationSet) translate.TranslationSet {
+	ret := translate.NewTranslationSet("yaml", "json")
+	owner := c.Storage.DefaultOwner
+	if owner == nil {
+		return ret
+	}
+	yamlPath := path.New("yaml", "storage", "default_owner")
+	apply := func(nodePath path.ContextPath, node *types.Node) {
+		from, ok := ts.Lookup(nodePath)
+		if !ok || from.From.Len() < 2 || from.From.Path[0] != "storage" {
+			return
+		}
+		switch from.From.Path[1] {
+		case "files", "directories", "links", "trees":
+		default:
+			return
+		}
+		if (owner.User.ID != nil || owner.User.Name != nil) && node.User.ID == nil && node.User.Name == nil {
+			node.User = types.NodeUser{
+				ID:   owner.User.ID,
+				Name: owner.User.Name,
+			}
+			ret.AddFromCommonObject(yamlPath.Append("user"), nodePath.Append("user"), node.User)
+		}
+		if (owner.Group.ID != nil || owner.Group.Name != nil) && node.Group.ID == nil && node.Group.Name == nil {
+			node.Group = types.NodeGroup{
+				ID:   owner.Group.ID,
+				Name: owner.Group.Name,
+			}
+			ret.AddFromCommonObject(yamlPath.Append("group"), nodePath.Append("group"), node.Group)
+		}
+	}
+	for i := range config.Storage.Files {
+		apply(path.New("json", "storage", "files", i), &config.Storage.Files[i].Node)
+	}
+	for i := range config.Storage.Directories {
+		apply(path.New("json", "storage", "directories", i), &config.Storage.Directories[i].Node)
+	}
+	for i := range config.Storage.Links {
+		apply(path.New("json", "storage", "links", i), &config.Storage.Links[i].Node)
+	}
+	return ret
+}
+
+// addRecursiveDirs adds directories for the undeclared ancestors of each
+// storage.directories entry with recursive set, using the entry's mode.
+// / and top-level directories are skipped since they always exist.
+func (c Config) addRecursiveDirs(config *types.Config) (translate.TranslationSet, report.Report) {
+	ts := translate.NewTranslationSet("yaml", "json")
+	var r report.Report
+	t := newNodeTracker(config)
+	for i, from := range c.Storage.Directories {
+		if !util.IsTrue(from.Recursive) {
+			continue
+		}
+		yamlPath := path.New("yaml", "storage", "directories", i, "recursive")
+		var parents []string
+		for dir := slashpath.Dir(slashpath.Clean(from.Path)); strings.Count(dir, "/") > 1; dir = slashpath.Dir(dir) {
+			parents = append(parents, dir)
+		}
+		// add from the top down
+		for j := len(parents) - 1; j >= 0; j-- {
+			if _, dir := t.GetDir(parents[j]); dir != nil {
+				continue
+			}
+			if t.Exists(parents[j]) {
+				r.AddOnError(yamlPath, fmt.Errorf("%s: %w", parents[j], common.ErrNodeExists))
+				break
+			}
+			dir := types.Directory{
+				Node: types.Node{
+					Path: parents[j],
+				},
+			}
+			if from.Mode != nil {
+				dir.Mode = util.IntToPtr(*from.Mode)
+			}
+			k, added := t.AddDir(dir)
+			ts.AddFromCommonSource(yamlPath, path.New("json", "storage", "directories", k), *added)
+		}
+	}
+	return ts, r
+}
+
+// checkExplicitModes reports an error for each file and directory in the
+// translated config without a mode, at the Butane config path which
+// produced it, so generated nodes are checked too.  Files given a default
+// mode by a tree are reported by walkTree.  Links have no mode.
+func checkExplicitModes(config types.Config, ts translate.TranslationSet) (r report.Report) {
+	reported := make(map[string]bool)
+	check := func(mode *int, p path.ContextPath) {
+		if mode != nil {
+			return
+		}
+		if from, ok := ts.Lookup(p); ok {
+			p = from.From
+		}
+		// recursive parents share the path of their entry
+		if !reported[p.String()] {
+			reported[p.String()] = true
+			r.AddOnError(p, common.ErrModeNotExplicit)
+		}
+	}
+	for i, file := range config.Storage.Files {
+		check(file.Mode, path.New("json", "storage", "files", i))
+	}
+	for i, dir := range config.Storage.Directories {
+		check(dir.Mode, path.New("json", "storage", "directories", i))
+	}
+	return
+}
+
+// checkReadOnlyMounts warns about storage nodes within filesystems which
+// Ignition mounts read-only.  Only the innermost filesystem containing a
+// node is considered.
+func checkReadOnlyMounts(config types.Config) (r report.Report) {
+	var mountPoints []string
+	var readOnly []bool
+	for _, fs := range config.Storage.Filesystems {
+		if util.NilOrEmpty(fs.Path) {
+			continue
+		}
+		mountPoints = append(mountPoints, *fs.Path)
+		readOnly = append(readOnly, hasReadOnlyOption(fs.MountOptions))
+	}
+	check := func(p string, c path.ContextPath) {
+		if i := baseutil.InnermostMount(p, mountPoints); i >= 0 && readOnly[i] {
+			r.AddOnWarn(c, common.ErrNodeUnderReadOnlyMount)
+		}
+	}
+	for i, file := range config.Storage.Files {
+		check(file.Path, path.New("json", "storage", "files", i, "path"))
+	}
+	for i, dir := range config.Storage.Directories {
+		check(dir.Path, path.New("json", "storage", "directories", i, "path"))
+	}
+	for i, link := range config.Storage.Links {
+		check(link.Path, path.New("json", "storage", "links", i, "path"))
+	}
+	return
+}
+
+// hasReadOnlyOption returns true if the mount options include ro, either
+// directly or in a comma-separated list, and it isn't overridden by a
+// later rw.
+func hasReadOnlyOption(options []types.MountOption) bool {
+	readOnly := false
+	for _, option := range options {
+		for _, opt := range strings.Split(string(option), ",") {
+			switch strings.TrimSpace(opt) {
+			case "ro":
+				readOnly = true
+			case "rw":
+				readOnly = false
+			}
+		}
+	}
+	return readOnly
+}
+
+const systemdSystemDir = "/etc/systemd/system"
+
+// checkDropinParents warns about dropins whose parent unit is neither
+// declared in the config nor in known.  Dropins are either attached to
+// a unit entry without contents or written as files in a unit's ".d"
+// directory under systemdSystemDir.  Dropin directories which apply to
+// a group of units, such as "service.d" or "foo-.service.d", are
+// ignored.
+func checkDropinParents(config types.Config, known []string) (r report.Report) {
+	declared := make(map[string]struct{})
+	for _, unit := range known {
+		declared[unit] = struct{}{}
+	}
+	for _, unit := range config.Systemd.Units {
+		if unit.Contents != nil {
+			declared[unit.Name] = struct{}{}
+		}
+	}
+	for _, file := range config.Storage.Files {
+		if slashpath.Dir(file.Path) == systemdSystemDir {
+			declared[slashpath.Base(file.Path)] = struct{}{}
+		}
+	}
+	for _, link := range config.Storage.Links {
+		if slashpath.Dir(link.Path) == systemdSystemDir {
+			declared[slashpath.Base(link.Path)] = struct{}{}
+		}
+	}
+	isDeclared := func(unit string) bool {
+		if _, ok := declared[unit]; ok {
+			return true
+		}
+		// instances are also configured by their template
+		at, dot := strings.Index(unit, "@"), strings.LastIndex(unit, ".")
+		if at >= 0 && dot > at {
+			if _, ok := declared[unit[:at+1]+unit[dot:]]; ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	for i, file := range config.Storage.Files {
+		dir := slashpath.Dir(file.Path)
+		if slashpath.Dir(dir) != systemdSystemDir || !strings.HasSuffix(dir, ".d") || !strings.HasSuffix(file.Path, ".conf") {
+			continue
+		}
+		unit := strings.TrimSuffix(slashpath.Base(dir), ".d")
+		dot := strings.LastIndex(unit, ".")
+		if dot <= 0 || strings.HasSuffix(unit[:dot], "-") {
+			// applies to a group of units
+			continue
+		}
+		if !isDeclared(unit) {
+			r.AddOnWarn(path.New("json", "storage", "files", i, "path"), common.ErrDropinParentUnknown)
+		}
+	}
+	for i, unit := range config.Systemd.Units {
+		if unit.Contents == nil && len(unit.Dropins) > 0 && !isDeclared(unit.Name) {
+			r.AddOnWarn(path.New("json", "systemd", "units", i, "name"), common.ErrDropinParentUnknown)
+		}
+	}
+	return
+}
+
+// the type-specific section of each unit type
+var unitTypeSections = map[string]string{
+	".automount": "Automount",
+	".device":    "",
+	".mount":     "Mount",
+	".path":      "Path",
+	".scope":     "Scope",
+	".service":   "Service",
+	".slice":     "Slice",
+	".socket":    "Socket",
+	".swap":      "Swap",
+	".target":    "",
+	".timer":     "Timer",
+}
+
+var unitOptionNameRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// checkUnitContents reports unit and dropin contents which parse but which
+// systemd would partly ignore: options before the first section header,
+// malformed option names, and sections not valid for the unit type.
+// Contents which don't parse at all are left to Ignition's validation.
+// Problems are reported at the source of the contents in ts, such as
+// contents_local or the entry which generated the unit, since the
+// translation set is discarded if the report is fatal.
+func checkUnitContents(config types.Config, ts translate.TranslationSet, fail bool) (r report.Report) {
+	add := func(c path.ContextPath, err error) {
+		if from, ok := ts.Lookup(c); ok {
+			c = from.From
+		}
+		if fail {
+			r.AddOnError(c, err)
+		} else {
+			r.AddOnWarn(c, err)
+		}
+	}
+	check := func(c path.ContextPath, name, contents string) {
+		opts, err := unit.DeserializeOptions(strings.NewReader(contents))
+		if err != nil {
+			return
+		}
+		// the parser silently drops options before the first section
+		for i, line := range strings.Split(contents, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+				continue
+			}
+			if !strings.HasPrefix(line, "[") {
+				add(c, fmt.Errorf("%w: line %d", common.ErrUnitOptionOutsideSection, i+1))
+			}
+			break
+		}
+		typeSection, knownType := unitTypeSections[slashpath.Ext(name)]
+		reported := make(map[string]bool)
+		for _, opt := range opts {
+			if !unitOptionNameRe.MatchString(opt.Name) {
+				add(c, fmt.Errorf("%w: %q in [%s]", common.ErrUnitOptionNameInvalid, opt.Name, opt.Section))
+			}
+			if !knownType || reported[opt.Section] {
+				continue
+			}
+			switch {
+			case opt.Section == "Unit", opt.Section == "Install", strings.HasPrefix(opt.Section, "X-"):
+			case typeSection != "" && opt.Section == typeSection:
+			default:
+				reported[opt.Section] = true
+				add(c, fmt.Errorf("%w: [%s]", common.ErrUnitSectionUnknown, opt.Section))
+			}
+		}
+	}
+
+	for i, u := range config.Systemd.Units {
+		if u.Contents != nil {
+			check(path.New("json", "systemd", "units", i, "contents"), u.Name, *u.Contents)
+		}
+		for j, dropin := range u.Dropins {
+			if dropin.Contents != nil {
+				check(path.New("json", "systemd", "units", i, "dropins", j, "contents"), u.Name, *dropin.Contents)
+			}
+		}
+	}
+	return
+}
+
+// checkLinkTargets warns about symlinks with absolute targets that aren't
+// a node in the config and aren't within a declared filesystem or one of
+// known.  Relative targets and hard links are ignored.
+func checkLinkTargets(config types.Config, known []string) (r report.Report) {
+	nodes := make(map[string]struct{})
+	for _, file := range config.Storage.Files {
+		nodes[slashpath.Clean(file.Path)] = struct{}{}
+	}
+	for _, dir := range config.Storage.Directories {
+		nodes[slashpath.Clean(dir.Path)] = struct{}{}
+	}
+	for _, link := range config.Storage.Links {
+		nodes[slashpath.Clean(link.Path)] = struct{}{}
+	}
+	roots := append([]string(nil), known...)
+	for _, fs := range config.Storage.Filesystems {
+		if !util.NilOrEmpty(fs.Path) {
+			roots = append(roots, *fs.Path)
+		}
+	}
+
+	for i, link := range config.Storage.Links {
+		if util.IsTrue(link.Hard) || link.Target == nil || !slashpath.IsAbs(*link.Target) {
+			continue
+		}
+		target := slashpath.Clean(*link.Target)
+		if _, ok := nodes[target]; ok {
+			continue
+		}
+		if baseutil.InnermostMount(target, roots) >= 0 {
+			continue
+		}
+		r.AddOnWarn(path.New("json", "storage", "links", i, "target"), common.ErrLinkTargetUnknown)
+	}
+	return
+}
+
+// hasMountOption returns true if the mount options include name, either
+// directly or in a comma-separated list.
+func hasMountOption(options []string, name string) bool {
+	for _, option := range options {
+		for _, opt := range strings.Split(option, ",") {
+			if strings.TrimSpace(opt) == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RewriteNodePaths applies options.PathRewriter and then
+// options.PathPrefix to the paths of all storage nodes, and to the
+// targets of hard links, which are resolved on the target filesystem.
+// options.PathPrefix alone is also applied to filesystem mount points.
+// Problems are reported at the source of each path in ts.
+// ToIgn3_5Unvalidated calls it on the config it returns; variants call it
+// on nodes and filesystems they add afterward.
+func RewriteNodePaths(config *types.Config, ts translate.TranslationSet, options common.TranslateOptions) (r report.Report) {
+	if options.PathRewriter == nil && options.PathPrefix == "" {
+		return
+	}
+	rewrite := func(p string, c path.ContextPath) string {
+		rewritten, err := rewriteNodePath(p, options)
+		if err != nil {
+			// the translation set is discarded if the report is
+			// fatal, so report at the source path directly
+			if from, ok := ts.Lookup(c); ok {
+				c = from.From
+			}
+			r.AddOnError(c, err)
+			return p
+		}
+		return rewritten
+	}
+	for i := range config.Storage.Files {
+		file := &config.Storage.Files[i]
+		file.Path = rewrite(file.Path, path.New("json", "storage", "files", i, "path"))
+	}
+	for i := range config.Storage.Directories {
+		dir := &config.Storage.Directories[i]
+		dir.Path = rewrite(dir.Path, path.New("json", "storage", "directories", i, "path"))
+	}
+	for i := range config.Storage.Links {
+		link := &config.Storage.Links[i]
+		link.Path = rewrite(link.Path, path.New("json", "storage", "links", i, "path"))
+		if util.IsTrue(link.Hard) && link.Target != nil && slashpath.IsAbs(*link.Target) {
+			link.Target = util.StrToPtr(rewrite(*link.Target, path.New("json", "storage", "links", i, "target")))
+		}
+	}
+	if options.PathPrefix != "" {
+		// generated mount units already use the prefixed path
+		for i := range config.Storage.Filesystems {
+			fs := &config.Storage.Filesystems[i]
+			if util.NotEmpty(fs.Path) {
+				fs.Path = util.StrToPtr(slashpath.Join(options.PathPrefix, *fs.Path))
+			}
+		}
+	}
+	return
+}
+
+// rewriteNodePath applies options.PathRewriter and then
+// options.PathPrefix to the node path p.
+func rewriteNodePath(p string, options common.TranslateOptions) (string, error) {
+	if options.PathRewriter != nil {
+		rewritten, err := options.PathRewriter(p)
+		if err != nil {
+			return "", err
+		}
+		if !slashpath.IsAbs(rewritten) {
+			return "", fmt.Errorf("%w: %q", common.ErrPathRewriteNotAbsolute, rewritten)
+		}
+		p = rewritten
+	}
+	if options.PathPrefix != "" {
+		p = slashpath.Join(options.PathPrefix, p)
+	}
+	return p, nil
+}
+
+// dataURLChunkSize returns the number of bytes which can be base64-encoded
+// into a data URL no longer than max.
+func dataURLChunkSize(max int) int {
+	return (max - len("data:;base64,")) / 4 * 3
+}
+
+// splitDataURLs replaces the data URLs of file contents and appends which
+// are longer than options.MaxDataURLSize with a series of shorter ones in
+// the file's append list, which Ignition concatenates to produce the same
+// file.
+func splitDataURLs(config *types.Config, ts *translate.TranslationSet, options common.TranslateOptions) (r report.Report) {
+	// the smallest encoding of a chunk is no longer than its base64
+	// encoding, which fits
+	chunkSize := dataURLChunkSize(options.MaxDataURLSize)
+	chunkOptions := options
+	chunkOptions.ReadableDataURLs = false
+	chunkOptions.CompressionPolicy = nil
+	for i := range config.Storage.Files {
+		file := &config.Storage.Files[i]
+		filePath := path.New("json", "storage", "files", i)
+		type part struct {
+			res types.Resource
+			// translations relative to the resource
+			ts translate.TranslationSet
+		}
+		var parts []part
+		var split bool
+		// the first part stays in contents, if there is one
+		var resources []types.Resource
+		var resourcePaths []path.ContextPath
+		if file.Contents.Source != nil {
+			resources = append(resources, file.Contents)
+			resourcePaths = append(resourcePaths, filePath.Append("contents"))
+		}
+		for j, res := range file.Append {
+			resources = append(resources, res)
+			resourcePaths = append(resourcePaths, filePath.Append("append", j))
+		}
+		for k, res := range resources {
+			resTranslations := ts.Descend(resourcePaths[k])
+			if res.Source == nil || !strings.HasPrefix(*res.Source, "data:") || len(*res.Source) <= options.MaxDataURLSize {
+				parts = append(parts, part{res, resTranslations})
+				continue
+			}
+			sourcePath := resourcePaths[k].Append("source")
+			if res.Verification.Hash != nil {
+				r.AddOnWarn(sourcePath, common.ErrSplitVerification)
+				parts = append(parts, part{res, resTranslations})
+				continue
+			}
+			decoded, err := dataurl.DecodeString(*res.Source)
+			if err != nil {
+				r.AddOnError(sourcePath, err)
+				continue
+			}
+			contents := decoded.Data
+			if util.NotEmpty(res.Compression) && *res.Compression == "gzip" {
+				if contents, err = baseutil.GunzipBytes(contents); err != nil {
+					r.AddOnError(sourcePath, err)
+					continue
+				}
+			}
+			// every source has a translation
+			from, _ := ts.Lookup(sourcePath)
+			for len(contents) > 0 {
+				n := chunkSize
+				if n > len(contents) {
+					n = len(contents)
+				}
+				uri, compression, err := makeDataURL(contents[:n], nil, chunkOptions)
+				if err != nil {
+					r.AddOnError(sourcePath, err)
+					break
+				}
+				chunk := types.Resource{
+					Source:      util.StrToPtr(uri),
+					Compression: compression,
+				}
+				chunkTranslations := translate.NewTranslationSet("yaml", "json")
+				chunkTranslations.AddFromCommonSource(from.From, path.New("json"), chunk)
+				parts = append(parts, part{chunk, chunkTranslations})
+				contents = contents[n:]
+			}
+			split = true
+		}
+		if !split {
+			continue
+		}
+
+		// replace the translations of the old resources
+		for _, p := range resourcePaths {
+			for _, t := range ts.Descend(p).Set {
+				delete(ts.Set, p.Append(t.To.Path...).String())
+			}
+		}
+		appendFrom, hadAppends := ts.Lookup(filePath.Append("append"))
+		if !hadAppends {
+			// use the file's source, which every node has
+			appendFrom, _ = ts.Lookup(filePath)
+		}
+		file.Append = nil
+		for k, p := range parts {
+			resPath := filePath.Append("append", len(file.Append))
+			if k == 0 && file.Contents.Source != nil {
+				file.Contents = p.res
+				resPath = filePath.Append("contents")
+			} else {
+				file.Append = append(file.Append, p.res)
+			}
+			ts.Merge(p.ts.PrefixPaths(path.New("yaml"), resPath))
+		}
+		if len(file.Append) > 0 {
+			ts.AddTranslation(appendFrom.From, filePath.Append("append"))
+		}
+	}
+	return
+}
+
+// sortUnits sorts the systemd units of config by name, keeping units with
+// the same name in their existing order, and returns ts adjusted to match.
+func sortUnits(config *types.Config, ts translate.TranslationSet) translate.TranslationSet {
+	units := config.Systemd.Units
+	order := make([]int, len(units))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return units[order[i]].Name < units[order[j]].Name
+	})
+	sorted := make([]types.Unit, len(units))
+	for i, old := range order {
+		sorted[i] = units[old]
+	}
+	config.Systemd.Units = sorted
+	return ts.Reorder(path.New("json", "systemd", "units"), order)
+}
+
+// luksVolume returns the LUKS volume which device refers to by the name
+// it's opened with, if any.
+func (c Config) luksVolume(device string) (Luks, bool) {
+	if !strings.HasPrefix(device, "/dev/mapper/") && !strings.HasPrefix(device, "/dev/disk/by-id/dm-name-") {
+		return Luks{}, false
+	}
+	for _, luks := range c.Storage.Luks {
+		if device == fmt.Sprintf("/dev/mapper/%s", luks.Name) || device == fmt.Sprintf("/dev/disk/by-id/dm-name-%s", luks.Name) {
+			return luks, true
+		}
+	}
+	return Luks{}, false
+}
+
+// luksBackingVolume returns the LUKS volume whose underlying device is
+// device, either by path or by referencing the same partition declared in
+// storage.disks, such as by partition label.
+func (c Config) luksBackingVolume(device string) (Luks, bool) {
+	for _, luks := range c.Storage.Luks {
+		if luks.Device != nil && c.sameDevice(device, *luks.Device) {
+			return luks, true
+		}
+	}
+	return Luks{}, false
+}
+
+// mountUnitFromFS returns a mount or swap unit for fs.  If luksName is
+// set, fs is on that LUKS device, and the unit waits for it to be opened.
+func mountUnitFromFS(fs Filesystem, remote bool, luksName string, options common.TranslateOptions) types.Unit {
+	context := struct {
+		*Filesystem
+		CryptsetupUnit string
+		EscapedDevice  string
+		FormatUnit     string
+		MountOptions   []string
+		NoUnitComments bool
+		Remote         bool
+		Swap           bool
+		Type           string
+		Wanted         bool
+		What           string
+		Where          string
+	}{
+		Filesystem:     &fs,
+		EscapedDevice:  unit.UnitNamePathEscape(fs.Device),
+		FormatUnit:     formatUnitName(fs, options),
+		MountOptions:   mountOptions(fs),
+		NoUnitComments: options.NoUnitComments,
+		Remote:         remote,
 		// unchecked deref of format ok, fs would fail validation otherwise
 		Swap: *fs.Format == "swap",
+		Type: mountType(fs),
+		What: unitPathValue(fs.Device),
+	}
+	if fs.Path != nil {
+		context.Where = unitPathValue(*fs.Path)
+	}
+	// a nofail mount shouldn't fail its target by default
+	if fs.MountInstallRequires != nil {
+		context.Wanted = !*fs.MountInstallRequires
+	} else {
+		context.Wanted = hasMountOption(context.MountOptions, "nofail")
+	}
+	if luksName != "" {
+		context.CryptsetupUnit = "systemd-cryptsetup@" + unit.UnitNameEscape(luksName) + ".service"
 	}
 	contents := strings.Builder{}
 	err := mountUnitTemplate.Execute(&contents, context)
 	if err != nil {
 		panic(err)
 	}
-	var unitName string
-	if context.Swap {
-		unitName = unit.UnitNamePathEscape(fs.Device) + ".swap"
-	} else {
-		// unchecked deref of path ok, fs would fail validation otherwise
-		unitName = unit.UnitNamePathEscape(*fs.Path) + ".mount"
-	}
 	return types.Unit{
-		Name:     unitName,
+		Name:     mountUnitName(fs),
 		Enabled:  util.BoolToPtr(true),
 		Contents: util.StrToPtr(contents.String()),
 	}
 }
+
+// formatUnitName returns the name of the systemd unit which formats the
+// device of fs, if fs is wiped and the mount should be ordered after it.
+// Ignition itself formats filesystems before the generated units start,
+// but with the ordering a mount can't race a systemd-makefs or
+// systemd-mkswap job pulled in by something else.
+func formatUnitName(fs Filesystem, options common.TranslateOptions) string {
+	if !options.OrderMountsAfterFormat || !util.IsTrue(fs.WipeFilesystem) {
+		return ""
+	}
+	// unchecked deref ok, fs would fail validation otherwise
+	if *fs.Format == "swap" {
+		return "systemd-mkswap@" + unit.UnitNamePathEscape(fs.Device) + ".service"
+	}
+	return "systemd-makefs@" + unit.UnitNamePathEscape(fs.Device) + ".service"
+}
+
+// mountUnitName returns the name of the swap or mount unit for fs, which
+// is also the name systemd-fstab-generator gives its fstab entry.
+func mountUnitName(fs Filesystem) string {
+	// unchecked derefs ok, fs would fail validation otherwise
+	if *fs.Format == "swap" {
+		return unit.UnitNamePathEscape(fs.Device) + ".swap"
+	}
+	return unit.UnitNamePathEscape(*fs.Path) + ".mount"
+}
+
+// mountOptions returns the mount options of fs, followed by its systemd
+// mount options in sorted order.
+func mountOptions(fs Filesystem) []string {
+	ret := append([]string(nil), fs.MountOptions...)
+	// sort for deterministic output
+	var keys []string
+	for key := range fs.SystemdMountOptions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if value := fs.SystemdMountOptions[key]; value != "" {
+			key += "=" + value
+		}
+		ret = append(ret, key)
+	}
+	return ret
+}
+
+// mountType returns the filesystem type to mount fs with.
+func mountType(fs Filesystem) string {
+	if fs.MountType != nil {
+		return *fs.MountType
+	}
+	// unchecked deref of format ok, fs would fail validation otherwise
+	return *fs.Format
+}
+
+// fstabLine returns the /etc/fstab entry for fs, including the trailing
+// newline.
+func fstabLine(fs Filesystem, remote bool, luksName string, options common.TranslateOptions) string {
+	opts := mountOptions(fs)
+	if remote {
+		opts = append(opts, "_netdev")
+	}
+	if luksName != "" {
+		opts = append(opts, "x-systemd.requires=systemd-cryptsetup@"+unit.UnitNameEscape(luksName)+".service")
+	}
+	if formatUnit := formatUnitName(fs, options); formatUnit != "" {
+		opts = append(opts, "x-systemd.after="+formatUnit)
+	}
+	for _, u := range fs.MountBefore {
+		opts = append(opts, "x-systemd.before="+u)
+	}
+	for _, u := range fs.MountAfter {
+		opts = append(opts, "x-systemd.after="+u)
+	}
+	if len(opts) == 0 {
+		opts = []string{"defaults"}
+	}
+	// swap isn't fsck'd; everything else is checked after the root
+	// filesystem, as with the systemd-fsck dependency of mount units
+	where, typ, pass := "none", "swap", 0
+	if *fs.Format != "swap" {
+		where, typ, pass = *fs.Path, mountType(fs), 2
+	}
+	for i, opt := range opts {
+		opts[i] = fstabEscape(opt)
+	}
+	return fmt.Sprintf("%s %s %s %s 0 %d\n", fstabEscape(fs.Device), fstabEscape(where), fstabEscape(typ), strings.Join(opts, ","), pass)
+}
+
+// fstabEscape returns s with whitespace and backslashes octal-escaped,
+// for use as an fstab field.
+func fstabEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case ' ', '\t', '\n', '\\':
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// unitPathValue returns p with systemd specifiers escaped, for use as a
+// What= or Where= value.  These take a path, not a unit name, and
+// systemd doesn't unescape them, so unit name escaping (as used for
+// the fsck service) would change the path.  Spaces are fine, and other
+// characters which would break unit parsing are rejected by validation.
+func unitPathValue(p string) string {
+	return strings.ReplaceAll(p, "%", "%%")
+}
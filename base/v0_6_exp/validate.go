@@ -15,14 +15,92 @@
 package v0_6_exp
 
 import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"net"
+	slashpath "path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
 	baseutil "github.com/coreos/butane/base/util"
 	"github.com/coreos/butane/config/common"
 
+	"github.com/coreos/go-systemd/v22/unit"
+	"github.com/coreos/ignition/v2/config/shared/errors"
 	"github.com/coreos/ignition/v2/config/util"
 	"github.com/coreos/vcontext/path"
 	"github.com/coreos/vcontext/report"
 )
 
+var (
+	// a single token with no whitespace or unit-file metacharacters
+	mountTypeRe = regexp.MustCompile(`^[^\s;#"'\\]+$`)
+
+	// a unit name without a type suffix or template instance
+	timerNameRe = regexp.MustCompile(`^[A-Za-z0-9:_\\-]+$`)
+
+	// a unit name with a type suffix, optionally a template instance
+	unitNameRe = regexp.MustCompile(`^[A-Za-z0-9:_.\\-]+(@[A-Za-z0-9:_.\\-]*)?\.(automount|device|mount|path|scope|service|slice|socket|swap|target|timer)$`)
+
+	// characters of a systemd calendar event, including shorthands
+	// such as "daily"; full parsing is left to systemd
+	onCalendarRe = regexp.MustCompile(`^[A-Za-z0-9*,./:~+\- ]+$`)
+
+	// a kernel interface name: at most IFNAMSIZ-1 bytes, without
+	// slashes, colons, or whitespace
+	networkNameRe = regexp.MustCompile(`^[^/:\s]{1,15}$`)
+
+	// an environment variable name
+	envNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// a file name; the .raw suffix is added automatically
+	extensionNameRe = regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+
+	// writable search directories for each extension type; the first
+	// is the default
+	extensionDirs = map[string][]string{
+		"sysext":  {"/var/lib/extensions", "/etc/extensions", "/run/extensions"},
+		"confext": {"/var/lib/confexts", "/etc/confexts", "/run/confexts"},
+	}
+
+	// a hostname or domain in no_proxy, optionally with a leading "." or
+	// "*." to match subdomains
+	noProxyHostRe = regexp.MustCompile(`^(\*?\.)?[A-Za-z0-9_]([A-Za-z0-9_-]*[A-Za-z0-9_])?(\.[A-Za-z0-9_]([A-Za-z0-9_-]*[A-Za-z0-9_])?)*$`)
+
+	// hash functions accepted by Ignition, and their digest sizes
+	hashSizes = map[string]int{
+		"sha256": sha256.Size,
+		"sha512": sha512.Size,
+	}
+
+	// names and values must not split the Options= list or the
+	// name=value pair
+	mountOptionNameRe  = regexp.MustCompile(`^[^\s,=]+$`)
+	mountOptionValueRe = regexp.MustCompile(`^[^\s,]*$`)
+
+	// known systemd mount options, and whether they take a value
+	knownSystemdMountOptions = map[string]bool{
+		"x-systemd.after":               true,
+		"x-systemd.automount":           false,
+		"x-systemd.before":              true,
+		"x-systemd.device-bound":        false,
+		"x-systemd.device-timeout":      true,
+		"x-systemd.growfs":              false,
+		"x-systemd.idle-timeout":        true,
+		"x-systemd.makefs":              false,
+		"x-systemd.mount-timeout":       true,
+		"x-systemd.required-by":         true,
+		"x-systemd.requires":            true,
+		"x-systemd.requires-mounts-for": true,
+		"x-systemd.rw-only":             false,
+		"x-systemd.wanted-by":           true,
+	}
+)
+
 func (rs Resource) Validate(c path.ContextPath) (r report.Report) {
 	var field string
 	sources := 0
@@ -41,13 +119,169 @@ func (rs Resource) Validate(c path.ContextPath) (r report.Report) {
 	if sources > 1 {
 		r.AddOnError(c.Append(field), common.ErrTooManyResourceSources)
 	}
+	if rs.Git != nil && sources > 0 {
+		r.AddOnError(c.Append("git"), common.ErrGitWithOtherSource)
+	}
+	if rs.Exec != nil && (sources > 0 || rs.Git != nil) {
+		r.AddOnError(c.Append("exec"), common.ErrExecWithOtherSource)
+	}
+	if rs.OCI != nil && (sources > 0 || rs.Git != nil || rs.Exec != nil) {
+		r.AddOnError(c.Append("oci"), common.ErrOCIWithOtherSource)
+	}
+	if rs.LineEndings != nil && *rs.LineEndings != "crlf" && *rs.LineEndings != "lf" {
+		r.AddOnError(c.Append("line_endings"), common.ErrLineEndings)
+	}
+	if rs.Source != nil && (rs.LineEndings != nil || util.IsTrue(rs.AddBOM)) {
+		field := "line_endings"
+		if rs.LineEndings == nil {
+			field = "add_bom"
+		}
+		r.AddOnError(c.Append(field), common.ErrTextTransformRemote)
+	}
+	return
+}
+
+func (v Verification) Validate(c path.ContextPath) (r report.Report) {
+	if v.Hash == nil {
+		return
+	}
+	c = c.Append("hash")
+	function, sum, ok := strings.Cut(*v.Hash, "-")
+	if !ok {
+		r.AddOnError(c, errors.ErrHashMalformed)
+		return
+	}
+	size, ok := hashSizes[function]
+	if !ok {
+		r.AddOnError(c, errors.ErrHashUnrecognized)
+		return
+	}
+	if len(sum) != hex.EncodedLen(size) {
+		r.AddOnError(c, errors.ErrHashWrongSize)
+	} else if _, err := hex.DecodeString(sum); err != nil {
+		r.AddOnError(c, common.ErrHashNotHex)
+	}
+	return
+}
+
+func (p Proxy) Validate(c path.ContextPath) (r report.Report) {
+	for i, entry := range p.NoProxy {
+		if !validNoProxyEntry(entry) {
+			r.AddOnError(c.Append("no_proxy", i), common.ErrNoProxyInvalid)
+		}
+	}
+	return
+}
+
+// validNoProxyEntry returns true if entry is "*", an IP address, a CIDR
+// range, or a hostname or domain, optionally followed by a port.
+func validNoProxyEntry(entry string) bool {
+	if entry == "*" {
+		return true
+	}
+	if strings.Contains(entry, "/") {
+		_, _, err := net.ParseCIDR(entry)
+		return err == nil
+	}
+	host := entry
+	if h, port, err := net.SplitHostPort(entry); err == nil {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return false
+		}
+		host = h
+	} else if strings.HasPrefix(entry, "[") {
+		return false
+	}
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	return noProxyHostRe.MatchString(host)
+}
+
+func (g GitResource) Validate(c path.ContextPath) (r report.Report) {
+	if g.URL == "" {
+		r.AddOnError(c.Append("url"), common.ErrGitURLRequired)
+	}
+	if g.Path == "" {
+		r.AddOnError(c.Append("path"), common.ErrGitPathRequired)
+	} else if slashpath.IsAbs(g.Path) || slashpath.Clean(g.Path) == ".." || strings.HasPrefix(slashpath.Clean(g.Path), "../") {
+		r.AddOnError(c.Append("path"), common.ErrGitPathInvalid)
+	}
+	return
+}
+
+func (o OCIResource) Validate(c path.ContextPath) (r report.Report) {
+	if o.Ref == "" {
+		r.AddOnError(c.Append("ref"), common.ErrOCIRefRequired)
+	}
+	if o.Digest != nil && !baseutil.ValidOCIDigest(*o.Digest) {
+		r.AddOnError(c.Append("digest"), common.ErrOCIDigestInvalid)
+	}
+	return
+}
+
+func (e ExecResource) Validate(c path.ContextPath) (r report.Report) {
+	if e.Command == "" {
+		r.AddOnError(c.Append("command"), common.ErrExecCommandRequired)
+	}
 	return
 }
 
 func (fs Filesystem) Validate(c path.ContextPath) (r report.Report) {
+	if util.IsTrue(fs.WipeFilesystem) && fs.Format != nil && *fs.Format == "none" {
+		r.AddOnError(c, common.ErrWipeFilesystemNone)
+	}
+	if fs.MountType != nil && !mountTypeRe.MatchString(*fs.MountType) {
+		r.AddOnError(c.Append("mount_type"), common.ErrMountUnitBadType)
+	}
+	for key, value := range fs.SystemdMountOptions {
+		c := c.Append("systemd_mount_options", key)
+		if !mountOptionNameRe.MatchString(key) {
+			r.AddOnError(c, common.ErrMountOptionBadName)
+			continue
+		}
+		if needsValue, ok := knownSystemdMountOptions[key]; !ok {
+			r.AddOnWarn(c, common.ErrMountOptionUnknown)
+		} else if needsValue && value == "" {
+			r.AddOnError(c, common.ErrMountOptionNeedsValue)
+		} else if !needsValue && value != "" {
+			r.AddOnError(c, common.ErrMountOptionNoValue)
+		}
+		if !mountOptionValueRe.MatchString(value) {
+			r.AddOnError(c, common.ErrMountOptionBadValue)
+		}
+	}
+	for _, order := range []struct {
+		name  string
+		units []string
+	}{
+		{"mount_before", fs.MountBefore},
+		{"mount_after", fs.MountAfter},
+	} {
+		for i, u := range order.units {
+			if !unitNameRe.MatchString(u) {
+				r.AddOnError(c.Append(order.name, i), common.ErrMountOrderUnitName)
+			}
+		}
+	}
+	if util.IsTrue(fs.Resize) {
+		if !util.IsTrue(fs.WithMountUnit) {
+			r.AddOnError(c.Append("resize"), common.ErrResizeNoMountUnit)
+		} else if fs.Format != nil {
+			if _, ok := resizeCommand(*fs.Format, fs.Device, ""); !ok {
+				r.AddOnError(c.Append("resize"), common.ErrResizeFormat)
+			}
+		}
+	}
 	if !util.IsTrue(fs.WithMountUnit) {
 		return
 	}
+	if !validUnitPathValue(fs.Device) {
+		r.AddOnError(c.Append("device"), common.ErrMountUnitBadPath)
+	}
+	if fs.Path != nil && !validUnitPathValue(*fs.Path) {
+		r.AddOnError(c.Append("path"), common.ErrMountUnitBadPath)
+	}
 	if util.NilOrEmpty(fs.Format) {
 		r.AddOnError(c.Append("format"), common.ErrMountUnitNoFormat)
 	} else if *fs.Format != "swap" && util.NilOrEmpty(fs.Path) {
@@ -56,6 +290,76 @@ func (fs Filesystem) Validate(c path.ContextPath) (r report.Report) {
 	return
 }
 
+func (l Luks) Validate(c path.ContextPath) (r report.Report) {
+	// a clevis section with no pins leaves the volume unbound
+	clevis := l.Clevis
+	present := clevis.Tang != nil || clevis.Tpm2 != nil || clevis.Threshold != nil ||
+		clevis.Custom.Pin != nil || clevis.Custom.Config != nil || clevis.Custom.NeedsNetwork != nil
+	pinned := len(clevis.Tang) > 0 || util.IsTrue(clevis.Tpm2) ||
+		util.NotEmpty(clevis.Custom.Pin) || util.NotEmpty(clevis.Custom.Config)
+	if present && !pinned {
+		r.AddOnError(c, common.ErrClevisNoPins)
+	}
+	if l.KeyFile.Inline != nil {
+		r.AddOnWarn(c.Append("key_file", "inline"), common.ErrLuksKeyFileEmbedded)
+	}
+	if l.KeyFile.Local != nil {
+		r.AddOnWarn(c.Append("key_file", "local"), common.ErrLuksKeyFileEmbedded)
+	}
+	return
+}
+
+func (s Storage) Validate(c path.ContextPath) (r report.Report) {
+	if s.CreateParentDirsMode != nil {
+		r.AddOnWarn(c.Append("create_parent_dirs_mode"), baseutil.CheckForDecimalMode(*s.CreateParentDirsMode, true))
+	}
+	if s.DefaultOwner != nil {
+		o := *s.DefaultOwner
+		if o.User.ID == nil && o.User.Name == nil && o.Group.ID == nil && o.Group.Name == nil {
+			r.AddOnWarn(c.Append("default_owner"), common.ErrDefaultOwnerEmpty)
+		}
+		// Ignition would report this for each node, or not at all if
+		// no node lacks an owner
+		if o.User.ID != nil && o.User.Name != nil {
+			r.AddOnError(c.Append("default_owner", "user"), common.ErrOwnerIDAndName)
+		}
+		if o.Group.ID != nil && o.Group.Name != nil {
+			r.AddOnError(c.Append("default_owner", "group"), common.ErrOwnerIDAndName)
+		}
+	}
+	r.Merge(s.validateNestedMounts(c))
+	return
+}
+
+// validateNestedMounts warns about mount units whose mount point is within
+// another declared filesystem which has no mount unit.  systemd orders
+// nested mount units itself, but nothing mounts the outer filesystem after
+// Ignition, so the inner one would be mounted over the wrong directory.
+func (s Storage) validateNestedMounts(c path.ContextPath) (r report.Report) {
+	for i, fs := range s.Filesystems {
+		if !util.IsTrue(fs.WithMountUnit) || util.NilOrEmpty(fs.Path) {
+			continue
+		}
+		// find the innermost other filesystem containing this one
+		parent := -1
+		for j, other := range s.Filesystems {
+			if j == i || util.NilOrEmpty(other.Path) || slashpath.Clean(*other.Path) == slashpath.Clean(*fs.Path) {
+				continue
+			}
+			if !baseutil.PathWithin(*fs.Path, *other.Path) {
+				continue
+			}
+			if parent == -1 || len(slashpath.Clean(*other.Path)) > len(slashpath.Clean(*s.Filesystems[parent].Path)) {
+				parent = j
+			}
+		}
+		if parent != -1 && !util.IsTrue(s.Filesystems[parent].WithMountUnit) {
+			r.AddOnWarn(c.Append("filesystems", i, "path"), fmt.Errorf("%w: %s", common.ErrMountParentNoUnit, c.Append("filesystems", parent, "path")))
+		}
+	}
+	return
+}
+
 func (d Directory) Validate(c path.ContextPath) (r report.Report) {
 	if d.Mode != nil {
 		r.AddOnWarn(c.Append("mode"), baseutil.CheckForDecimalMode(*d.Mode, true))
@@ -63,10 +367,46 @@ func (d Directory) Validate(c path.ContextPath) (r report.Report) {
 	return
 }
 
+func (e Extension) Validate(c path.ContextPath) (r report.Report) {
+	if !extensionNameRe.MatchString(e.Name) || e.Name == "." || e.Name == ".." || strings.HasSuffix(e.Name, ".raw") {
+		r.AddOnError(c.Append("name"), common.ErrExtensionName)
+	}
+	dirs, ok := extensionDirs[extensionType(e)]
+	if !ok {
+		r.AddOnError(c.Append("type"), common.ErrExtensionType)
+	} else if e.Directory != nil {
+		found := false
+		for _, dir := range dirs {
+			if slashpath.Clean(*e.Directory) == dir {
+				found = true
+				break
+			}
+		}
+		if !found {
+			r.AddOnError(c.Append("directory"), common.ErrExtensionDirectory)
+		}
+	}
+	if e.Contents.Source == nil && e.Contents.Local == nil && e.Contents.Inline == nil && e.Contents.Git == nil && e.Contents.Exec == nil && e.Contents.OCI == nil {
+		r.AddOnError(c.Append("contents"), common.ErrExtensionNoContents)
+	}
+	return
+}
+
 func (f File) Validate(c path.ContextPath) (r report.Report) {
 	if f.Mode != nil {
 		r.AddOnWarn(c.Append("mode"), baseutil.CheckForDecimalMode(*f.Mode, false))
 	}
+	contents := f.Contents.Source != nil || f.Contents.Inline != nil || f.Contents.Local != nil || f.Contents.Git != nil || f.Contents.Exec != nil || f.Contents.OCI != nil
+	if util.IsTrue(f.Overwrite) && contents && len(f.Append) > 0 {
+		r.AddOnError(c, common.ErrOverwriteWithAppend)
+	}
+	return
+}
+
+func (t ConfigTree) Validate(c path.ContextPath) (r report.Report) {
+	if t.Local == "" {
+		r.AddOnError(c, common.ErrTreeNoLocal)
+	}
 	return
 }
 
@@ -74,6 +414,216 @@ func (t Tree) Validate(c path.ContextPath) (r report.Report) {
 	if t.Local == "" {
 		r.AddOnError(c, common.ErrTreeNoLocal)
 	}
+	if t.Compression != nil && *t.Compression != "gzip" && *t.Compression != "none" {
+		r.AddOnError(c.Append("compression"), common.ErrTreeCompression)
+	}
+	if t.ModeFilter != nil && *t.ModeFilter != "executable" && *t.ModeFilter != "non-executable" && *t.ModeFilter != "all" {
+		r.AddOnError(c.Append("mode_filter"), common.ErrTreeModeFilter)
+	}
+	if t.OnSpecialFile != nil && *t.OnSpecialFile != "error" && *t.OnSpecialFile != "skip" {
+		r.AddOnError(c.Append("on_special_file"), common.ErrTreeOnSpecialFile)
+	}
+	return
+}
+
+func (u PasswdUser) Validate(c path.ContextPath) (r report.Report) {
+	if u.PasswordHash != nil && u.PasswordHashLocal != nil {
+		r.AddOnError(c.Append("password_hash_local"), common.ErrTooManyPasswordHashSources)
+	}
+	return
+}
+
+func (s Systemd) Validate(c path.ContextPath) (r report.Report) {
+	names := make(map[string]struct{})
+	for i, t := range s.Timers {
+		if _, ok := names[t.Name]; ok {
+			r.AddOnError(c.Append("timers", i, "name"), common.ErrTimerNameDuplicate)
+		}
+		names[t.Name] = struct{}{}
+	}
+	containers := make(map[string]struct{})
+	for i, ctr := range s.Containers {
+		if _, ok := containers[containerPath(ctr, "")]; ok {
+			r.AddOnError(c.Append("containers", i, "name"), common.ErrContainerNameDuplicate)
+		}
+		containers[containerPath(ctr, "")] = struct{}{}
+	}
+	networks := make(map[string]struct{})
+	for i, n := range s.Networks {
+		if _, ok := networks[n.Name]; ok {
+			r.AddOnError(c.Append("networks", i, "name"), common.ErrNetworkNameDuplicate)
+		}
+		networks[n.Name] = struct{}{}
+	}
+	units := make(map[string]struct{}, len(s.Units))
+	for _, u := range s.Units {
+		units[u.Name] = struct{}{}
+	}
+	enabled := make(map[string]struct{}, len(s.Presets.Enabled))
+	for i, name := range s.Presets.Enabled {
+		if _, ok := units[name]; !ok {
+			r.AddOnError(c.Append("presets", "enabled", i), common.ErrPresetUnitUndeclared)
+		}
+		enabled[name] = struct{}{}
+	}
+	for i, name := range s.Presets.Disabled {
+		if _, ok := units[name]; !ok {
+			r.AddOnError(c.Append("presets", "disabled", i), common.ErrPresetUnitUndeclared)
+		}
+		if _, ok := enabled[name]; ok {
+			r.AddOnError(c.Append("presets", "disabled", i), common.ErrPresetUnitConflict)
+		}
+	}
+	return
+}
+
+func (ctr Container) Validate(c path.ContextPath) (r report.Report) {
+	if !timerNameRe.MatchString(ctr.Name) {
+		r.AddOnError(c.Append("name"), common.ErrContainerNameInvalid)
+	}
+	if ctr.Image == "" {
+		r.AddOnError(c.Append("image"), common.ErrContainerNoImage)
+	} else if strings.IndexFunc(ctr.Image, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		r.AddOnError(c.Append("image"), common.ErrContainerBadImage)
+	}
+	for i, port := range ctr.Ports {
+		if !validContainerPort(port) {
+			r.AddOnError(c.Append("ports", i), common.ErrContainerPort)
+		}
+	}
+	for i, volume := range ctr.Volumes {
+		if !validContainerVolume(volume) {
+			r.AddOnError(c.Append("volumes", i), common.ErrContainerVolume)
+		}
+	}
+	for i, env := range ctr.Environment {
+		name, value, ok := strings.Cut(env, "=")
+		if !ok || !envNameRe.MatchString(name) || strings.ContainsAny(value, "\r\n") {
+			r.AddOnError(c.Append("environment", i), common.ErrContainerEnvironment)
+		}
+	}
+	if ctr.UserID != nil && *ctr.UserID <= 0 {
+		r.AddOnError(c.Append("user_id"), common.ErrContainerUserID)
+	}
+	return
+}
+
+// validContainerPort returns true if p is a Podman port mapping of the
+// form [[ip:][host_port]:]container_port[/protocol].
+func validContainerPort(p string) bool {
+	spec, proto, hasProto := strings.Cut(p, "/")
+	if hasProto && proto != "tcp" && proto != "udp" && proto != "sctp" {
+		return false
+	}
+	i := strings.LastIndex(spec, ":")
+	if !validPortRange(spec[i+1:]) {
+		return false
+	}
+	if i < 0 {
+		return true
+	}
+	host := spec[:i]
+	j := strings.LastIndex(host, ":")
+	if j < 0 {
+		return validPortRange(host)
+	}
+	if host[j+1:] != "" && !validPortRange(host[j+1:]) {
+		return false
+	}
+	ip := host[:j]
+	if strings.HasPrefix(ip, "[") && strings.HasSuffix(ip, "]") {
+		ip = ip[1 : len(ip)-1]
+	}
+	return net.ParseIP(ip) != nil
+}
+
+// validPortRange returns true if s is a port number or a range of them
+// separated by "-".
+func validPortRange(s string) bool {
+	first, last, isRange := strings.Cut(s, "-")
+	start, err := strconv.Atoi(first)
+	if err != nil || start < 1 || start > 65535 {
+		return false
+	}
+	if !isRange {
+		return true
+	}
+	end, err := strconv.Atoi(last)
+	return err == nil && end >= start && end <= 65535
+}
+
+// validContainerVolume returns true if v is a Podman volume mapping of
+// the form source:destination[:options].
+func validContainerVolume(v string) bool {
+	if strings.ContainsAny(v, "\r\n") {
+		return false
+	}
+	parts := strings.Split(v, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return false
+	}
+	if parts[0] == "" || !slashpath.IsAbs(parts[1]) {
+		return false
+	}
+	return len(parts) == 2 || parts[2] != ""
+}
+
+func (n Network) Validate(c path.ContextPath) (r report.Report) {
+	if !networkNameRe.MatchString(n.Name) || n.Name == "." || n.Name == ".." {
+		r.AddOnError(c.Append("name"), common.ErrNetworkNameInvalid)
+	}
+	for i, address := range n.Addresses {
+		if _, _, err := net.ParseCIDR(address); err != nil {
+			r.AddOnError(c.Append("addresses", i), common.ErrNetworkAddress)
+		}
+	}
+	if n.Gateway != nil && net.ParseIP(*n.Gateway) == nil {
+		r.AddOnError(c.Append("gateway"), common.ErrNetworkGateway)
+	}
+	for i, server := range n.DNS {
+		if net.ParseIP(server) == nil {
+			r.AddOnError(c.Append("dns", i), common.ErrNetworkDNS)
+		}
+	}
+	return
+}
+
+func (t Timer) Validate(c path.ContextPath) (r report.Report) {
+	if !timerNameRe.MatchString(t.Name) {
+		r.AddOnError(c.Append("name"), common.ErrTimerNameInvalid)
+	}
+	if t.OnCalendar == "" {
+		r.AddOnError(c.Append("on_calendar"), common.ErrTimerNoOnCalendar)
+	} else if !onCalendarRe.MatchString(t.OnCalendar) {
+		r.AddOnError(c.Append("on_calendar"), common.ErrTimerBadOnCalendar)
+	}
+	if t.ExecStart == "" {
+		r.AddOnError(c.Append("exec_start"), common.ErrTimerNoExecStart)
+	} else if strings.ContainsAny(t.ExecStart, "\r\n") {
+		r.AddOnError(c.Append("exec_start"), common.ErrTimerBadExecStart)
+	}
+	return
+}
+
+func (s System) Validate(c path.ContextPath) (r report.Report) {
+	banners := []struct {
+		name     string
+		resource *Resource
+	}{
+		{"issue", s.Issue},
+		{"motd", s.Motd},
+	}
+	for _, banner := range banners {
+		res := banner.resource
+		if res == nil {
+			continue
+		}
+		if res.Source == nil && res.Inline == nil && res.Local == nil && res.Git == nil && res.Exec == nil && res.OCI == nil {
+			r.AddOnError(c.Append(banner.name), common.ErrBannerEmpty)
+		} else if res.Inline != nil && *res.Inline == "" {
+			r.AddOnError(c.Append(banner.name, "inline"), common.ErrBannerEmpty)
+		}
+	}
 	return
 }
 
@@ -81,12 +631,54 @@ func (rs Unit) Validate(c path.ContextPath) (r report.Report) {
 	if rs.ContentsLocal != nil && rs.Contents != nil {
 		r.AddOnError(c.Append("contents_local"), common.ErrTooManySystemdSources)
 	}
+	if util.IsTrue(rs.Enabled) && util.NotEmpty(rs.Contents) && !hasInstallTarget(*rs.Contents) {
+		r.AddOnWarn(c, common.ErrUnitInstallNoTarget)
+	}
 	return
 }
 
+// hasInstallTarget returns false if contents has an [Install] section but
+// none of the options which make systemctl enable do anything.  Ignition
+// already warns about enabled units with no [Install] options at all, and
+// unparseable contents are reported elsewhere.
+func hasInstallTarget(contents string) bool {
+	opts, err := unit.DeserializeOptions(strings.NewReader(contents))
+	if err != nil {
+		return true
+	}
+	var install bool
+	for _, opt := range opts {
+		if opt.Section != "Install" {
+			continue
+		}
+		install = true
+		switch opt.Name {
+		case "WantedBy", "RequiredBy", "UpheldBy", "Alias", "Also":
+			return true
+		}
+	}
+	return !install
+}
+
 func (rs Dropin) Validate(c path.ContextPath) (r report.Report) {
 	if rs.ContentsLocal != nil && rs.Contents != nil {
 		r.AddOnError(c.Append("contents_local"), common.ErrTooManySystemdSources)
 	}
 	return
 }
+
+// validUnitPathValue returns false if p can't be represented as a
+// What= or Where= value: systemd strips leading and trailing whitespace,
+// a trailing backslash continues the line, and control characters
+// include line breaks.
+func validUnitPathValue(p string) bool {
+	if strings.TrimSpace(p) != p || strings.HasSuffix(p, "\\") {
+		return false
+	}
+	for _, c := range p {
+		if unicode.IsControl(c) {
+			return false
+		}
+	}
+	return true
+}
@@ -21,6 +21,7 @@ import (
 	baseutil "github.com/coreos/butane/base/util"
 	"github.com/coreos/butane/config/common"
 
+	"github.com/coreos/ignition/v2/config/shared/errors"
 	"github.com/coreos/ignition/v2/config/util"
 	"github.com/coreos/vcontext/path"
 	"github.com/coreos/vcontext/report"
@@ -126,6 +127,1611 @@ func TestValidateResource(t *testing.T) {
 			common.ErrTooManyResourceSources,
 			path.New("yaml", "source"),
 		},
+		// git specified
+		{
+			Resource{
+				Git: &GitResource{
+					URL:  "https://example.com/repo.git",
+					Path: "file",
+				},
+			},
+			nil,
+			path.New("yaml"),
+		},
+		// git + inline, invalid
+		{
+			Resource{
+				Git: &GitResource{
+					URL:  "https://example.com/repo.git",
+					Path: "file",
+				},
+				Inline: util.StrToPtr("hello"),
+			},
+			common.ErrGitWithOtherSource,
+			path.New("yaml", "git"),
+		},
+		// exec specified
+		{
+			Resource{
+				Exec: &ExecResource{
+					Command: "echo",
+				},
+			},
+			nil,
+			path.New("yaml"),
+		},
+		// exec + source, invalid
+		{
+			Resource{
+				Exec: &ExecResource{
+					Command: "echo",
+				},
+				Source: util.StrToPtr("https://example.com/file"),
+			},
+			common.ErrExecWithOtherSource,
+			path.New("yaml", "exec"),
+		},
+		// oci specified
+		{
+			Resource{
+				OCI: &OCIResource{
+					Ref: "registry.example.com/configs/app:v1",
+				},
+			},
+			nil,
+			path.New("yaml"),
+		},
+		// oci + local, invalid
+		{
+			Resource{
+				OCI: &OCIResource{
+					Ref: "registry.example.com/configs/app:v1",
+				},
+				Local: util.StrToPtr("file"),
+			},
+			common.ErrOCIWithOtherSource,
+			path.New("yaml", "oci"),
+		},
+		// line endings and BOM
+		{
+			Resource{
+				Inline:      util.StrToPtr("hello"),
+				LineEndings: util.StrToPtr("crlf"),
+				AddBOM:      util.BoolToPtr(true),
+			},
+			nil,
+			path.New("yaml"),
+		},
+		// invalid line endings
+		{
+			Resource{
+				Inline:      util.StrToPtr("hello"),
+				LineEndings: util.StrToPtr("cr"),
+			},
+			common.ErrLineEndings,
+			path.New("yaml", "line_endings"),
+		},
+		// BOM on remote contents
+		{
+			Resource{
+				Source: util.StrToPtr("https://example.com/file"),
+				AddBOM: util.BoolToPtr(true),
+			},
+			common.ErrTextTransformRemote,
+			path.New("yaml", "add_bom"),
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("validate %d", i), func(t *testing.T) {
+			actual := test.in.Validate(path.New("yaml"))
+			baseutil.VerifyReport(t, test.in, actual)
+			expected := report.Report{}
+			expected.AddOnError(test.errPath, test.out)
+			assert.Equal(t, expected, actual, "bad report")
+		})
+	}
+}
+
+func TestValidateVerification(t *testing.T) {
+	tests := []struct {
+		in      Verification
+		out     error
+		errPath path.ContextPath
+	}{
+		// no hash
+		{
+			Verification{},
+			nil,
+			path.New("yaml"),
+		},
+		// valid sha256
+		{
+			Verification{
+				Hash: util.StrToPtr("sha256-e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"),
+			},
+			nil,
+			path.New("yaml"),
+		},
+		// valid sha512
+		{
+			Verification{
+				Hash: util.StrToPtr("sha512-cf83e1357eefb8bdf1542850d66d8007d620e4050b5715dc83f4a921d36ce9ce47d0d13c5d85f2b0ff8318d2877eec2f63b931bd47417a81a538327af927da3e"),
+			},
+			nil,
+			path.New("yaml"),
+		},
+		// missing function
+		{
+			Verification{
+				Hash: util.StrToPtr("e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"),
+			},
+			errors.ErrHashMalformed,
+			path.New("yaml", "hash"),
+		},
+		// unrecognized function
+		{
+			Verification{
+				Hash: util.StrToPtr("md5-d41d8cd98f00b204e9800998ecf8427e"),
+			},
+			errors.ErrHashUnrecognized,
+			path.New("yaml", "hash"),
+		},
+		// truncated sha256
+		{
+			Verification{
+				Hash: util.StrToPtr("sha256-e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b85"),
+			},
+			errors.ErrHashWrongSize,
+			path.New("yaml", "hash"),
+		},
+		// sha512 with sha256 sum
+		{
+			Verification{
+				Hash: util.StrToPtr("sha512-e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"),
+			},
+			errors.ErrHashWrongSize,
+			path.New("yaml", "hash"),
+		},
+		// empty sha256
+		{
+			Verification{
+				Hash: util.StrToPtr("sha256-"),
+			},
+			errors.ErrHashWrongSize,
+			path.New("yaml", "hash"),
+		},
+		// non-hex sha256
+		{
+			Verification{
+				Hash: util.StrToPtr("sha256-e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b85g"),
+			},
+			common.ErrHashNotHex,
+			path.New("yaml", "hash"),
+		},
+		// non-hex sha512
+		{
+			Verification{
+				Hash: util.StrToPtr("sha512-cf83e1357eefb8bdf1542850d66d8007d620e4050b5715dc83f4a921d36ce9ce47d0d13c5d85f2b0ff8318d2877eec2f63b931bd47417a81a538327af927da3-"),
+			},
+			common.ErrHashNotHex,
+			path.New("yaml", "hash"),
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("validate %d", i), func(t *testing.T) {
+			actual := test.in.Validate(path.New("yaml"))
+			baseutil.VerifyReport(t, test.in, actual)
+			expected := report.Report{}
+			expected.AddOnError(test.errPath, test.out)
+			assert.Equal(t, expected, actual, "bad report")
+		})
+	}
+}
+
+func TestValidateProxy(t *testing.T) {
+	tests := []struct {
+		in      Proxy
+		out     error
+		errPath path.ContextPath
+	}{
+		// valid entries
+		{
+			Proxy{
+				NoProxy: []string{
+					"*",
+					"example.com",
+					".example.com",
+					"*.example.com",
+					"host_1.internal:8080",
+					"localhost",
+					"10.0.0.1",
+					"10.0.0.0/8",
+					"fd00::/8",
+					"::1",
+					"[::1]:443",
+				},
+			},
+			nil,
+			path.New("yaml"),
+		},
+		// invalid CIDR
+		{
+			Proxy{
+				NoProxy: []string{"example.com", "10.0.0.0/33"},
+			},
+			common.ErrNoProxyInvalid,
+			path.New("yaml", "no_proxy", 1),
+		},
+		// invalid hostname
+		{
+			Proxy{
+				NoProxy: []string{"exa mple.com"},
+			},
+			common.ErrNoProxyInvalid,
+			path.New("yaml", "no_proxy", 0),
+		},
+		// comma-separated list in a single entry
+		{
+			Proxy{
+				NoProxy: []string{"a.com,b.com"},
+			},
+			common.ErrNoProxyInvalid,
+			path.New("yaml", "no_proxy", 0),
+		},
+		// invalid port
+		{
+			Proxy{
+				NoProxy: []string{"example.com:http"},
+			},
+			common.ErrNoProxyInvalid,
+			path.New("yaml", "no_proxy", 0),
+		},
+		// empty entry
+		{
+			Proxy{
+				NoProxy: []string{""},
+			},
+			common.ErrNoProxyInvalid,
+			path.New("yaml", "no_proxy", 0),
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("validate %d", i), func(t *testing.T) {
+			actual := test.in.Validate(path.New("yaml"))
+			baseutil.VerifyReport(t, test.in, actual)
+			expected := report.Report{}
+			expected.AddOnError(test.errPath, test.out)
+			assert.Equal(t, expected, actual, "bad report")
+		})
+	}
+}
+
+func TestValidateGitResource(t *testing.T) {
+	tests := []struct {
+		in      GitResource
+		out     error
+		errPath path.ContextPath
+	}{
+		// valid
+		{
+			GitResource{
+				URL:  "https://example.com/repo.git",
+				Ref:  util.StrToPtr("v1.0"),
+				Path: "dir/file",
+			},
+			nil,
+			path.New("yaml"),
+		},
+		// missing url
+		{
+			GitResource{
+				Path: "file",
+			},
+			common.ErrGitURLRequired,
+			path.New("yaml", "url"),
+		},
+		// missing path
+		{
+			GitResource{
+				URL: "https://example.com/repo.git",
+			},
+			common.ErrGitPathRequired,
+			path.New("yaml", "path"),
+		},
+		// absolute path
+		{
+			GitResource{
+				URL:  "https://example.com/repo.git",
+				Path: "/file",
+			},
+			common.ErrGitPathInvalid,
+			path.New("yaml", "path"),
+		},
+		// path traversal
+		{
+			GitResource{
+				URL:  "https://example.com/repo.git",
+				Path: "dir/../../file",
+			},
+			common.ErrGitPathInvalid,
+			path.New("yaml", "path"),
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("validate %d", i), func(t *testing.T) {
+			actual := test.in.Validate(path.New("yaml"))
+			baseutil.VerifyReport(t, test.in, actual)
+			expected := report.Report{}
+			expected.AddOnError(test.errPath, test.out)
+			assert.Equal(t, expected, actual, "bad report")
+		})
+	}
+}
+
+func TestValidateOCIResource(t *testing.T) {
+	tests := []struct {
+		in      OCIResource
+		out     error
+		errPath path.ContextPath
+	}{
+		// valid
+		{
+			OCIResource{
+				Ref:    "registry.example.com/configs/app:v1",
+				Digest: util.StrToPtr("sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"),
+			},
+			nil,
+			path.New("yaml"),
+		},
+		// missing ref
+		{
+			OCIResource{},
+			common.ErrOCIRefRequired,
+			path.New("yaml", "ref"),
+		},
+		// invalid digest
+		{
+			OCIResource{
+				Ref:    "registry.example.com/configs/app:v1",
+				Digest: util.StrToPtr("sha256-0123"),
+			},
+			common.ErrOCIDigestInvalid,
+			path.New("yaml", "digest"),
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("validate %d", i), func(t *testing.T) {
+			actual := test.in.Validate(path.New("yaml"))
+			baseutil.VerifyReport(t, test.in, actual)
+			expected := report.Report{}
+			expected.AddOnError(test.errPath, test.out)
+			assert.Equal(t, expected, actual, "bad report")
+		})
+	}
+}
+
+func TestValidateExecResource(t *testing.T) {
+	tests := []struct {
+		in      ExecResource
+		out     error
+		errPath path.ContextPath
+	}{
+		// valid
+		{
+			ExecResource{
+				Command: "echo",
+				Args:    []string{"hello"},
+			},
+			nil,
+			path.New("yaml"),
+		},
+		// missing command
+		{
+			ExecResource{
+				Args: []string{"hello"},
+			},
+			common.ErrExecCommandRequired,
+			path.New("yaml", "command"),
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("validate %d", i), func(t *testing.T) {
+			actual := test.in.Validate(path.New("yaml"))
+			baseutil.VerifyReport(t, test.in, actual)
+			expected := report.Report{}
+			expected.AddOnError(test.errPath, test.out)
+			assert.Equal(t, expected, actual, "bad report")
+		})
+	}
+}
+
+func TestValidatePasswdUser(t *testing.T) {
+	tests := []struct {
+		in      PasswdUser
+		out     error
+		errPath path.ContextPath
+	}{
+		// password_hash
+		{
+			PasswdUser{
+				PasswordHash: util.StrToPtr("hash"),
+			},
+			nil,
+			path.New("yaml"),
+		},
+		// password_hash_local
+		{
+			PasswdUser{
+				PasswordHashLocal: util.StrToPtr("hash"),
+			},
+			nil,
+			path.New("yaml"),
+		},
+		// both
+		{
+			PasswdUser{
+				PasswordHash:      util.StrToPtr("hash"),
+				PasswordHashLocal: util.StrToPtr("hash"),
+			},
+			common.ErrTooManyPasswordHashSources,
+			path.New("yaml", "password_hash_local"),
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("validate %d", i), func(t *testing.T) {
+			actual := test.in.Validate(path.New("yaml"))
+			baseutil.VerifyReport(t, test.in, actual)
+			expected := report.Report{}
+			expected.AddOnError(test.errPath, test.out)
+			assert.Equal(t, expected, actual, "bad report")
+		})
+	}
+}
+
+func TestValidateTimer(t *testing.T) {
+	tests := []struct {
+		in      Timer
+		out     error
+		errPath path.ContextPath
+	}{
+		// valid
+		{
+			Timer{
+				Name:       "backup",
+				OnCalendar: "Mon..Fri *-*-* 02:00:00",
+				ExecStart:  "/usr/local/bin/backup",
+			},
+			nil,
+			path.New("yaml"),
+		},
+		// shorthand calendar
+		{
+			Timer{
+				Name:       "backup",
+				OnCalendar: "weekly",
+				ExecStart:  "/usr/local/bin/backup",
+			},
+			nil,
+			path.New("yaml"),
+		},
+		// missing name
+		{
+			Timer{
+				OnCalendar: "daily",
+				ExecStart:  "/usr/local/bin/backup",
+			},
+			common.ErrTimerNameInvalid,
+			path.New("yaml", "name"),
+		},
+		// name with suffix
+		{
+			Timer{
+				Name:       "backup.service",
+				OnCalendar: "daily",
+				ExecStart:  "/usr/local/bin/backup",
+			},
+			common.ErrTimerNameInvalid,
+			path.New("yaml", "name"),
+		},
+		// missing on_calendar
+		{
+			Timer{
+				Name:      "backup",
+				ExecStart: "/usr/local/bin/backup",
+			},
+			common.ErrTimerNoOnCalendar,
+			path.New("yaml", "on_calendar"),
+		},
+		// bad on_calendar
+		{
+			Timer{
+				Name:       "backup",
+				OnCalendar: "daily\nExecStart=/bin/false",
+				ExecStart:  "/usr/local/bin/backup",
+			},
+			common.ErrTimerBadOnCalendar,
+			path.New("yaml", "on_calendar"),
+		},
+		// missing exec_start
+		{
+			Timer{
+				Name:       "backup",
+				OnCalendar: "daily",
+			},
+			common.ErrTimerNoExecStart,
+			path.New("yaml", "exec_start"),
+		},
+		// multi-line exec_start
+		{
+			Timer{
+				Name:       "backup",
+				OnCalendar: "daily",
+				ExecStart:  "/usr/local/bin/backup\n/usr/local/bin/cleanup",
+			},
+			common.ErrTimerBadExecStart,
+			path.New("yaml", "exec_start"),
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("validate %d", i), func(t *testing.T) {
+			actual := test.in.Validate(path.New("yaml"))
+			baseutil.VerifyReport(t, test.in, actual)
+			expected := report.Report{}
+			expected.AddOnError(test.errPath, test.out)
+			assert.Equal(t, expected, actual, "bad report")
+		})
+	}
+}
+
+func TestValidateNetwork(t *testing.T) {
+	tests := []struct {
+		in      Network
+		out     error
+		errPath path.ContextPath
+	}{
+		// valid
+		{
+			Network{
+				Name:      "eth0",
+				Addresses: []string{"192.0.2.10/24", "2001:db8::10/64"},
+				Gateway:   util.StrToPtr("192.0.2.1"),
+				DNS:       []string{"192.0.2.53", "2001:db8::53"},
+			},
+			nil,
+			path.New("yaml"),
+		},
+		// missing name
+		{
+			Network{},
+			common.ErrNetworkNameInvalid,
+			path.New("yaml", "name"),
+		},
+		// name too long
+		{
+			Network{
+				Name: "abcdefghijklmnop",
+			},
+			common.ErrNetworkNameInvalid,
+			path.New("yaml", "name"),
+		},
+		// name with slash
+		{
+			Network{
+				Name: "../eth0",
+			},
+			common.ErrNetworkNameInvalid,
+			path.New("yaml", "name"),
+		},
+		// address without prefix length
+		{
+			Network{
+				Name:      "eth0",
+				Addresses: []string{"192.0.2.10"},
+			},
+			common.ErrNetworkAddress,
+			path.New("yaml", "addresses", 0),
+		},
+		// invalid gateway
+		{
+			Network{
+				Name:    "eth0",
+				Gateway: util.StrToPtr("192.0.2.1/24"),
+			},
+			common.ErrNetworkGateway,
+			path.New("yaml", "gateway"),
+		},
+		// invalid DNS server
+		{
+			Network{
+				Name: "eth0",
+				DNS:  []string{"192.0.2.53", "dns.example.com"},
+			},
+			common.ErrNetworkDNS,
+			path.New("yaml", "dns", 1),
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("validate %d", i), func(t *testing.T) {
+			actual := test.in.Validate(path.New("yaml"))
+			baseutil.VerifyReport(t, test.in, actual)
+			expected := report.Report{}
+			expected.AddOnError(test.errPath, test.out)
+			assert.Equal(t, expected, actual, "bad report")
+		})
+	}
+}
+
+func TestValidateContainer(t *testing.T) {
+	tests := []struct {
+		in      Container
+		out     error
+		errPath path.ContextPath
+	}{
+		// valid
+		{
+			Container{
+				Name:        "web",
+				Image:       "quay.io/example/web:latest",
+				Ports:       []string{"80", "8080:80", "8000-8010:8000-8010/udp", "127.0.0.1::443", "[::1]:8443:443/tcp"},
+				Volumes:     []string{"/srv/web:/usr/share/web", "data:/data:ro,Z"},
+				Environment: []string{"MODE=production", "EMPTY="},
+				UserID:      util.IntToPtr(1000),
+			},
+			nil,
+			path.New("yaml"),
+		},
+		// missing name
+		{
+			Container{
+				Image: "quay.io/example/web",
+			},
+			common.ErrContainerNameInvalid,
+			path.New("yaml", "name"),
+		},
+		// name with suffix
+		{
+			Container{
+				Name:  "web.container",
+				Image: "quay.io/example/web",
+			},
+			common.ErrContainerNameInvalid,
+			path.New("yaml", "name"),
+		},
+		// missing image
+		{
+			Container{
+				Name: "web",
+			},
+			common.ErrContainerNoImage,
+			path.New("yaml", "image"),
+		},
+		// image with whitespace
+		{
+			Container{
+				Name:  "web",
+				Image: "quay.io/example/web latest",
+			},
+			common.ErrContainerBadImage,
+			path.New("yaml", "image"),
+		},
+		// port out of range
+		{
+			Container{
+				Name:  "web",
+				Image: "quay.io/example/web",
+				Ports: []string{"80", "0"},
+			},
+			common.ErrContainerPort,
+			path.New("yaml", "ports", 1),
+		},
+		// container port out of range
+		{
+			Container{
+				Name:  "web",
+				Image: "quay.io/example/web",
+				Ports: []string{"80", "8080:70000"},
+			},
+			common.ErrContainerPort,
+			path.New("yaml", "ports", 1),
+		},
+		// bad protocol
+		{
+			Container{
+				Name:  "web",
+				Image: "quay.io/example/web",
+				Ports: []string{"80", "80/icmp"},
+			},
+			common.ErrContainerPort,
+			path.New("yaml", "ports", 1),
+		},
+		// host name instead of IP
+		{
+			Container{
+				Name:  "web",
+				Image: "quay.io/example/web",
+				Ports: []string{"80", "localhost:8080:80"},
+			},
+			common.ErrContainerPort,
+			path.New("yaml", "ports", 1),
+		},
+		// reversed range
+		{
+			Container{
+				Name:  "web",
+				Image: "quay.io/example/web",
+				Ports: []string{"80", "8010-8000"},
+			},
+			common.ErrContainerPort,
+			path.New("yaml", "ports", 1),
+		},
+		// missing destination
+		{
+			Container{
+				Name:    "web",
+				Image:   "quay.io/example/web",
+				Volumes: []string{"/srv/web"},
+			},
+			common.ErrContainerVolume,
+			path.New("yaml", "volumes", 0),
+		},
+		// relative destination
+		{
+			Container{
+				Name:    "web",
+				Image:   "quay.io/example/web",
+				Volumes: []string{"/srv/web:data"},
+			},
+			common.ErrContainerVolume,
+			path.New("yaml", "volumes", 0),
+		},
+		// empty options
+		{
+			Container{
+				Name:    "web",
+				Image:   "quay.io/example/web",
+				Volumes: []string{"/srv/web:/data:"},
+			},
+			common.ErrContainerVolume,
+			path.New("yaml", "volumes", 0),
+		},
+		// missing value
+		{
+			Container{
+				Name:        "web",
+				Image:       "quay.io/example/web",
+				Environment: []string{"MODE"},
+			},
+			common.ErrContainerEnvironment,
+			path.New("yaml", "environment", 0),
+		},
+		// invalid name
+		{
+			Container{
+				Name:        "web",
+				Image:       "quay.io/example/web",
+				Environment: []string{"1MODE=a"},
+			},
+			common.ErrContainerEnvironment,
+			path.New("yaml", "environment", 0),
+		},
+		// multiline value
+		{
+			Container{
+				Name:        "web",
+				Image:       "quay.io/example/web",
+				Environment: []string{"MODE=a\nb"},
+			},
+			common.ErrContainerEnvironment,
+			path.New("yaml", "environment", 0),
+		},
+		// root user ID
+		{
+			Container{
+				Name:   "web",
+				Image:  "quay.io/example/web",
+				UserID: util.IntToPtr(0),
+			},
+			common.ErrContainerUserID,
+			path.New("yaml", "user_id"),
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("validate %d", i), func(t *testing.T) {
+			actual := test.in.Validate(path.New("yaml"))
+			baseutil.VerifyReport(t, test.in, actual)
+			expected := report.Report{}
+			expected.AddOnError(test.errPath, test.out)
+			assert.Equal(t, expected, actual, "bad report")
+		})
+	}
+}
+
+func TestValidateExtension(t *testing.T) {
+	tests := []struct {
+		in      Extension
+		out     error
+		errPath path.ContextPath
+	}{
+		// valid sysext
+		{
+			Extension{
+				Name: "foo",
+				Contents: Resource{
+					Source: util.StrToPtr("https://example.com/foo.raw"),
+				},
+			},
+			nil,
+			path.New("yaml"),
+		},
+		// valid confext in /etc
+		{
+			Extension{
+				Name:      "foo",
+				Type:      util.StrToPtr("confext"),
+				Directory: util.StrToPtr("/etc/confexts"),
+				Contents: Resource{
+					Local: util.StrToPtr("foo.raw"),
+				},
+			},
+			nil,
+			path.New("yaml"),
+		},
+		// name with suffix
+		{
+			Extension{
+				Name: "foo.raw",
+				Contents: Resource{
+					Source: util.StrToPtr("https://example.com/foo.raw"),
+				},
+			},
+			common.ErrExtensionName,
+			path.New("yaml", "name"),
+		},
+		// name with slash
+		{
+			Extension{
+				Name: "../foo",
+				Contents: Resource{
+					Source: util.StrToPtr("https://example.com/foo.raw"),
+				},
+			},
+			common.ErrExtensionName,
+			path.New("yaml", "name"),
+		},
+		// bad type
+		{
+			Extension{
+				Name: "foo",
+				Type: util.StrToPtr("portable"),
+				Contents: Resource{
+					Source: util.StrToPtr("https://example.com/foo.raw"),
+				},
+			},
+			common.ErrExtensionType,
+			path.New("yaml", "type"),
+		},
+		// directory for the wrong type
+		{
+			Extension{
+				Name:      "foo",
+				Directory: util.StrToPtr("/var/lib/confexts"),
+				Contents: Resource{
+					Source: util.StrToPtr("https://example.com/foo.raw"),
+				},
+			},
+			common.ErrExtensionDirectory,
+			path.New("yaml", "directory"),
+		},
+		// read-only directory
+		{
+			Extension{
+				Name:      "foo",
+				Directory: util.StrToPtr("/usr/lib/extensions"),
+				Contents: Resource{
+					Source: util.StrToPtr("https://example.com/foo.raw"),
+				},
+			},
+			common.ErrExtensionDirectory,
+			path.New("yaml", "directory"),
+		},
+		// no contents
+		{
+			Extension{
+				Name: "foo",
+			},
+			common.ErrExtensionNoContents,
+			path.New("yaml", "contents"),
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("validate %d", i), func(t *testing.T) {
+			actual := test.in.Validate(path.New("yaml"))
+			baseutil.VerifyReport(t, test.in, actual)
+			expected := report.Report{}
+			expected.AddOnError(test.errPath, test.out)
+			assert.Equal(t, expected, actual, "bad report")
+		})
+	}
+}
+
+func TestValidateSystemd(t *testing.T) {
+	tests := []struct {
+		in      Systemd
+		out     error
+		errPath path.ContextPath
+	}{
+		// distinct timers
+		{
+			Systemd{
+				Timers: []Timer{
+					{
+						Name: "a",
+					},
+					{
+						Name: "b",
+					},
+				},
+			},
+			nil,
+			path.New("yaml"),
+		},
+		// duplicate timers
+		{
+			Systemd{
+				Timers: []Timer{
+					{
+						Name: "a",
+					},
+					{
+						Name: "a",
+					},
+				},
+			},
+			common.ErrTimerNameDuplicate,
+			path.New("yaml", "timers", 1, "name"),
+		},
+		// duplicate containers
+		{
+			Systemd{
+				Containers: []Container{
+					{
+						Name: "web",
+					},
+					{
+						Name:   "web",
+						UserID: util.IntToPtr(1000),
+					},
+					{
+						Name: "web",
+					},
+				},
+			},
+			common.ErrContainerNameDuplicate,
+			path.New("yaml", "containers", 2, "name"),
+		},
+		// duplicate networks
+		{
+			Systemd{
+				Networks: []Network{
+					{
+						Name: "eth0",
+					},
+					{
+						Name: "eth0",
+					},
+				},
+			},
+			common.ErrNetworkNameDuplicate,
+			path.New("yaml", "networks", 1, "name"),
+		},
+		// presets
+		{
+			Systemd{
+				Presets: Presets{
+					Enabled:  []string{"a.service"},
+					Disabled: []string{"b.service"},
+				},
+				Units: []Unit{
+					{
+						Name: "a.service",
+					},
+					{
+						Name: "b.service",
+					},
+				},
+			},
+			nil,
+			path.New("yaml"),
+		},
+		// preset for undeclared unit
+		{
+			Systemd{
+				Presets: Presets{
+					Enabled: []string{"a.service"},
+				},
+			},
+			common.ErrPresetUnitUndeclared,
+			path.New("yaml", "presets", "enabled", 0),
+		},
+		// unit both enabled and disabled
+		{
+			Systemd{
+				Presets: Presets{
+					Enabled:  []string{"a.service"},
+					Disabled: []string{"a.service"},
+				},
+				Units: []Unit{
+					{
+						Name: "a.service",
+					},
+				},
+			},
+			common.ErrPresetUnitConflict,
+			path.New("yaml", "presets", "disabled", 0),
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("validate %d", i), func(t *testing.T) {
+			actual := test.in.Validate(path.New("yaml"))
+			baseutil.VerifyReport(t, test.in, actual)
+			expected := report.Report{}
+			expected.AddOnError(test.errPath, test.out)
+			assert.Equal(t, expected, actual, "bad report")
+		})
+	}
+}
+
+func TestValidateTree(t *testing.T) {
+	tests := []struct {
+		in      Tree
+		out     error
+		errPath path.ContextPath
+	}{
+		{
+			in:      Tree{},
+			out:     common.ErrTreeNoLocal,
+			errPath: path.New("yaml"),
+		},
+		{
+			in: Tree{
+				Compression: util.StrToPtr("gzip"),
+				Local:       "tree",
+			},
+			errPath: path.New("yaml"),
+		},
+		{
+			in: Tree{
+				Compression: util.StrToPtr("none"),
+				Local:       "tree",
+			},
+			errPath: path.New("yaml"),
+		},
+		{
+			in: Tree{
+				Compression: util.StrToPtr("zstd"),
+				Local:       "tree",
+			},
+			out:     common.ErrTreeCompression,
+			errPath: path.New("yaml", "compression"),
+		},
+		{
+			in: Tree{
+				Local:      "tree",
+				ModeFilter: util.StrToPtr("executable"),
+			},
+			errPath: path.New("yaml"),
+		},
+		{
+			in: Tree{
+				Local:      "tree",
+				ModeFilter: util.StrToPtr("binaries"),
+			},
+			out:     common.ErrTreeModeFilter,
+			errPath: path.New("yaml", "mode_filter"),
+		},
+		{
+			in: Tree{
+				Local:         "tree",
+				OnSpecialFile: util.StrToPtr("skip"),
+			},
+			errPath: path.New("yaml"),
+		},
+		{
+			in: Tree{
+				Local:         "tree",
+				OnSpecialFile: util.StrToPtr("ignore"),
+			},
+			out:     common.ErrTreeOnSpecialFile,
+			errPath: path.New("yaml", "on_special_file"),
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("validate %d", i), func(t *testing.T) {
+			actual := test.in.Validate(path.New("yaml"))
+			baseutil.VerifyReport(t, test.in, actual)
+			expected := report.Report{}
+			expected.AddOnError(test.errPath, test.out)
+			assert.Equal(t, expected, actual, "bad report")
+		})
+	}
+}
+
+func TestValidateFileMode(t *testing.T) {
+	fileTests := []struct {
+		in  File
+		out error
+	}{
+		{
+			in:  File{},
+			out: nil,
+		},
+		{
+			in: File{
+				Mode: util.IntToPtr(0600),
+			},
+			out: nil,
+		},
+		{
+			in: File{
+				Mode: util.IntToPtr(600),
+			},
+			out: common.ErrDecimalMode,
+		},
+	}
+
+	for i, test := range fileTests {
+		t.Run(fmt.Sprintf("validate %d", i), func(t *testing.T) {
+			actual := test.in.Validate(path.New("yaml"))
+			baseutil.VerifyReport(t, test.in, actual)
+			expected := report.Report{}
+			expected.AddOnWarn(path.New("yaml", "mode"), test.out)
+			assert.Equal(t, expected, actual, "bad report")
+		})
+	}
+}
+
+func TestValidateDirMode(t *testing.T) {
+	dirTests := []struct {
+		in  Directory
+		out error
+	}{
+		{
+			in:  Directory{},
+			out: nil,
+		},
+		{
+			in: Directory{
+				Mode: util.IntToPtr(01770),
+			},
+			out: nil,
+		},
+		{
+			in: Directory{
+				Mode: util.IntToPtr(1770),
+			},
+			out: common.ErrDecimalMode,
+		},
+	}
+
+	for i, test := range dirTests {
+		t.Run(fmt.Sprintf("validate %d", i), func(t *testing.T) {
+			actual := test.in.Validate(path.New("yaml"))
+			baseutil.VerifyReport(t, test.in, actual)
+			expected := report.Report{}
+			expected.AddOnWarn(path.New("yaml", "mode"), test.out)
+			assert.Equal(t, expected, actual, "bad report")
+		})
+	}
+}
+
+func TestValidateFileAppend(t *testing.T) {
+	tests := []struct {
+		in      File
+		out     error
+		errPath path.ContextPath
+	}{
+		// append only
+		{
+			in: File{
+				Overwrite: util.BoolToPtr(true),
+				Append: []Resource{
+					{
+						Inline: util.StrToPtr("hello"),
+					},
+				},
+			},
+			errPath: path.New("yaml"),
+		},
+		// contents and append without overwrite
+		{
+			in: File{
+				Contents: Resource{
+					Inline: util.StrToPtr("hello"),
+				},
+				Append: []Resource{
+					{
+						Inline: util.StrToPtr("world"),
+					},
+				},
+			},
+			errPath: path.New("yaml"),
+		},
+		// contents, append, and overwrite
+		{
+			in: File{
+				Overwrite: util.BoolToPtr(true),
+				Contents: Resource{
+					Source: util.StrToPtr("https://example.com/hello"),
+				},
+				Append: []Resource{
+					{
+						Inline: util.StrToPtr("world"),
+					},
+				},
+			},
+			out:     common.ErrOverwriteWithAppend,
+			errPath: path.New("yaml"),
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("validate %d", i), func(t *testing.T) {
+			actual := test.in.Validate(path.New("yaml"))
+			baseutil.VerifyReport(t, test.in, actual)
+			expected := report.Report{}
+			expected.AddOnError(test.errPath, test.out)
+			assert.Equal(t, expected, actual, "bad report")
+		})
+	}
+}
+
+func TestValidateFilesystem(t *testing.T) {
+	tests := []struct {
+		in      Filesystem
+		out     error
+		errPath path.ContextPath
+	}{
+		{
+			Filesystem{},
+			nil,
+			path.New("yaml"),
+		},
+		{
+			Filesystem{
+				Device: "/dev/foo",
+			},
+			nil,
+			path.New("yaml"),
+		},
+		{
+			Filesystem{
+				Device:        "/dev/foo",
+				Format:        util.StrToPtr("zzz"),
+				Path:          util.StrToPtr("/z"),
+				WithMountUnit: util.BoolToPtr(true),
+			},
+			nil,
+			path.New("yaml"),
+		},
+		{
+			Filesystem{
+				Device:        "/dev/foo",
+				Format:        util.StrToPtr("swap"),
+				WithMountUnit: util.BoolToPtr(true),
+			},
+			nil,
+			path.New("yaml"),
+		},
+		{
+			Filesystem{
+				Device:        "/dev/foo",
+				WithMountUnit: util.BoolToPtr(true),
+			},
+			common.ErrMountUnitNoFormat,
+			path.New("yaml", "format"),
+		},
+		// spaces are fine
+		{
+			Filesystem{
+				Device:        "/dev/disk/by-label/my data",
+				Format:        util.StrToPtr("ext4"),
+				Path:          util.StrToPtr("/var/my data"),
+				WithMountUnit: util.BoolToPtr(true),
+			},
+			nil,
+			path.New("yaml"),
+		},
+		{
+			Filesystem{
+				Device:        "/dev/disk/by-label/data ",
+				Format:        util.StrToPtr("ext4"),
+				Path:          util.StrToPtr("/var/data"),
+				WithMountUnit: util.BoolToPtr(true),
+			},
+			common.ErrMountUnitBadPath,
+			path.New("yaml", "device"),
+		},
+		{
+			Filesystem{
+				Device:        "/dev/foo",
+				Format:        util.StrToPtr("ext4"),
+				Path:          util.StrToPtr("/var/da\nta"),
+				WithMountUnit: util.BoolToPtr(true),
+			},
+			common.ErrMountUnitBadPath,
+			path.New("yaml", "path"),
+		},
+		{
+			Filesystem{
+				Device:        "/dev/foo\\",
+				Format:        util.StrToPtr("swap"),
+				WithMountUnit: util.BoolToPtr(true),
+			},
+			common.ErrMountUnitBadPath,
+			path.New("yaml", "device"),
+		},
+		// not checked without a mount unit
+		{
+			Filesystem{
+				Device: "/dev/foo\\",
+			},
+			nil,
+			path.New("yaml"),
+		},
+		// would render an empty Type=
+		{
+			Filesystem{
+				Device:        "/dev/foo",
+				Format:        util.StrToPtr(""),
+				Path:          util.StrToPtr("/z"),
+				WithMountUnit: util.BoolToPtr(true),
+			},
+			common.ErrMountUnitNoFormat,
+			path.New("yaml", "format"),
+		},
+		{
+			Filesystem{
+				Device:        "/dev/foo",
+				Format:        util.StrToPtr("zzz"),
+				WithMountUnit: util.BoolToPtr(true),
+			},
+			common.ErrMountUnitNoPath,
+			path.New("yaml", "path"),
+		},
+		{
+			Filesystem{
+				Device:        "/dev/foo",
+				Format:        util.StrToPtr("ext4"),
+				MountType:     util.StrToPtr("fuse.zzz"),
+				Path:          util.StrToPtr("/z"),
+				WithMountUnit: util.BoolToPtr(true),
+			},
+			nil,
+			path.New("yaml"),
+		},
+		{
+			Filesystem{
+				Device:        "/dev/foo",
+				Format:        util.StrToPtr("ext4"),
+				MountType:     util.StrToPtr(""),
+				Path:          util.StrToPtr("/z"),
+				WithMountUnit: util.BoolToPtr(true),
+			},
+			common.ErrMountUnitBadType,
+			path.New("yaml", "mount_type"),
+		},
+		{
+			Filesystem{
+				Device:        "/dev/foo",
+				Format:        util.StrToPtr("ext4"),
+				MountType:     util.StrToPtr("ext4 ro"),
+				Path:          util.StrToPtr("/z"),
+				WithMountUnit: util.BoolToPtr(true),
+			},
+			common.ErrMountUnitBadType,
+			path.New("yaml", "mount_type"),
+		},
+		{
+			Filesystem{
+				Device: "/dev/foo",
+				Format: util.StrToPtr("none"),
+			},
+			nil,
+			path.New("yaml"),
+		},
+		{
+			Filesystem{
+				Device:         "/dev/foo",
+				Format:         util.StrToPtr("none"),
+				WipeFilesystem: util.BoolToPtr(true),
+			},
+			common.ErrWipeFilesystemNone,
+			path.New("yaml"),
+		},
+		{
+			Filesystem{
+				Device:        "/dev/foo",
+				Format:        util.StrToPtr("ext4"),
+				Path:          util.StrToPtr("/z"),
+				Resize:        util.BoolToPtr(true),
+				WithMountUnit: util.BoolToPtr(true),
+			},
+			nil,
+			path.New("yaml"),
+		},
+		{
+			Filesystem{
+				Device: "/dev/foo",
+				Format: util.StrToPtr("ext4"),
+				Path:   util.StrToPtr("/z"),
+				Resize: util.BoolToPtr(true),
+			},
+			common.ErrResizeNoMountUnit,
+			path.New("yaml", "resize"),
+		},
+		{
+			Filesystem{
+				Device:        "/dev/foo",
+				Format:        util.StrToPtr("swap"),
+				Resize:        util.BoolToPtr(true),
+				WithMountUnit: util.BoolToPtr(true),
+			},
+			common.ErrResizeFormat,
+			path.New("yaml", "resize"),
+		},
+		{
+			Filesystem{
+				Device:        "/dev/foo",
+				Format:        util.StrToPtr("ext4"),
+				Path:          util.StrToPtr("/z"),
+				WithMountUnit: util.BoolToPtr(true),
+				SystemdMountOptions: map[string]string{
+					"x-systemd.automount":    "",
+					"x-systemd.idle-timeout": "1min",
+				},
+			},
+			nil,
+			path.New("yaml"),
+		},
+		{
+			Filesystem{
+				Device:        "/dev/foo",
+				Format:        util.StrToPtr("ext4"),
+				Path:          util.StrToPtr("/z"),
+				WithMountUnit: util.BoolToPtr(true),
+				SystemdMountOptions: map[string]string{
+					"x-systemd.idle-timeout": "",
+				},
+			},
+			common.ErrMountOptionNeedsValue,
+			path.New("yaml", "systemd_mount_options", "x-systemd.idle-timeout"),
+		},
+		{
+			Filesystem{
+				Device:        "/dev/foo",
+				Format:        util.StrToPtr("ext4"),
+				Path:          util.StrToPtr("/z"),
+				WithMountUnit: util.BoolToPtr(true),
+				SystemdMountOptions: map[string]string{
+					"x-systemd.automount": "yes",
+				},
+			},
+			common.ErrMountOptionNoValue,
+			path.New("yaml", "systemd_mount_options", "x-systemd.automount"),
+		},
+		{
+			Filesystem{
+				Device:        "/dev/foo",
+				Format:        util.StrToPtr("ext4"),
+				Path:          util.StrToPtr("/z"),
+				WithMountUnit: util.BoolToPtr(true),
+				SystemdMountOptions: map[string]string{
+					"x-systemd.requires": "a.service,b.service",
+				},
+			},
+			common.ErrMountOptionBadValue,
+			path.New("yaml", "systemd_mount_options", "x-systemd.requires"),
+		},
+		{
+			Filesystem{
+				Device:        "/dev/foo",
+				Format:        util.StrToPtr("ext4"),
+				Path:          util.StrToPtr("/z"),
+				WithMountUnit: util.BoolToPtr(true),
+				SystemdMountOptions: map[string]string{
+					"ro,exec": "",
+				},
+			},
+			common.ErrMountOptionBadName,
+			path.New("yaml", "systemd_mount_options", "ro,exec"),
+		},
+		{
+			Filesystem{
+				Device:        "/dev/foo",
+				Format:        util.StrToPtr("ext4"),
+				Path:          util.StrToPtr("/z"),
+				WithMountUnit: util.BoolToPtr(true),
+				SystemdMountOptions: map[string]string{
+					"x-systemd.requires=a.service": "",
+				},
+			},
+			common.ErrMountOptionBadName,
+			path.New("yaml", "systemd_mount_options", "x-systemd.requires=a.service"),
+		},
+		{
+			Filesystem{
+				Device:        "/dev/foo",
+				Format:        util.StrToPtr("ext4"),
+				Path:          util.StrToPtr("/z"),
+				WithMountUnit: util.BoolToPtr(true),
+				SystemdMountOptions: map[string]string{
+					"nofail x": "",
+				},
+			},
+			common.ErrMountOptionBadName,
+			path.New("yaml", "systemd_mount_options", "nofail x"),
+		},
+		{
+			Filesystem{
+				Device:        "/dev/foo",
+				Format:        util.StrToPtr("ext4"),
+				Path:          util.StrToPtr("/z"),
+				WithMountUnit: util.BoolToPtr(true),
+				SystemdMountOptions: map[string]string{
+					"": "",
+				},
+			},
+			common.ErrMountOptionBadName,
+			path.New("yaml", "systemd_mount_options", ""),
+		},
+		{
+			Filesystem{
+				Device:        "/dev/foo",
+				Format:        util.StrToPtr("ext4"),
+				Path:          util.StrToPtr("/z"),
+				WithMountUnit: util.BoolToPtr(true),
+				MountBefore:   []string{"provision.target", "getty@tty1.service"},
+				MountAfter:    []string{"dev-disk-by\\x2dlabel-foo.device"},
+			},
+			nil,
+			path.New("yaml"),
+		},
+		{
+			Filesystem{
+				Device:        "/dev/foo",
+				Format:        util.StrToPtr("ext4"),
+				Path:          util.StrToPtr("/z"),
+				WithMountUnit: util.BoolToPtr(true),
+				MountBefore:   []string{"provision.target"},
+				MountAfter:    []string{"a.service", "provision"},
+			},
+			common.ErrMountOrderUnitName,
+			path.New("yaml", "mount_after", 1),
+		},
+		{
+			Filesystem{
+				Device:        "/dev/foo",
+				Format:        util.StrToPtr("ext4"),
+				Path:          util.StrToPtr("/z"),
+				WithMountUnit: util.BoolToPtr(true),
+				MountBefore:   []string{"a.service b.service"},
+			},
+			common.ErrMountOrderUnitName,
+			path.New("yaml", "mount_before", 0),
+		},
 	}
 
 	for i, test := range tests {
@@ -139,149 +1745,258 @@ func TestValidateResource(t *testing.T) {
 	}
 }
 
-func TestValidateTree(t *testing.T) {
+// TestValidateNestedMounts tests warning about mount units within
+// filesystems which aren't mounted by a unit
+func TestValidateNestedMounts(t *testing.T) {
 	tests := []struct {
-		in  Tree
-		out error
+		in      Storage
+		out     error
+		errPath path.ContextPath
 	}{
+		// both mounted by units
 		{
-			in:  Tree{},
-			out: common.ErrTreeNoLocal,
+			Storage{
+				Filesystems: []Filesystem{
+					{
+						Device:        "/dev/sdb",
+						Format:        util.StrToPtr("xfs"),
+						Path:          util.StrToPtr("/var/lib/app"),
+						WithMountUnit: util.BoolToPtr(true),
+					},
+					{
+						Device:        "/dev/sda",
+						Format:        util.StrToPtr("xfs"),
+						Path:          util.StrToPtr("/var"),
+						WithMountUnit: util.BoolToPtr(true),
+					},
+				},
+			},
+			nil,
+			path.New("yaml"),
 		},
-	}
-
-	for i, test := range tests {
-		t.Run(fmt.Sprintf("validate %d", i), func(t *testing.T) {
-			actual := test.in.Validate(path.New("yaml"))
-			baseutil.VerifyReport(t, test.in, actual)
-			expected := report.Report{}
-			expected.AddOnError(path.New("yaml"), test.out)
-			assert.Equal(t, expected, actual, "bad report")
-		})
-	}
-}
-
-func TestValidateFileMode(t *testing.T) {
-	fileTests := []struct {
-		in  File
-		out error
-	}{
+		// outer filesystem has no mount unit
 		{
-			in:  File{},
-			out: nil,
+			Storage{
+				Filesystems: []Filesystem{
+					{
+						Device: "/dev/sda",
+						Format: util.StrToPtr("xfs"),
+						Path:   util.StrToPtr("/var"),
+					},
+					{
+						Device:        "/dev/sdb",
+						Format:        util.StrToPtr("xfs"),
+						Path:          util.StrToPtr("/var/lib/app/"),
+						WithMountUnit: util.BoolToPtr(true),
+					},
+				},
+			},
+			fmt.Errorf("%w: $.filesystems.0.path", common.ErrMountParentNoUnit),
+			path.New("yaml", "filesystems", 1, "path"),
 		},
+		// innermost filesystem has a mount unit
 		{
-			in: File{
-				Mode: util.IntToPtr(0600),
+			Storage{
+				Filesystems: []Filesystem{
+					{
+						Device: "/dev/sda",
+						Format: util.StrToPtr("xfs"),
+						Path:   util.StrToPtr("/var"),
+					},
+					{
+						Device:        "/dev/sdb",
+						Format:        util.StrToPtr("xfs"),
+						Path:          util.StrToPtr("/var/lib"),
+						WithMountUnit: util.BoolToPtr(true),
+					},
+					{
+						Device:        "/dev/sdc",
+						Format:        util.StrToPtr("xfs"),
+						Path:          util.StrToPtr("/var/lib/app"),
+						WithMountUnit: util.BoolToPtr(true),
+					},
+				},
 			},
-			out: nil,
+			fmt.Errorf("%w: $.filesystems.0.path", common.ErrMountParentNoUnit),
+			path.New("yaml", "filesystems", 1, "path"),
 		},
+		// sibling paths with a common prefix
 		{
-			in: File{
-				Mode: util.IntToPtr(600),
+			Storage{
+				Filesystems: []Filesystem{
+					{
+						Device: "/dev/sda",
+						Format: util.StrToPtr("xfs"),
+						Path:   util.StrToPtr("/var/lib"),
+					},
+					{
+						Device:        "/dev/sdb",
+						Format:        util.StrToPtr("xfs"),
+						Path:          util.StrToPtr("/var/library"),
+						WithMountUnit: util.BoolToPtr(true),
+					},
+				},
 			},
-			out: common.ErrDecimalMode,
+			nil,
+			path.New("yaml"),
 		},
 	}
 
-	for i, test := range fileTests {
+	for i, test := range tests {
 		t.Run(fmt.Sprintf("validate %d", i), func(t *testing.T) {
 			actual := test.in.Validate(path.New("yaml"))
 			baseutil.VerifyReport(t, test.in, actual)
 			expected := report.Report{}
-			expected.AddOnWarn(path.New("yaml", "mode"), test.out)
+			expected.AddOnWarn(test.errPath, test.out)
 			assert.Equal(t, expected, actual, "bad report")
 		})
 	}
 }
 
-func TestValidateDirMode(t *testing.T) {
-	dirTests := []struct {
-		in  Directory
-		out error
+func TestValidateDefaultOwner(t *testing.T) {
+	tests := []struct {
+		in      DefaultOwner
+		out     error
+		errPath path.ContextPath
+		warn    bool
 	}{
 		{
-			in:  Directory{},
-			out: nil,
+			DefaultOwner{
+				User: NodeUser{
+					Name: util.StrToPtr("core"),
+				},
+				Group: NodeGroup{
+					ID: util.IntToPtr(1000),
+				},
+			},
+			nil,
+			path.New("yaml"),
+			false,
 		},
 		{
-			in: Directory{
-				Mode: util.IntToPtr(01770),
+			DefaultOwner{
+				User: NodeUser{
+					ID:   util.IntToPtr(1000),
+					Name: util.StrToPtr("core"),
+				},
 			},
-			out: nil,
+			common.ErrOwnerIDAndName,
+			path.New("yaml", "default_owner", "user"),
+			false,
 		},
 		{
-			in: Directory{
-				Mode: util.IntToPtr(1770),
+			DefaultOwner{
+				Group: NodeGroup{
+					ID:   util.IntToPtr(1000),
+					Name: util.StrToPtr("core"),
+				},
 			},
-			out: common.ErrDecimalMode,
+			common.ErrOwnerIDAndName,
+			path.New("yaml", "default_owner", "group"),
+			false,
+		},
+		{
+			DefaultOwner{},
+			common.ErrDefaultOwnerEmpty,
+			path.New("yaml", "default_owner"),
+			true,
 		},
 	}
 
-	for i, test := range dirTests {
+	for i, test := range tests {
 		t.Run(fmt.Sprintf("validate %d", i), func(t *testing.T) {
-			actual := test.in.Validate(path.New("yaml"))
-			baseutil.VerifyReport(t, test.in, actual)
+			in := Storage{
+				DefaultOwner: &test.in,
+			}
+			actual := in.Validate(path.New("yaml"))
+			baseutil.VerifyReport(t, in, actual)
 			expected := report.Report{}
-			expected.AddOnWarn(path.New("yaml", "mode"), test.out)
+			if test.warn {
+				expected.AddOnWarn(test.errPath, test.out)
+			} else {
+				expected.AddOnError(test.errPath, test.out)
+			}
 			assert.Equal(t, expected, actual, "bad report")
 		})
 	}
 }
 
-func TestValidateFilesystem(t *testing.T) {
+func TestValidateLuks(t *testing.T) {
 	tests := []struct {
-		in      Filesystem
+		in      Luks
 		out     error
 		errPath path.ContextPath
 	}{
+		// no clevis
 		{
-			Filesystem{},
+			Luks{
+				Device: util.StrToPtr("/dev/foo"),
+			},
 			nil,
 			path.New("yaml"),
 		},
+		// empty clevis
 		{
-			Filesystem{
-				Device: "/dev/foo",
+			Luks{
+				Clevis: Clevis{
+					Tang: []Tang{},
+				},
+				Device: util.StrToPtr("/dev/foo"),
 			},
-			nil,
+			common.ErrClevisNoPins,
 			path.New("yaml"),
 		},
+		// threshold without pins
 		{
-			Filesystem{
-				Device:        "/dev/foo",
-				Format:        util.StrToPtr("zzz"),
-				Path:          util.StrToPtr("/z"),
-				WithMountUnit: util.BoolToPtr(true),
+			Luks{
+				Clevis: Clevis{
+					Threshold: util.IntToPtr(1),
+					Tpm2:      util.BoolToPtr(false),
+				},
+				Device: util.StrToPtr("/dev/foo"),
 			},
-			nil,
+			common.ErrClevisNoPins,
 			path.New("yaml"),
 		},
+		// tang only
 		{
-			Filesystem{
-				Device:        "/dev/foo",
-				Format:        util.StrToPtr("swap"),
-				WithMountUnit: util.BoolToPtr(true),
+			Luks{
+				Clevis: Clevis{
+					Tang: []Tang{
+						{
+							URL: "https://example.com/",
+						},
+					},
+				},
+				Device: util.StrToPtr("/dev/foo"),
 			},
 			nil,
 			path.New("yaml"),
 		},
+		// tpm2 only
 		{
-			Filesystem{
-				Device:        "/dev/foo",
-				WithMountUnit: util.BoolToPtr(true),
+			Luks{
+				Clevis: Clevis{
+					Tpm2: util.BoolToPtr(true),
+				},
+				Device: util.StrToPtr("/dev/foo"),
 			},
-			common.ErrMountUnitNoFormat,
-			path.New("yaml", "format"),
+			nil,
+			path.New("yaml"),
 		},
+		// custom
 		{
-			Filesystem{
-				Device:        "/dev/foo",
-				Format:        util.StrToPtr("zzz"),
-				WithMountUnit: util.BoolToPtr(true),
+			Luks{
+				Clevis: Clevis{
+					Custom: ClevisCustom{
+						Config: util.StrToPtr("{}"),
+						Pin:    util.StrToPtr("sss"),
+					},
+				},
+				Device: util.StrToPtr("/dev/foo"),
 			},
-			common.ErrMountUnitNoPath,
-			path.New("yaml", "path"),
+			nil,
+			path.New("yaml"),
 		},
 	}
 
@@ -342,6 +2057,123 @@ func TestValidateUnit(t *testing.T) {
 	}
 }
 
+func TestValidateSystem(t *testing.T) {
+	tests := []struct {
+		in      System
+		out     error
+		errPath path.ContextPath
+	}{
+		{},
+		// inline and local banners
+		{
+			System{
+				Issue: &Resource{
+					Inline: util.StrToPtr("hi"),
+				},
+				Motd: &Resource{
+					Local: util.StrToPtr("motd"),
+				},
+			},
+			nil,
+			path.New("yaml"),
+		},
+		// empty inline banner
+		{
+			System{
+				Motd: &Resource{
+					Inline: util.StrToPtr(""),
+				},
+			},
+			common.ErrBannerEmpty,
+			path.New("yaml", "motd", "inline"),
+		},
+		// no contents
+		{
+			System{
+				Issue: &Resource{},
+			},
+			common.ErrBannerEmpty,
+			path.New("yaml", "issue"),
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("validate %d", i), func(t *testing.T) {
+			actual := test.in.Validate(path.New("yaml"))
+			baseutil.VerifyReport(t, test.in, actual)
+			expected := report.Report{}
+			expected.AddOnError(test.errPath, test.out)
+			assert.Equal(t, expected, actual, "bad report")
+		})
+	}
+}
+
+// TestValidateUnitInstall tests that enabled units with an [Install]
+// section which can't enable anything are warned about
+func TestValidateUnitInstall(t *testing.T) {
+	tests := []struct {
+		in   Unit
+		warn error
+	}{
+		// wanted by a target
+		{
+			Unit{
+				Contents: util.StrToPtr("[Service]\nExecStart=/bin/true\n[Install]\nWantedBy=multi-user.target\n"),
+				Enabled:  util.BoolToPtr(true),
+			},
+			nil,
+		},
+		// alias
+		{
+			Unit{
+				Contents: util.StrToPtr("[Install]\nAlias=other.service\n"),
+				Enabled:  util.BoolToPtr(true),
+			},
+			nil,
+		},
+		// no targets
+		{
+			Unit{
+				Contents: util.StrToPtr("[Service]\nExecStart=/bin/true\n[Install]\nDefaultInstance=a\n"),
+				Enabled:  util.BoolToPtr(true),
+			},
+			common.ErrUnitInstallNoTarget,
+		},
+		// no targets, not enabled
+		{
+			Unit{
+				Contents: util.StrToPtr("[Install]\nDefaultInstance=a\n"),
+			},
+			nil,
+		},
+		// no [Install] section, left to Ignition
+		{
+			Unit{
+				Contents: util.StrToPtr("[Service]\nExecStart=/bin/true\n"),
+				Enabled:  util.BoolToPtr(true),
+			},
+			nil,
+		},
+		// no contents
+		{
+			Unit{
+				Enabled: util.BoolToPtr(true),
+			},
+			nil,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("validate %d", i), func(t *testing.T) {
+			actual := test.in.Validate(path.New("yaml"))
+			baseutil.VerifyReport(t, test.in, actual)
+			expected := report.Report{}
+			expected.AddOnWarn(path.New("yaml"), test.warn)
+			assert.Equal(t, expected, actual, "bad report")
+		})
+	}
+}
+
 // TestValidateDropin tests that multiple sources (i.e. contents and contents_local) are not allowed but zero or one sources are
 func TestValidateDropin(t *testing.T) {
 	tests := []struct {
@@ -14,10 +14,140 @@
 
 package common
 
+import (
+	"context"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/coreos/vcontext/report"
+)
+
+// ButaneTranslator translates a Butane config of any variant to an
+// Ignition config.
+type ButaneTranslator func(input []byte) ([]byte, report.Report, error)
+
+// CompressionPolicy returns true if a resource of the given size and
+// detected MIME type should be gzipped, even if that doesn't make it
+// smaller.
+type CompressionPolicy func(size int, contentType string) bool
+
 type TranslateOptions struct {
-	FilesDir                  string // allow embedding local files relative to this directory
-	NoResourceAutoCompression bool   // skip automatic compression of inline/local resources
-	DebugPrintTranslations    bool   // report translations to stderr
+	FilesDir                  string                       // allow embedding local files relative to this directory
+	FilesFS                   fs.FS                        // read local files from this filesystem instead of FilesDir
+	NoResourceAutoCompression bool                         // skip automatic compression of inline/local resources
+	CompressionPolicy         CompressionPolicy            // decide whether to compress each inline/local resource instead of compressing if smaller
+	VerifyCompression         bool                         // decode each embedded resource and tree file after encoding and fail if it doesn't match
+	DebugPrintTranslations    bool                         // report translations to stderr
+	SkipMissingLocalFiles     bool                         // warn and skip files, appends, units, dropins, trees, SSH key files, and password hashes whose local file doesn't exist
+	PathPrefix                string                       // absolute path prepended to storage node paths, filesystem mount points, and paths in generated mount units
+	PathRewriter              func(string) (string, error) // rewrite storage node paths to absolute paths before PathPrefix is applied; not used for mount points
+	ReadableDataURLs          bool                         // percent-encode short printable contents rather than choosing the smallest encoding
+	NoUnitComments            bool                         // omit the header comment from generated systemd units
+	AllowGit                  bool                         // allow fetching resource contents from git repositories
+	AllowOCI                  bool                         // allow fetching resource contents from OCI registries
+	AllowExec                 bool                         // allow running commands in AllowedExecCommands to produce resource contents
+	AllowedExecCommands       []string                     // commands which may be run if AllowExec is set; matched by the executable they resolve to via PATH
+	MaxTotalEmbeddedBytes     int                          // fail if the total size of embedded resource and tree contents exceeds this; 0 for no limit
+	EmitManifest              io.Writer                    // write a JSON inventory of produced nodes and units here
+	EmitPlan                  io.Writer                    // write a plain-language summary of the filesystems, nodes, and units of the config here
+	WarnReadOnlyMounts        bool                         // warn about storage nodes within filesystems with the ro mount option
+	TargetIgnitionVersion     string                       // fail if the config uses fields not supported by this Ignition spec version
+	IgnitionVersionOverride   string                       // set the Ignition spec version of the output to this, warning about fields it doesn't support
+	SourceName                string                       // name of the source config, such as its filename, to prefix to the messages of report entries
+	SourcePath                string                       // path of the source config within the files directory, so includes of it are reported as cycles
+	MergeInlineAppends        bool                         // concatenate consecutive inline append entries of a file
+	InlineAppendSeparator     string                       // inserted between inline append entries merged by MergeInlineAppends
+	TranslateButaneFragment   ButaneTranslator             // translate Butane configs in ignition.config.merge_trees; set by config.TranslateBytes and Check if nil
+	DevicePathForm            string                       // rewrite filesystem and LUKS devices on declared partitions to this form: partlabel or disk
+	ChecksumFile              string                       // add a file at this path containing the SHA-256 of the rest of the Ignition config
+	WarnUnknownDropinParents  bool                         // warn about dropins whose parent unit isn't declared in the config or listed in KnownUnits
+	KnownUnits                []string                     // units provided by the OS image, for WarnUnknownDropinParents
+	WarnDanglingLinks         bool                         // warn about absolute symlink targets not provided by the config or within KnownPaths
+	KnownPaths                []string                     // paths provided by the OS image, for WarnDanglingLinks
+	WarnTreeLinkCycles        bool                         // warn about symlinks from trees whose targets lead back to themselves
+	WarnDuplicateSSHKeys      bool                         // warn about SSH keys listed more than once for a user
+	DedupeSSHKeys             bool                         // drop repeated SSH keys of a user, keeping the first
+	WarnSharedCredentials     bool                         // warn about SSH keys and password hashes used by more than one user
+	InlineWarnBytes           int                          // warn about inline contents larger than this, suggesting local; 0 to disable
+	WarnRedundantCompression  bool                         // warn about compression fields of embedded contents which match what auto-compression would choose
+	DetectSecrets             bool                         // warn about embedded contents and password hashes which look like plaintext secrets
+	SecretPatterns            []string                     // additional regular expressions which DetectSecrets treats as secrets
+	FailOnSecrets             bool                         // report secrets found by DetectSecrets as errors rather than warnings
+	CheckUnitContents         bool                         // check unit and dropin contents for options and sections that systemd would ignore
+	FailOnUnitContents        bool                         // report problems found by CheckUnitContents as errors rather than warnings
+	EmitUnitGraph             io.Writer                    // write the ordering relationships of generated mount, swap, and automount units here
+	UnitGraphFormat           string                       // format for EmitUnitGraph: dot (the default) or json
+	ResourceStoreDir          string                       // write embedded resource contents to this directory, named by SHA-256, and reference them remotely
+	ResourceStoreURL          string                       // base URL of ResourceStoreDir when served to the target system
+	Timeout                   time.Duration                // fail reads, git fetches, and commands still running this long after translation starts; 0 for no limit
+	PreserveTreeMtimes        bool                         // add a first-boot service which restores the modification times of files from trees; ignored for merge_trees fragments
+	RequireExplicitModes      bool                         // fail if a file or directory would get a default or derived mode
+	MountStyle                string                       // how to mount filesystems with with_mount_unit: units (the default) or fstab entries
+	OrderMountsAfterFormat    bool                         // order mount and swap units of filesystems with wipe_filesystem after the systemd-makefs or systemd-mkswap unit for their device
+	MaxDataURLSize            int                          // split embedded file contents and appends across append entries so no data URL is longer than this; 0 for no limit
+	StrictYAMLScalars         bool                         // fail on booleans other than true and false, and on integers other than decimal or 0o-prefixed octal
+	GeneratedUnitPrefix       string                       // prepend this, such as 90-butane-, to the names of the growfs and tree mtimes services, .network files, and quadlet files Butane generates; mount, swap, and timer unit names are unchanged
+	SortUnits                 bool                         // sort systemd units by name, for stable output when diffing
+
+	embeddedBytes *int            // running total for MaxTotalEmbeddedBytes, shared between copies
+	ctx           context.Context // deadline for Timeout, shared between copies
+}
+
+// TrackEmbeddedBytes returns a copy of the options with a new running
+// total of embedded bytes, shared by copies of the result.
+func (o TranslateOptions) TrackEmbeddedBytes() TranslateOptions {
+	o.embeddedBytes = new(int)
+	return o
+}
+
+// AddEmbeddedBytes adds n bytes to the running total, and returns
+// ErrEmbeddedSizeExceeded if that exceeds MaxTotalEmbeddedBytes for the
+// first time.  It does nothing unless TrackEmbeddedBytes has been called.
+func (o TranslateOptions) AddEmbeddedBytes(n int) error {
+	if o.MaxTotalEmbeddedBytes <= 0 || o.embeddedBytes == nil {
+		return nil
+	}
+	previous := *o.embeddedBytes
+	*o.embeddedBytes += n
+	if previous <= o.MaxTotalEmbeddedBytes && *o.embeddedBytes > o.MaxTotalEmbeddedBytes {
+		return ErrEmbeddedSizeExceeded
+	}
+	return nil
+}
+
+// EmbeddedBytesRemaining returns the number of bytes which can be
+// embedded before MaxTotalEmbeddedBytes is exceeded, or -1 if there's no
+// limit or TrackEmbeddedBytes hasn't been called.
+func (o TranslateOptions) EmbeddedBytesRemaining() int {
+	if o.MaxTotalEmbeddedBytes <= 0 || o.embeddedBytes == nil {
+		return -1
+	}
+	if remaining := o.MaxTotalEmbeddedBytes - *o.embeddedBytes; remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// StartTimeout returns a copy of the options whose Context expires after
+// Timeout, and a function which releases the associated resources.  If
+// Timeout is zero or the options already have a deadline, the copy is
+// unchanged, so nested translations share the outermost deadline.
+func (o TranslateOptions) StartTimeout() (TranslateOptions, context.CancelFunc) {
+	if o.Timeout <= 0 || o.ctx != nil {
+		return o, func() {}
+	}
+	var cancel context.CancelFunc
+	o.ctx, cancel = context.WithTimeout(context.Background(), o.Timeout)
+	return o, cancel
+}
+
+// Context returns the context bounding IO performed during translation.
+func (o TranslateOptions) Context() context.Context {
+	if o.ctx == nil {
+		return context.Background()
+	}
+	return o.ctx
 }
 
 type TranslateBytesOptions struct {
@@ -17,6 +17,7 @@ package common
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/coreos/go-semver/semver"
 )
@@ -26,6 +27,11 @@ var (
 	ErrNoVariant      = errors.New("error parsing variant; must be specified")
 	ErrInvalidVersion = errors.New("error parsing version; must be a valid semver")
 
+	// strict YAML scalars
+	ErrStrictBool  = errors.New("boolean must be written as true or false")
+	ErrStrictOctal = errors.New("number with a leading zero is octal in YAML 1.1 but decimal in YAML 1.2; write modes with a 0o prefix, such as 0o644")
+	ErrStrictInt   = errors.New("integer must be written in decimal or as octal with a 0o prefix")
+
 	// high-level errors for fatal reports
 	ErrInvalidSourceConfig    = errors.New("source config is invalid")
 	ErrInvalidGeneratedConfig = errors.New("config generated was invalid")
@@ -34,24 +40,178 @@ var (
 	ErrRhcosVariantUnsupported = errors.New("rhcos variant has been removed; use openshift variant instead: https://coreos.github.io/butane/upgrading-openshift/")
 
 	// resources and trees
-	ErrTooManyResourceSources = errors.New("only one of the following can be set: inline, local, source")
-	ErrFilesDirEscape         = errors.New("local file path traverses outside the files directory")
-	ErrFileType               = errors.New("trees may only contain files, directories, and symlinks")
-	ErrNodeExists             = errors.New("matching filesystem node has existing contents or different type")
-	ErrNoFilesDir             = errors.New("local file paths are relative to a files directory that must be specified with -d/--files-dir")
-	ErrTreeNotDirectory       = errors.New("root of tree must be a directory")
-	ErrTreeNoLocal            = errors.New("local is required")
+	ErrTooManyResourceSources      = errors.New("only one of the following can be set: inline, local, source")
+	ErrCompressionRemote           = errors.New("compression describes the contents fetched from source, which Butane does not compress; set it only if those contents are already compressed")
+	ErrCompressionRedundant        = errors.New("Butane would choose the same compression automatically; compression can be removed")
+	ErrCompressionRedundantGzip    = errors.New("Butane would compress these contents automatically; consider embedding them uncompressed and removing compression")
+	ErrCompressionVerify           = errors.New("encoded contents don't decode to the original contents")
+	ErrFilesDirEscape              = errors.New("local file path traverses outside the files directory")
+	ErrFileType                    = errors.New("trees may only contain files, directories, and symlinks")
+	ErrNodeExists                  = errors.New("matching filesystem node has existing contents or different type")
+	ErrNodePathExists              = errors.New("path is already used by another node")
+	ErrNoFilesDir                  = errors.New("local file paths are relative to a files directory that must be specified with -d/--files-dir")
+	ErrTreeNotDirectory            = errors.New("root of tree must be a directory")
+	ErrTreeNoLocal                 = errors.New("local is required")
+	ErrTreeCompression             = errors.New("compression must be one of: gzip, none")
+	ErrTreeOverlap                 = errors.New("tree would write the same path as an earlier tree")
+	ErrTreeEmpty                   = errors.New("tree contains no files or symlinks")
+	ErrTreeOnSpecialFile           = errors.New("on_special_file must be one of: error, skip")
+	ErrTreeModeFilter              = errors.New("mode_filter must be one of: executable, non-executable, all")
+	ErrSpecialFileSkipped          = errors.New("skipping file which is not a regular file, directory, or symlink")
+	ErrTreeNodeSuppressed          = errors.New("skipping tree entry overridden by a files or links entry with contents or target")
+	ErrTreeCompressionIgnored      = errors.New("ignoring compression of files entry whose contents are supplied by a tree; the tree contents are compressed independently")
+	ErrTreeMtimesUnitExists        = errors.New("unit with the same name as the generated tree mtimes service already exists")
+	ErrTreeModesFileEntry          = errors.New("modes_file lines must contain a path followed by an octal mode")
+	ErrTreeModesFilePath           = errors.New("modes_file paths must be relative to the root of the tree and must not contain ..")
+	ErrTreeModesFileMode           = errors.New("modes_file modes must be octal numbers no greater than 07777")
+	ErrTreeModesFileDuplicate      = errors.New("modes_file lists the same path more than once")
+	ErrTreeModesFileUnknown        = errors.New("modes_file path does not match a file in the tree")
+	ErrTreeLinkCycle               = errors.New("symlinks from trees form a cycle")
+	ErrConfigTreeFileType          = errors.New("config trees may only contain Ignition (.ign) and Butane (.bu) config files")
+	ErrConfigTreeNotIgnition       = errors.New("file is not an Ignition config")
+	ErrConfigTreeButaneUnsupported = errors.New("Butane configs in config trees can only be translated by config.TranslateBytes")
+	ErrConfigTreeNested            = errors.New("Butane configs in config trees cannot themselves use config trees")
+	ErrSymlinkUnsupported          = errors.New("the files filesystem does not support reading symlinks")
+	ErrEmbeddedSizeExceeded        = errors.New("total size of embedded contents exceeds the configured limit")
+	ErrTimeout                     = errors.New("translation timed out")
+	ErrGitWithOtherSource          = errors.New("git cannot be combined with inline, local, or source")
+	ErrGitNotAllowed               = errors.New("fetching resources from git must be enabled with --allow-git")
+	ErrGitURLRequired              = errors.New("url is required")
+	ErrGitPathRequired             = errors.New("path is required")
+	ErrGitPathInvalid              = errors.New("path must be relative and must not traverse outside the repository")
+	ErrExecWithOtherSource         = errors.New("exec cannot be combined with inline, local, source, or git")
+	ErrExecNotAllowed              = errors.New("running commands must be enabled with --allow-exec")
+	ErrExecCommandNotAllowed       = errors.New("command is not in the list of allowed commands")
+	ErrExecCommandRequired         = errors.New("command is required")
+	ErrOCIWithOtherSource          = errors.New("oci cannot be combined with inline, local, source, git, or exec")
+	ErrOCINotAllowed               = errors.New("fetching resources from OCI registries must be enabled with --allow-oci")
+	ErrSourceUnchecked             = errors.New("resource not fetched or run while checking; contents not checked")
+	ErrOCIRefRequired              = errors.New("ref is required")
+	ErrOCIRefInvalid               = errors.New("ref must be of the form registry/repository:tag or registry/repository@digest")
+	ErrOCIDigestInvalid            = errors.New("digest must be of the form sha256:<hex> or sha512:<hex>")
+	ErrOCIFetch                    = errors.New("failed to fetch from OCI registry")
+	ErrOCINoLayers                 = errors.New("OCI artifact has no layers")
+	ErrOCILayerAmbiguous           = errors.New("OCI artifact has more than one layer; specify digest to select one")
+	ErrOCILayerNotFound            = errors.New("OCI artifact has no layer with digest")
+	ErrOCIDigestMismatch           = errors.New("OCI content doesn't match its digest")
+	ErrLineEndings                 = errors.New("line_endings must be one of: crlf, lf")
+	ErrTextTransformRemote         = errors.New("line_endings and add_bom can only be applied to inline, local, git, exec, or oci contents")
+	ErrTextTransformBinary         = errors.New("line_endings and add_bom can only be applied to UTF-8 text")
+	ErrInlineTooLarge              = errors.New("inline contents are large; consider moving them to a file referenced with local")
+	ErrHashNotHex                  = errors.New("hash sum must be hexadecimal")
+	ErrHashMismatch                = errors.New("embedded contents don't match verification hash")
+	ErrOverwriteWithAppend         = errors.New("overwrite cannot be combined with both contents and append")
+	ErrResourceStoreURLRequired    = errors.New("resource store directory requires a base URL")
+	ErrLocalFileSkipped            = errors.New("local file does not exist; skipping entry")
+	ErrChecksumFileNotAbsolute     = errors.New("checksum file path must be absolute")
+	ErrUnknownWrapFormat           = errors.New("wrapper format must be one of: mime, cloud-init")
+	ErrWrapHeaderInvalid           = errors.New("invalid wrapper part header")
+	ErrUnknownUserdataProvider     = errors.New("user data provider must be one of: aws, azure, gcp")
+	ErrUserdataTooLarge            = errors.New("config exceeds the provider's user data size limit")
+	ErrSecretPattern               = errors.New("invalid secret pattern")
+	ErrSecretDetected              = errors.New("contents may contain a plaintext secret")
+	ErrUnknownUnitGraphFormat      = errors.New("unit graph format must be one of: dot, json")
+	ErrUnknownDevicePathForm       = errors.New("device path form must be one of: partlabel, disk")
+	ErrUnknownMountStyle           = errors.New("mount style must be one of: units, fstab")
+	ErrMaxDataURLSizeTooSmall      = errors.New("maximum data URL size must be 0 or at least 17 bytes")
+	ErrGeneratedUnitPrefix         = errors.New("generated unit prefix may only contain letters, digits, colons, underscores, periods, hyphens, and backslashes")
+	ErrSplitVerification           = errors.New("contents with a verification hash can't be split across append entries; leaving the data URL longer than the maximum size")
+	ErrPathPrefixNotAbsolute       = errors.New("path prefix must be absolute")
+	ErrPathRewriteNotAbsolute      = errors.New("path rewriter returned a relative path")
+	ErrUnknownIgnitionVersion      = errors.New("unknown target Ignition spec version")
+	ErrFieldUnsupportedByIgnition  = errors.New("field is not supported by Ignition spec")
 
 	// filesystem nodes
-	ErrDecimalMode = errors.New("unreasonable mode would be reasonable if specified in octal; remember to add a leading zero")
+	ErrDecimalMode            = errors.New("unreasonable mode would be reasonable if specified in octal; remember to add a leading zero")
+	ErrModeNotExplicit        = errors.New("mode must be specified explicitly")
+	ErrNodeUnderReadOnlyMount = errors.New("path is within a filesystem mounted read-only; Ignition may fail to write it")
+	ErrLinkTargetUnknown      = errors.New("link target is not created by the config or within a declared filesystem or known path; the link may dangle")
+	ErrOwnerIDAndName         = errors.New("only one of id and name can be set")
+	ErrDefaultOwnerEmpty      = errors.New("default_owner sets neither a user nor a group")
+
+	// passwd
+	ErrTooManyPasswordHashSources = errors.New("only one of the following can be set: password_hash, password_hash_local")
+	ErrPasswordHashLocalLines     = errors.New("password hash file must contain exactly one line")
+	ErrSSHKeyDuplicate            = errors.New("SSH key is listed more than once for this user")
+	ErrSSHKeyShared               = errors.New("SSH key is also authorized for another user")
+	ErrPasswordHashShared         = errors.New("password hash is also used by another user")
+	ErrPasswordHashPlaintext      = errors.New("password hash doesn't look like a crypt(3) hash and may be a plaintext password")
 
 	// systemd
-	ErrTooManySystemdSources = errors.New("only one of the following can be set: contents, contents_local")
+	ErrTooManySystemdSources    = errors.New("only one of the following can be set: contents, contents_local")
+	ErrPresetUnitUndeclared     = errors.New("unit is not declared in systemd.units")
+	ErrDropinParentUnknown      = errors.New("dropin parent unit is not declared in the config or known; check for a misspelled unit name")
+	ErrPresetUnitConflict       = errors.New("unit is listed as both enabled and disabled")
+	ErrUnitInstallNoTarget      = errors.New("unit is enabled but its [Install] section has no WantedBy, RequiredBy, UpheldBy, Alias, or Also; enabling it will have no effect")
+	ErrUnitOptionOutsideSection = errors.New("unit option appears before the first section header and will be ignored by systemd")
+	ErrUnitOptionNameInvalid    = errors.New("invalid unit option name")
+	ErrUnitSectionUnknown       = errors.New("unknown section for this unit type; systemd will ignore it")
+
+	// timers
+	ErrTimerNameInvalid   = errors.New("name must be a non-empty unit name prefix without a suffix or instance separator")
+	ErrTimerNameDuplicate = errors.New("name is the same as that of an earlier timer")
+	ErrTimerNoOnCalendar  = errors.New("on_calendar is required")
+	ErrTimerBadOnCalendar = errors.New("on_calendar is not a valid calendar expression")
+	ErrTimerNoExecStart   = errors.New("exec_start is required")
+	ErrTimerBadExecStart  = errors.New("exec_start must be a single line")
+	ErrTimerUnitExists    = errors.New("unit with the same name already exists; merging with generated unit")
+
+	// login banners
+	ErrBannerEmpty      = errors.New("banner contents must be specified and non-empty")
+	ErrBannerFileExists = errors.New("file with the same path as the generated banner file already exists")
+
+	// networks
+	ErrNetworkNameInvalid   = errors.New("name must be a network interface name of at most 15 characters")
+	ErrNetworkNameDuplicate = errors.New("name is the same as that of an earlier network")
+	ErrNetworkAddress       = errors.New("address must be an IP address with a prefix length, such as 192.0.2.10/24")
+	ErrNetworkGateway       = errors.New("gateway must be an IP address")
+	ErrNetworkDNS           = errors.New("DNS server must be an IP address")
+	ErrNetworkFileExists    = errors.New("file with the same path as the generated network file already exists")
+
+	// containers
+	ErrContainerNameInvalid   = errors.New("name must be a non-empty unit name prefix without a suffix or instance separator")
+	ErrContainerNameDuplicate = errors.New("name is the same as that of an earlier container for the same user")
+	ErrContainerNoImage       = errors.New("image is required")
+	ErrContainerBadImage      = errors.New("image must not contain whitespace or control characters")
+	ErrContainerPort          = errors.New("port must be of the form [[ip:][host_port]:]container_port[/protocol], with ports or port ranges between 1 and 65535 and protocol tcp, udp, or sctp")
+	ErrContainerVolume        = errors.New("volume must be of the form source:destination[:options], with an absolute destination path")
+	ErrContainerEnvironment   = errors.New("environment entry must be of the form NAME=value, with a single-line value")
+	ErrContainerUserID        = errors.New("user_id must be a positive user ID; omit it for a rootful container")
+	ErrContainerFileExists    = errors.New("file with the same path as the generated container file already exists")
 
 	// mount units
-	ErrMountUnitNoPath     = errors.New("path is required if with_mount_unit is true and format is not swap")
-	ErrMountUnitNoFormat   = errors.New("format is required if with_mount_unit is true")
-	ErrMountPointForbidden = errors.New("path must be under /etc or /var if with_mount_unit is true")
+	ErrMountUnitNoPath        = errors.New("path is required if with_mount_unit is true and format is not swap")
+	ErrMountUnitNoFormat      = errors.New("format is required if with_mount_unit is true")
+	ErrMountUnitBadPath       = errors.New("path cannot be used in a mount unit: it must not contain control characters, begin or end with whitespace, or end with a backslash")
+	ErrMountPointForbidden    = errors.New("path must be under /etc or /var if with_mount_unit is true")
+	ErrMountUnitBadType       = errors.New("mount_type must be a non-empty token without whitespace")
+	ErrMountUnitNameCollision = errors.New("generated unit name is the same as that of an earlier filesystem")
+	ErrMountUnitExists        = errors.New("generated unit name is the same as that of an existing unit")
+	ErrFilesystemExists       = errors.New("device already has a filesystem entry")
+	ErrFstabFileExists        = errors.New("file /etc/fstab cannot be specified when generating fstab entries")
+	ErrFstabInstallRequires   = errors.New("mount_install_requires is ignored for fstab entries; the nofail mount option determines whether the mount is required")
+	ErrResizeNoMountUnit      = errors.New("resize requires with_mount_unit to be true")
+	ErrResizeFormat           = errors.New("resize is only supported for formats: btrfs, ext4, xfs")
+	ErrMountOptionUnknown     = errors.New("unknown systemd mount option; passing through unmodified")
+	ErrMountOptionNeedsValue  = errors.New("systemd mount option requires a value")
+	ErrMountOptionNoValue     = errors.New("systemd mount option does not take a value")
+	ErrMountOptionBadValue    = errors.New("systemd mount option value must not contain commas or whitespace")
+	ErrMountOptionBadName     = errors.New("systemd mount option name must not be empty or contain commas, equals signs, or whitespace")
+	ErrMountOrderUnitName     = errors.New("must be a unit name with a type suffix, such as provision.target")
+	ErrMountParentNoUnit      = errors.New("mount point is within a filesystem without with_mount_unit, which won't be mounted first")
+
+	// extensions
+	ErrExtensionName       = errors.New("name must be a non-empty file name without a .raw suffix")
+	ErrExtensionType       = errors.New("type must be one of: sysext, confext")
+	ErrExtensionDirectory  = errors.New("directory must be a search directory for the extension type under /etc, /run, or /var/lib")
+	ErrExtensionNoContents = errors.New("contents must specify source, local, inline, git, or exec")
+	ErrExtensionFileExists = errors.New("file with the same path as the generated extension image already exists")
+	ErrSysextSupport       = errors.New("systemd system and configuration extensions are not documented as supported on this distribution")
+
+	// filesystems
+	ErrWipeFilesystemNone            = errors.New("wipe_filesystem cannot be true if format is none")
+	ErrDeviceNotNormalized           = errors.New("device can't be normalized using the partitions declared in storage.disks; leaving unchanged")
+	ErrFilesystemOnLuksBackingDevice = errors.New("device is the backing device of a LUKS volume; the opened volume is /dev/mapper/<name>")
 
 	// boot device
 	ErrUnknownBootDeviceLayout = errors.New("layout must be one of: aarch64, ppc64le, s390x-eckd, s390x-virt, s390x-zfcp, x86_64")
@@ -60,6 +220,13 @@ var (
 	ErrMirrorNotSupport        = errors.New("mirroring not supported on layouts: s390x-eckd, s390x-zfcp, s390x-virt")
 	ErrLuksBootDeviceBadName   = errors.New("device name must start with /dev/dasd on s390x-eckd layout or /dev/sd on s390x-zfcp layout")
 
+	// proxy
+	ErrNoProxyInvalid = errors.New("no_proxy entry must be *, an IP address, a CIDR range, or a hostname or domain, optionally followed by a port")
+
+	// luks
+	ErrClevisNoPins        = errors.New("clevis requires at least one of: tang, tpm2, custom")
+	ErrLuksKeyFileEmbedded = errors.New("key file is embedded in the config; anyone who can read the config can unlock the volume")
+
 	// partition
 	ErrReuseByLabel         = errors.New("partitions cannot be reused by label; number must be specified except on boot disk (/dev/disk/by-id/coreos-boot-disk) or when wipe_table is true")
 	ErrWrongPartitionNumber = errors.New("incorrect partition number; a new partition will be created using reserved label")
@@ -96,6 +263,13 @@ var (
 
 	// Kernel arguments
 	ErrGeneralKernelArgumentSupport = errors.New("kernel argument customization is not supported in this spec version")
+
+	// includes
+	ErrIncludeType    = errors.New("include must be a path or a list of paths")
+	ErrIncludeCycle   = errors.New("config includes itself")
+	ErrIncludeDepth   = errors.New("includes are nested too deeply")
+	ErrIncludeVariant = errors.New("included config specifies a different variant or version")
+	ErrIncludeRoot    = errors.New("included config must be a mapping")
 )
 
 type ErrUnmarshal struct {
@@ -116,3 +290,17 @@ type ErrUnknownVersion struct {
 func (e ErrUnknownVersion) Error() string {
 	return fmt.Sprintf("No translator exists for variant %s with version %s", e.Variant, e.Version)
 }
+
+type ErrInclude struct {
+	// included files, outermost first
+	Chain []string
+	Err   error
+}
+
+func (e ErrInclude) Error() string {
+	return fmt.Sprintf("Error including %s: %v", strings.Join(e.Chain, " -> "), e.Err)
+}
+
+func (e ErrInclude) Unwrap() error {
+	return e.Err
+}
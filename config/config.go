@@ -15,6 +15,7 @@
 package config
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/coreos/butane/config/common"
@@ -39,6 +40,7 @@ import (
 	r4e1_0 "github.com/coreos/butane/config/r4e/v1_0"
 	r4e1_1 "github.com/coreos/butane/config/r4e/v1_1"
 	r4e1_2_exp "github.com/coreos/butane/config/r4e/v1_2_exp"
+	cutil "github.com/coreos/butane/config/util"
 
 	"github.com/coreos/go-semver/semver"
 	"github.com/coreos/vcontext/report"
@@ -56,6 +58,7 @@ type commonFields struct {
 }
 
 func init() {
+	cutil.RegisterFragmentTranslator(TranslateBytes)
 	RegisterTranslator("fcos", "1.0.0", fcos1_0.ToIgn3_0Bytes)
 	RegisterTranslator("fcos", "1.1.0", fcos1_1.ToIgn3_1Bytes)
 	RegisterTranslator("fcos", "1.2.0", fcos1_2.ToIgn3_2Bytes)
@@ -133,6 +136,13 @@ func TranslateBytes(input []byte, options common.TranslateBytesOptions) ([]byte,
 		return nil, report.Report{}, err
 	}
 
+	// start the timeout here, so fragments share it
+	var cancel context.CancelFunc
+	options.TranslateOptions, cancel = options.TranslateOptions.StartTimeout()
+	defer cancel()
+	if options.TranslateButaneFragment == nil {
+		options.TranslateButaneFragment = cutil.FragmentTranslator(options)
+	}
 	return translator(input, options)
 }
 
@@ -15,7 +15,13 @@
 package v1_6_exp
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	baseutil "github.com/coreos/butane/base/util"
@@ -29,6 +35,7 @@ import (
 	"github.com/coreos/vcontext/path"
 	"github.com/coreos/vcontext/report"
 	"github.com/stretchr/testify/assert"
+	"github.com/vincent-petithory/dataurl"
 )
 
 // Most of this is covered by the Ignition translator generic tests, so just test the custom bits
@@ -1530,6 +1537,7 @@ func TestTranslateGrub(t *testing.T) {
 		out        types.Config
 		exceptions []translate.Translation
 		report     report.Report
+		options    common.TranslateOptions
 	}{
 		// config with 1 user
 		{
@@ -1574,6 +1582,7 @@ func TestTranslateGrub(t *testing.T) {
 			},
 			translations,
 			report.Report{},
+			common.TranslateOptions{},
 		},
 		// config with 2 users (and 2 different hashes)
 		{
@@ -1622,12 +1631,60 @@ func TestTranslateGrub(t *testing.T) {
 			},
 			translations,
 			report.Report{},
+			common.TranslateOptions{},
+		},
+		// path prefix
+		{
+			Config{
+				Grub: Grub{
+					Users: []GrubUser{
+						{
+							Name:         "root",
+							PasswordHash: util.StrToPtr("grub.pbkdf2.sha512.10000.874A958E526409..."),
+						},
+					},
+				},
+			},
+			types.Config{
+				Ignition: types.Ignition{
+					Version: "3.5.0-experimental",
+				},
+				Storage: types.Storage{
+					Filesystems: []types.Filesystem{
+						{
+							Device: "/dev/disk/by-label/boot",
+							Format: util.StrToPtr("ext4"),
+							Path:   util.StrToPtr("/mnt/sysroot/boot"),
+						},
+					},
+					Files: []types.File{
+						{
+							Node: types.Node{
+								Path: "/mnt/sysroot/boot/grub2/user.cfg",
+							},
+							FileEmbedded1: types.FileEmbedded1{
+								Append: []types.Resource{
+									{
+										Source:      util.StrToPtr("data:,%23%20Generated%20by%20Butane%0A%0Aset%20superusers%3D%22root%22%0Apassword_pbkdf2%20root%20grub.pbkdf2.sha512.10000.874A958E526409...%0A"),
+										Compression: util.StrToPtr(""),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			translations,
+			report.Report{},
+			common.TranslateOptions{
+				PathPrefix: "/mnt/sysroot",
+			},
 		},
 	}
 
 	for i, test := range tests {
 		t.Run(fmt.Sprintf("translate %d", i), func(t *testing.T) {
-			actual, translations, r := test.in.ToIgn3_5Unvalidated(common.TranslateOptions{})
+			actual, translations, r := test.in.ToIgn3_5Unvalidated(test.options)
 			r = confutil.TranslateReportPaths(r, translations)
 			baseutil.VerifyReport(t, test.in, r)
 			assert.Equal(t, test.out, actual, "translation mismatch")
@@ -1637,3 +1694,426 @@ func TestTranslateGrub(t *testing.T) {
 		})
 	}
 }
+
+// TestToIgn3_5YAML tests emitting the Ignition config as YAML.
+func TestToIgn3_5YAML(t *testing.T) {
+	in := []byte(`variant: fcos
+version: 1.6.0-experimental
+systemd:
+  units:
+    - name: z.service
+      enabled: true
+      contents: |
+        [Service]
+        ExecStart=/bin/true
+        [Install]
+        WantedBy=multi-user.target
+storage:
+  files:
+    - path: /etc/a
+      mode: 0644
+      contents:
+        inline: "true"
+`)
+	expected := `# Generated by Butane; do not edit
+ignition:
+  version: 3.5.0-experimental
+storage:
+  files:
+    - contents:
+        compression: ""
+        source: data:,true
+      mode: 420
+      path: /etc/a
+systemd:
+  units:
+    - contents: |
+        [Service]
+        ExecStart=/bin/true
+        [Install]
+        WantedBy=multi-user.target
+      enabled: true
+      name: z.service`
+	actual, r, err := ToIgn3_5YAML(in, common.TranslateBytesOptions{})
+	assert.NoError(t, err, "translation failed")
+	assert.Equal(t, report.Report{}, r, "non-empty report")
+	assert.Equal(t, expected, string(actual), "bad YAML output")
+}
+
+// TestToIgn3_5BytesMergeLocal tests embedding local child Ignition configs
+// in ignition.config.
+func TestToIgn3_5BytesMergeLocal(t *testing.T) {
+	child := []byte(`{"ignition":{"version":"3.4.0"},"storage":{"files":[{"path":"/etc/child"}]}}`)
+	filesDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(filesDir, "child.ign"), child, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	in := []byte(`variant: fcos
+version: 1.6.0-experimental
+ignition:
+  config:
+    merge:
+      - local: child.ign
+      - inline: '{"ignition":{"version":"3.5.0-experimental"}}'
+    replace:
+      local: child.ign
+`)
+	options := common.TranslateBytesOptions{
+		TranslateOptions: common.TranslateOptions{
+			FilesDir:                  filesDir,
+			NoResourceAutoCompression: true,
+		},
+	}
+	out, r, err := ToIgn3_5Bytes(in, options)
+	assert.NoError(t, err, "translation failed")
+	assert.Equal(t, report.Report{}, r, "non-empty report")
+
+	var cfg types.Config
+	if err := json.Unmarshal(out, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	sources := []*string{
+		cfg.Ignition.Config.Merge[0].Source,
+		cfg.Ignition.Config.Replace.Source,
+	}
+	for i, source := range sources {
+		if !assert.NotNil(t, source, "missing source %d", i) {
+			continue
+		}
+		decoded, err := dataurl.DecodeString(*source)
+		if assert.NoError(t, err, "decoding source %d", i) {
+			assert.Equal(t, child, decoded.Data, "bad contents for source %d", i)
+		}
+	}
+
+	// missing child config
+	in = []byte(`variant: fcos
+version: 1.6.0-experimental
+ignition:
+  config:
+    merge:
+      - local: missing.ign
+`)
+	_, r, err = ToIgn3_5Bytes(in, options)
+	assert.Error(t, err, "translation succeeded")
+	if assert.Len(t, r.Entries, 1) {
+		assert.Equal(t, "$.ignition.config.merge.0.local", r.Entries[0].Context.String(), "bad error path")
+		assert.Equal(t, report.Error, r.Entries[0].Kind, "bad entry kind")
+		assert.Equal(t, int64(6), r.Entries[0].Marker.StartP.Line, "bad error line")
+	}
+}
+
+// TestToIgn3_5BytesConfigLocalCompressed tests embedding a local child
+// Ignition config large enough to be compressed, separately as a merge
+// and as a replacement, since the two are easily confused.
+func TestToIgn3_5BytesConfigLocalCompressed(t *testing.T) {
+	var files []string
+	for i := 0; i < 50; i++ {
+		files = append(files, fmt.Sprintf(`{"path":"/etc/child/%d"}`, i))
+	}
+	child := []byte(`{"ignition":{"version":"3.4.0"},"storage":{"files":[` + strings.Join(files, ",") + `]}}`)
+	filesDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(filesDir, "child.ign"), child, 0644); err != nil {
+		t.Fatal(err)
+	}
+	options := common.TranslateBytesOptions{
+		TranslateOptions: common.TranslateOptions{
+			FilesDir: filesDir,
+		},
+	}
+
+	tests := []struct {
+		in       string
+		resource func(types.Config) types.Resource
+		other    func(types.Config) bool
+	}{
+		{
+			"merge:\n      - local: child.ign",
+			func(cfg types.Config) types.Resource {
+				return cfg.Ignition.Config.Merge[0]
+			},
+			func(cfg types.Config) bool {
+				return cfg.Ignition.Config.Replace.Source != nil
+			},
+		},
+		{
+			"replace:\n      local: child.ign",
+			func(cfg types.Config) types.Resource {
+				return cfg.Ignition.Config.Replace
+			},
+			func(cfg types.Config) bool {
+				return len(cfg.Ignition.Config.Merge) > 0
+			},
+		},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("translate %d", i), func(t *testing.T) {
+			in := []byte("variant: fcos\nversion: 1.6.0-experimental\nignition:\n  config:\n    " + test.in + "\n")
+			out, r, err := ToIgn3_5Bytes(in, options)
+			if !assert.NoError(t, err, "translation failed") {
+				return
+			}
+			assert.Equal(t, report.Report{}, r, "non-empty report")
+
+			var cfg types.Config
+			if err := json.Unmarshal(out, &cfg); err != nil {
+				t.Fatal(err)
+			}
+			assert.False(t, test.other(cfg), "child config also used for the other field")
+			res := test.resource(cfg)
+			if !assert.NotNil(t, res.Source, "missing source") {
+				return
+			}
+			assert.Equal(t, util.StrToPtr("gzip"), res.Compression, "child config not compressed")
+			decoded, err := dataurl.DecodeString(*res.Source)
+			if !assert.NoError(t, err, "decoding source") {
+				return
+			}
+			contents, err := baseutil.GunzipBytes(decoded.Data)
+			if assert.NoError(t, err, "decompressing source") {
+				assert.Equal(t, child, contents, "bad contents")
+			}
+		})
+	}
+
+	// missing replacement config
+	in := []byte(`variant: fcos
+version: 1.6.0-experimental
+ignition:
+  config:
+    replace:
+      local: missing.ign
+`)
+	_, r, err := ToIgn3_5Bytes(in, options)
+	assert.Error(t, err, "translation succeeded")
+	if assert.Len(t, r.Entries, 1) {
+		assert.Equal(t, "$.ignition.config.replace.local", r.Entries[0].Context.String(), "bad error path")
+		assert.Equal(t, report.Error, r.Entries[0].Kind, "bad entry kind")
+		assert.Equal(t, int64(6), r.Entries[0].Marker.StartP.Line, "bad error line")
+	}
+}
+
+// TestToIgn3_5BytesLuksKeyFileLocal tests embedding a local LUKS key file.
+func TestToIgn3_5BytesLuksKeyFileLocal(t *testing.T) {
+	key := []byte("secret key\n")
+	filesDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(filesDir, "luks.key"), key, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	in := []byte(`variant: fcos
+version: 1.6.0-experimental
+storage:
+  luks:
+    - name: data
+      device: /dev/disk/by-partlabel/data
+      key_file:
+        local: luks.key
+      clevis:
+        tpm2: true
+  filesystems:
+    - device: /dev/mapper/data
+      path: /var/data
+      format: xfs
+      with_mount_unit: true
+`)
+	options := common.TranslateBytesOptions{
+		TranslateOptions: common.TranslateOptions{
+			FilesDir: filesDir,
+		},
+	}
+	out, r, err := ToIgn3_5Bytes(in, options)
+	assert.NoError(t, err, "translation failed")
+	if assert.Len(t, r.Entries, 1) {
+		assert.Equal(t, "$.storage.luks.0.key_file.local", r.Entries[0].Context.String(), "bad warning path")
+		assert.Equal(t, report.Warn, r.Entries[0].Kind, "bad entry kind")
+		assert.Equal(t, common.ErrLuksKeyFileEmbedded.Error(), r.Entries[0].Message, "bad warning")
+	}
+
+	var cfg types.Config
+	if err := json.Unmarshal(out, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, cfg.Storage.Luks, 1) && assert.NotNil(t, cfg.Storage.Luks[0].KeyFile.Source, "missing key file") {
+		assert.True(t, util.IsTrue(cfg.Storage.Luks[0].Clevis.Tpm2), "volume not bound")
+		decoded, err := dataurl.DecodeString(*cfg.Storage.Luks[0].KeyFile.Source)
+		if assert.NoError(t, err, "decoding key file") {
+			assert.Equal(t, key, decoded.Data, "bad key file contents")
+		}
+	}
+
+	// key file outside the files directory
+	in = []byte(`variant: fcos
+version: 1.6.0-experimental
+storage:
+  luks:
+    - name: data
+      device: /dev/disk/by-partlabel/data
+      key_file:
+        local: ../luks.key
+`)
+	_, r, err = ToIgn3_5Bytes(in, options)
+	assert.Error(t, err, "translation succeeded")
+	var errs []string
+	for _, entry := range r.Entries {
+		if entry.Kind == report.Error {
+			errs = append(errs, entry.Context.String()+": "+entry.Message)
+		}
+	}
+	assert.Equal(t, []string{"$.storage.luks.0.key_file.local: " + common.ErrFilesDirEscape.Error()}, errs, "bad errors")
+}
+
+// TestToIgn3_5BytesIgnitionVersionOverride tests overriding the spec
+// version of the output.
+func TestToIgn3_5BytesIgnitionVersionOverride(t *testing.T) {
+	in := []byte(`variant: fcos
+version: 1.6.0-experimental
+storage:
+  luks:
+    - name: data
+      device: /dev/disk/by-partlabel/data
+      discard: true
+`)
+	options := common.TranslateBytesOptions{
+		TranslateOptions: common.TranslateOptions{
+			IgnitionVersionOverride: "3.3.0",
+		},
+	}
+	out, r, err := ToIgn3_5Bytes(in, options)
+	assert.NoError(t, err, "translation failed")
+	if assert.Len(t, r.Entries, 1) {
+		assert.Equal(t, "$.storage.luks.0.discard", r.Entries[0].Context.String(), "bad warning path")
+		assert.Equal(t, report.Warn, r.Entries[0].Kind, "bad entry kind")
+		assert.Equal(t, common.ErrFieldUnsupportedByIgnition.Error()+" 3.3.0", r.Entries[0].Message, "bad warning")
+	}
+	var cfg types.Config
+	if err := json.Unmarshal(out, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "3.3.0", cfg.Ignition.Version, "bad version")
+
+	// unknown version
+	options.IgnitionVersionOverride = "3.6.0"
+	_, _, err = ToIgn3_5Bytes(in, options)
+	assert.ErrorIs(t, err, common.ErrUnknownIgnitionVersion, "bad error")
+}
+
+// TestToIgn3_5BytesSourceName tests annotating report entries with the
+// name of the config.
+func TestToIgn3_5BytesSourceName(t *testing.T) {
+	in := []byte(`variant: fcos
+version: 1.6.0-experimental
+unused: true
+storage:
+  luks:
+    - name: data
+      device: /dev/disk/by-partlabel/data
+      key_file:
+        inline: secret
+`)
+	options := common.TranslateBytesOptions{
+		TranslateOptions: common.TranslateOptions{
+			SourceName: "host.bu",
+		},
+	}
+	_, r, err := ToIgn3_5Bytes(in, options)
+	assert.NoError(t, err, "translation failed")
+	assert.Equal(t, "warning at $.unused, line 3 col 1: host.bu: Unused key unused\n"+
+		"warning at $.storage.luks.0.key_file.inline, line 9 col 17: host.bu: "+common.ErrLuksKeyFileEmbedded.Error()+"\n", r.String(), "bad report")
+
+	// unchanged without a name
+	options.SourceName = ""
+	_, r, err = ToIgn3_5Bytes(in, options)
+	assert.NoError(t, err, "translation failed")
+	assert.Equal(t, "warning at $.unused, line 3 col 1: Unused key unused\n"+
+		"warning at $.storage.luks.0.key_file.inline, line 9 col 17: "+common.ErrLuksKeyFileEmbedded.Error()+"\n", r.String(), "bad report")
+}
+
+// TestToIgn3_5BytesResourceStore tests that the resource store is only
+// written for valid configs, and is covered by the checksum file.
+func TestToIgn3_5BytesResourceStore(t *testing.T) {
+	in := []byte(`variant: fcos
+version: 1.6.0-experimental
+storage:
+  files:
+    - path: /etc/a
+      contents:
+        inline: hi
+`)
+	hiHash := "8f434346648f6b96df89dda901c5176b10a6d83961dd3c1ac88b59b2dc327aa4"
+	storeDir := filepath.Join(t.TempDir(), "store")
+	options := common.TranslateBytesOptions{
+		TranslateOptions: common.TranslateOptions{
+			ResourceStoreDir: storeDir,
+			ResourceStoreURL: "https://example.com/store",
+			ChecksumFile:     "/etc/checksum",
+		},
+	}
+	out, r, err := ToIgn3_5Bytes(in, options)
+	assert.NoError(t, err, "translation failed")
+	assert.Empty(t, r.Entries, "non-empty report")
+	var cfg types.Config
+	assert.NoError(t, json.Unmarshal(out, &cfg), "unmarshaling output")
+	if assert.Len(t, cfg.Storage.Files, 2, "bad files") {
+		assert.Equal(t, "https://example.com/store/"+hiHash, *cfg.Storage.Files[0].Contents.Source, "bad source")
+		// the checksum covers the stored reference
+		withoutChecksum := options
+		withoutChecksum.ChecksumFile = ""
+		serialized, _, err := ToIgn3_5Bytes(in, withoutChecksum)
+		assert.NoError(t, err, "translation failed")
+		sum := sha256.Sum256(serialized)
+		assert.Equal(t, "data:,sha256-"+hex.EncodeToString(sum[:])+"%0A", *cfg.Storage.Files[1].Contents.Source, "bad checksum")
+	}
+	_, err = os.Stat(filepath.Join(storeDir, hiHash))
+	assert.NoError(t, err, "missing stored file")
+
+	// nothing is written for an invalid config
+	invalidDir := filepath.Join(t.TempDir(), "store")
+	options.ResourceStoreDir = invalidDir
+	options.ChecksumFile = "/etc/a"
+	_, _, err = ToIgn3_5Bytes(in, options)
+	assert.ErrorIs(t, err, common.ErrInvalidGeneratedConfig, "bad error")
+	_, err = os.Stat(invalidDir)
+	assert.ErrorIs(t, err, os.ErrNotExist, "store written")
+
+	// base URL is required
+	options.ChecksumFile = ""
+	options.ResourceStoreURL = ""
+	_, _, err = ToIgn3_5Bytes(in, options)
+	assert.ErrorIs(t, err, common.ErrResourceStoreURLRequired, "bad error")
+}
+
+// TestToIgn3_5BytesStrictYAMLScalars tests rejecting ambiguous booleans
+// and modes.
+func TestToIgn3_5BytesStrictYAMLScalars(t *testing.T) {
+	in := []byte(`variant: fcos
+version: 1.6.0-experimental
+storage:
+  files:
+    - path: /etc/a
+      mode: 0644
+      overwrite: yes
+      contents:
+        inline: a
+    - path: /etc/b
+      mode: 0o644
+      overwrite: true
+      contents:
+        inline: b
+`)
+	// accepted by default
+	_, r, err := ToIgn3_5Bytes(in, common.TranslateBytesOptions{})
+	assert.NoError(t, err, "translation failed")
+	assert.Equal(t, "", r.String(), "bad report")
+
+	options := common.TranslateBytesOptions{
+		TranslateOptions: common.TranslateOptions{
+			StrictYAMLScalars: true,
+		},
+	}
+	_, r, err = ToIgn3_5Bytes(in, options)
+	assert.ErrorIs(t, err, common.ErrInvalidSourceConfig, "translation succeeded")
+	assert.Equal(t, "error at $.storage.files.0.mode, line 6 col 13: "+common.ErrStrictOctal.Error()+"\n"+
+		"error at $.storage.files.0.overwrite, line 7 col 18: "+common.ErrStrictBool.Error()+"\n", r.String(), "bad report")
+}
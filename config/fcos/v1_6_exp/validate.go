@@ -47,6 +47,9 @@ func (conf Config) Validate(c path.ContextPath) (r report.Report) {
 			r.AddOnError(c.Append("storage", "filesystems", i, "path"), common.ErrMountPointForbidden)
 		}
 	}
+	for i := range conf.Storage.Extensions {
+		r.AddOnWarn(c.Append("storage", "extensions", i), common.ErrSysextSupport)
+	}
 	return
 }
 
@@ -16,7 +16,9 @@ package v1_6_exp
 
 import (
 	"fmt"
+	"io/fs"
 	"testing"
+	"testing/fstest"
 
 	baseutil "github.com/coreos/butane/base/util"
 	base "github.com/coreos/butane/base/v0_6_exp"
@@ -467,6 +469,25 @@ func TestValidateConfig(t *testing.T) {
 			out:     common.ErrReuseByLabel,
 			errPath: path.New("yaml", "storage", "disks", 0, "partitions", 0, "number"),
 		},
+		// extension image
+		{
+			in: Config{
+				Config: base.Config{
+					Storage: base.Storage{
+						Extensions: []base.Extension{
+							{
+								Name: "foo",
+								Contents: base.Resource{
+									Source: util.StrToPtr("https://example.com/foo.raw"),
+								},
+							},
+						},
+					},
+				},
+			},
+			out:     common.ErrSysextSupport,
+			errPath: path.New("yaml", "storage", "extensions", 0),
+		},
 	}
 
 	for i, test := range tests {
@@ -479,3 +500,146 @@ func TestValidateConfig(t *testing.T) {
 		})
 	}
 }
+
+// TestCheck tests that Check reports the same problems as translation,
+// except for local files if no files directory is specified.
+func TestCheck(t *testing.T) {
+	localFile := base.File{
+		Path: "/etc/local",
+		Mode: util.IntToPtr(644),
+		Contents: base.Resource{
+			Local: util.StrToPtr("missing"),
+		},
+	}
+	execFile := base.File{
+		Path: "/etc/exec",
+		Contents: base.Resource{
+			Exec: &base.ExecResource{
+				Command: "echo",
+			},
+		},
+	}
+	tests := []struct {
+		in      Config
+		options common.TranslateOptions
+		out     string
+	}{
+		// local file skipped without a files dir
+		{
+			in: Config{
+				Config: base.Config{
+					Storage: base.Storage{
+						Files: []base.File{localFile},
+					},
+				},
+			},
+			out: "warning at $.storage.files.0.mode: " + common.ErrDecimalMode.Error() + "\n",
+		},
+		// local file skipped without a files dir, with a source name
+		{
+			in: Config{
+				Config: base.Config{
+					Storage: base.Storage{
+						Files: []base.File{localFile},
+					},
+				},
+			},
+			options: common.TranslateOptions{
+				SourceName: "host.bu",
+			},
+			out: "warning at $.storage.files.0.mode: host.bu: " + common.ErrDecimalMode.Error() + "\n",
+		},
+		// local file read with a files dir
+		{
+			in: Config{
+				Config: base.Config{
+					Storage: base.Storage{
+						Files: []base.File{localFile},
+					},
+				},
+			},
+			options: common.TranslateOptions{
+				FilesFS: fstest.MapFS{},
+			},
+			out: "warning at $.storage.files.0.mode: " + common.ErrDecimalMode.Error() + "\n" +
+				"error at $.storage.files.0.contents.local: open missing: " + fs.ErrNotExist.Error() + "\n",
+		},
+		// allowed exec resource not run
+		{
+			in: Config{
+				Config: base.Config{
+					Storage: base.Storage{
+						Files: []base.File{execFile},
+					},
+				},
+			},
+			options: common.TranslateOptions{
+				AllowExec:           true,
+				AllowedExecCommands: []string{"echo"},
+			},
+			out: "warning at $.storage.files.0.contents.exec: " + common.ErrSourceUnchecked.Error() + "\n",
+		},
+		// disallowed exec resource still reported
+		{
+			in: Config{
+				Config: base.Config{
+					Storage: base.Storage{
+						Files: []base.File{execFile},
+					},
+				},
+			},
+			out: "error at $.storage.files.0.contents.exec: " + common.ErrExecNotAllowed.Error() + "\n",
+		},
+		// allowed git resource not fetched
+		{
+			in: Config{
+				Config: base.Config{
+					Storage: base.Storage{
+						Files: []base.File{
+							{
+								Path: "/etc/git",
+								Contents: base.Resource{
+									Git: &base.GitResource{
+										URL:  "https://example.com/repo.git",
+										Path: "file",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			options: common.TranslateOptions{
+				AllowGit: true,
+			},
+			out: "warning at $.storage.files.0.contents.git: " + common.ErrSourceUnchecked.Error() + "\n",
+		},
+		// checks of the generated config
+		{
+			in: Config{
+				Config: base.Config{
+					Storage: base.Storage{
+						Files: []base.File{
+							{
+								Path: "/etc/a",
+							},
+							{
+								Path: "/etc/a",
+							},
+						},
+					},
+				},
+			},
+			out: "error at $.storage.files.1: " + errors.ErrDuplicate.Error() + "\n",
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("check %d", i), func(t *testing.T) {
+			test.in.Version = "1.6.0-experimental"
+			test.in.Variant = "fcos"
+			r := test.in.Check(test.options)
+			assert.Equal(t, test.out, r.String(), "bad report")
+		})
+	}
+}
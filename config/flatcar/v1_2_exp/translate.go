@@ -15,6 +15,8 @@
 package v1_2_exp
 
 import (
+	"io"
+
 	"github.com/coreos/butane/config/common"
 	cutil "github.com/coreos/butane/config/util"
 
@@ -33,6 +35,14 @@ func (c Config) FieldFilters() *cutil.FieldFilters {
 	return &fieldFilters
 }
 
+// Check returns a report of any errors or warnings that translating the
+// config would produce, without producing any output.  Local files are
+// only read if options specify a FilesDir or FilesFS, and git, OCI, and
+// exec resources aren't fetched or run.
+func (c Config) Check(options common.TranslateOptions) report.Report {
+	return cutil.Check(c, "ToIgn3_5Unvalidated", options)
+}
+
 // ToIgn3_5 translates the config to an Ignition config.  It returns a
 // report of any errors or warnings in the source and resultant config.  If
 // the report has fatal errors or it encounters other problems translating,
@@ -48,3 +58,20 @@ func (c Config) ToIgn3_5(options common.TranslateOptions) (types.Config, report.
 func ToIgn3_5Bytes(input []byte, options common.TranslateBytesOptions) ([]byte, report.Report, error) {
 	return cutil.TranslateBytes(input, &Config{}, "ToIgn3_5", options)
 }
+
+// ToIgn3_5Writer translates from a v1.2 Butane config to a v3.5.0 Ignition config and writes it to w. The output
+// is identical to that of ToIgn3_5Bytes, but the JSON is marshaled incrementally to reduce memory usage for large
+// configs. It returns a report of any errors or warnings in the source and resultant config. If the report has
+// fatal errors or it encounters other problems translating, an error is returned and nothing is written. If
+// writing fails partway through, w may have received partial output.
+func ToIgn3_5Writer(w io.Writer, input []byte, options common.TranslateBytesOptions) (report.Report, error) {
+	return cutil.TranslateBytesWriter(w, input, &Config{}, "ToIgn3_5", options)
+}
+
+// ToIgn3_5YAML translates from a v1.2 Butane config to a v3.5.0 Ignition config marshaled as YAML with sorted keys,
+// for inspecting or diffing. Ignition cannot read the output. It returns a report of any errors or warnings in the
+// source and resultant config. If the report has fatal errors or it encounters other problems translating, an error
+// is returned.
+func ToIgn3_5YAML(input []byte, options common.TranslateBytesOptions) ([]byte, report.Report, error) {
+	return cutil.TranslateBytesYAML(input, &Config{}, "ToIgn3_5", options)
+}
@@ -15,6 +15,7 @@
 package v4_15_exp
 
 import (
+	"io"
 	"net/url"
 	"strings"
 
@@ -194,6 +195,14 @@ func (c Config) ToIgn3_5Unvalidated(options common.TranslateOptions) (types.Conf
 	return cfg, ts, r
 }
 
+// Check returns a report of any errors or warnings that translating the
+// config would produce, without producing any output.  Local files are
+// only read if options specify a FilesDir or FilesFS, and git, OCI, and
+// exec resources aren't fetched or run.
+func (c Config) Check(options common.TranslateOptions) report.Report {
+	return cutil.Check(c, "ToMachineConfig4_15Unvalidated", options)
+}
+
 // ToIgn3_5 translates the config to an Ignition config.  It returns a
 // report of any errors or warnings in the source and resultant config.  If
 // the report has fatal errors or it encounters other problems translating,
@@ -214,6 +223,20 @@ func ToConfigBytes(input []byte, options common.TranslateBytesOptions) ([]byte,
 	}
 }
 
+// ToConfigWriter translates from a v4.15 Butane config to a v4.15 MachineConfig or a v3.5.0 Ignition config and
+// writes it to w. The output is identical to that of ToConfigBytes. An Ignition config is marshaled incrementally
+// to reduce memory usage for large configs; a MachineConfig is converted in memory and encoded directly to w. It
+// returns a report of any errors or warnings in the source and resultant config. If the report has fatal errors or
+// it encounters other problems translating, an error is returned and nothing is written. If writing fails partway
+// through, w may have received partial output.
+func ToConfigWriter(w io.Writer, input []byte, options common.TranslateBytesOptions) (report.Report, error) {
+	if options.Raw {
+		return cutil.TranslateBytesWriter(w, input, &Config{}, "ToIgn3_5", options)
+	} else {
+		return cutil.TranslateBytesYAMLWriter(w, input, &Config{}, "ToMachineConfig4_15", options)
+	}
+}
+
 func addLuksFipsOptions(mc *result.MachineConfig) translate.TranslationSet {
 	ts := translate.NewTranslationSet("yaml", "json")
 	if !util.IsTrue(mc.Spec.FIPS) {
@@ -15,6 +15,7 @@
 package v4_15_exp
 
 import (
+	"bytes"
 	"fmt"
 	"testing"
 
@@ -596,3 +597,43 @@ func TestValidateSupport(t *testing.T) {
 		})
 	}
 }
+
+// TestToConfigWriter checks that the writer produces the same output as
+// ToConfigBytes.
+func TestToConfigWriter(t *testing.T) {
+	input := []byte(`variant: openshift
+version: 4.15.0-experimental
+metadata:
+  name: config
+  labels:
+    machineconfiguration.openshift.io/role: worker
+storage:
+  files:
+    - path: /etc/motd
+      contents:
+        inline: hello
+`)
+	for _, raw := range []bool{false, true} {
+		for _, pretty := range []bool{false, true} {
+			t.Run(fmt.Sprintf("raw %v pretty %v", raw, pretty), func(t *testing.T) {
+				options := common.TranslateBytesOptions{
+					Raw:    raw,
+					Pretty: pretty,
+				}
+				expected, expectedReport, err := ToConfigBytes(input, options)
+				assert.NoError(t, err, "translating")
+				var actual bytes.Buffer
+				r, err := ToConfigWriter(&actual, input, options)
+				assert.NoError(t, err, "translating to writer")
+				assert.Equal(t, expectedReport, r, "report mismatch")
+				assert.Equal(t, string(expected), actual.String(), "output mismatch")
+			})
+		}
+	}
+
+	// nothing is written on failure
+	var out bytes.Buffer
+	_, err := ToConfigWriter(&out, []byte("variant: openshift\nversion: 4.15.0-experimental\n"), common.TranslateBytesOptions{})
+	assert.Error(t, err, "translating config without metadata")
+	assert.Empty(t, out.String(), "output written on failure")
+}
@@ -0,0 +1,95 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+
+	"github.com/coreos/butane/translate"
+
+	"github.com/coreos/vcontext/path"
+)
+
+// addChecksumFile returns a copy of the translated config final with a
+// file at filePath recording the checksum of the Ignition config.
+//
+// The checksum is computed in a first pass, before the file is added.  It
+// covers the Ignition config only, excluding any wrapper such as a
+// MachineConfig, serialized as compact JSON exactly as Butane writes it
+// without --pretty.  The file contains "sha256-" followed by the
+// lowercase hex digest and a newline, and has mode 0644.  To verify it, remove
+// the checksum file from the end of storage.files, serialize the config
+// the same way, and hash the result.
+func addChecksumFile(final interface{}, ts translate.TranslationSet, filePath string) (interface{}, translate.TranslationSet, error) {
+	v := reflect.New(reflect.TypeOf(final)).Elem()
+	v.Set(reflect.ValueOf(final))
+	cfg, cfgPath, ok := findIgnitionConfig(v, path.New("json"))
+	if !ok {
+		return nil, ts, fmt.Errorf("no Ignition config found in %T", final)
+	}
+	serialized, err := marshal(cfg.Interface(), false)
+	if err != nil {
+		return nil, ts, err
+	}
+	sum := sha256.Sum256(serialized)
+	contents := "sha256-" + hex.EncodeToString(sum[:])
+
+	storage, ok := jsonField(cfg, "storage")
+	if !ok {
+		return nil, ts, fmt.Errorf("no storage section in %T", final)
+	}
+	files, ok := jsonField(storage, "files")
+	if !ok {
+		return nil, ts, fmt.Errorf("no storage.files section in %T", final)
+	}
+	file := reflect.New(files.Type().Elem()).Elem()
+	setJSONField(file, "path", filePath)
+	setJSONField(file, "mode", 0644)
+	if resource, ok := rawJSONField(file, "contents"); ok {
+		setJSONField(resource, "source", "data:,"+contents+"%0A")
+	}
+	filesPath := cfgPath.Append("storage", "files")
+	// copy so the translations below don't share filesPath's backing array
+	filePathJSON := filesPath.Append(files.Len()).Copy()
+	files.Set(reflect.Append(files, file))
+
+	// attribute the file to the config as a whole
+	for _, p := range []path.ContextPath{cfgPath.Append("storage"), filesPath} {
+		if _, ok := ts.Lookup(p); !ok {
+			ts.AddTranslation(path.New("yaml"), p)
+		}
+	}
+	ts.AddFromCommonSource(path.New("yaml"), filePathJSON, file.Interface())
+	return v.Interface(), ts, nil
+}
+
+// setJSONField sets the field of struct v with the JSON name name to
+// value, allocating it if it's a pointer.
+func setJSONField(v reflect.Value, name string, value interface{}) {
+	f, ok := rawJSONField(v, name)
+	if !ok {
+		panic(fmt.Sprintf("no field %q in %s", name, v.Type()))
+	}
+	if f.Kind() == reflect.Ptr {
+		p := reflect.New(f.Type().Elem())
+		p.Elem().Set(reflect.ValueOf(value))
+		f.Set(p)
+		return
+	}
+	f.Set(reflect.ValueOf(value))
+}
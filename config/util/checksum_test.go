@@ -0,0 +1,103 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/coreos/butane/translate"
+
+	"github.com/coreos/ignition/v2/config/util"
+	"github.com/coreos/ignition/v2/config/v3_5_experimental/types"
+	"github.com/coreos/vcontext/path"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAddChecksumFile checks that the checksum file records the hash of
+// the config without it.
+func TestAddChecksumFile(t *testing.T) {
+	cfg := types.Config{
+		Ignition: types.Ignition{
+			Version: "3.5.0-experimental",
+		},
+		Storage: types.Storage{
+			Files: []types.File{
+				{
+					Node: types.Node{
+						Path: "/etc/a",
+					},
+					FileEmbedded1: types.FileEmbedded1{
+						Contents: types.Resource{
+							Source: util.StrToPtr("data:,a"),
+						},
+					},
+				},
+			},
+		},
+	}
+	serialized, err := marshal(cfg, false)
+	assert.NoError(t, err, "marshaling")
+	sum := sha256.Sum256(serialized)
+	checksumFile := types.File{
+		Node: types.Node{
+			Path: "/etc/checksum",
+		},
+		FileEmbedded1: types.FileEmbedded1{
+			Contents: types.Resource{
+				Source: util.StrToPtr("data:,sha256-" + hex.EncodeToString(sum[:]) + "%0A"),
+			},
+			Mode: util.IntToPtr(0644),
+		},
+	}
+	expected := cfg
+	expected.Storage.Files = append([]types.File{}, cfg.Storage.Files...)
+	expected.Storage.Files = append(expected.Storage.Files, checksumFile)
+
+	// wrapped config, e.g. a MachineConfig
+	type wrapper struct {
+		Spec struct {
+			Config types.Config `json:"config"`
+		} `json:"spec"`
+	}
+	var wrapped, expectedWrapped wrapper
+	wrapped.Spec.Config = cfg
+	expectedWrapped.Spec.Config = expected
+
+	tests := []struct {
+		in      interface{}
+		out     interface{}
+		cfgPath path.ContextPath
+	}{
+		{cfg, expected, path.New("json")},
+		{wrapped, expectedWrapped, path.New("json", "spec", "config")},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("checksum %d", i), func(t *testing.T) {
+			ts := translate.NewTranslationSet("yaml", "json")
+			ts.AddTranslation(path.New("yaml", "storage", "files", 0), test.cfgPath.Append("storage", "files", 0))
+			actual, ts, err := addChecksumFile(test.in, ts, "/etc/checksum")
+			assert.NoError(t, err, "adding checksum file")
+			assert.Equal(t, test.out, actual, "bad output")
+			from, ok := ts.Lookup(test.cfgPath.Append("storage", "files", 1, "contents", "source"))
+			assert.True(t, ok, "missing translation")
+			assert.Equal(t, path.New("yaml"), from.From, "bad translation")
+			// input must not be modified
+			assert.Len(t, cfg.Storage.Files, 1, "input modified")
+		})
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/coreos/butane/config/common"
+
+	ignerrors "github.com/coreos/ignition/v2/config/shared/errors"
+	types3_0 "github.com/coreos/ignition/v2/config/v3_0/types"
+	types3_1 "github.com/coreos/ignition/v2/config/v3_1/types"
+	types3_2 "github.com/coreos/ignition/v2/config/v3_2/types"
+	types3_3 "github.com/coreos/ignition/v2/config/v3_3/types"
+	types3_4 "github.com/coreos/ignition/v2/config/v3_4/types"
+	types3_5_exp "github.com/coreos/ignition/v2/config/v3_5_experimental/types"
+	"github.com/coreos/vcontext/path"
+	"github.com/coreos/vcontext/report"
+)
+
+var (
+	// Ignition config structs by spec version.  A field is supported by
+	// a spec version if its JSON path exists in the struct.
+	ignitionSpecs = map[string]reflect.Type{
+		types3_0.MaxVersion.String():     reflect.TypeOf(types3_0.Config{}),
+		types3_1.MaxVersion.String():     reflect.TypeOf(types3_1.Config{}),
+		types3_2.MaxVersion.String():     reflect.TypeOf(types3_2.Config{}),
+		types3_3.MaxVersion.String():     reflect.TypeOf(types3_3.Config{}),
+		types3_4.MaxVersion.String():     reflect.TypeOf(types3_4.Config{}),
+		types3_5_exp.MaxVersion.String(): reflect.TypeOf(types3_5_exp.Config{}),
+	}
+)
+
+// checkIgnitionVersion reports fields in the translated config final,
+// which is an Ignition config or a wrapper containing one, that aren't
+// supported by the specified Ignition spec version, which must be in
+// ignitionSpecs.
+func checkIgnitionVersion(final interface{}, version string) (r report.Report) {
+	target, ok := ignitionSpecs[version]
+	if !ok {
+		panic(fmt.Errorf("unknown Ignition spec version %q", version))
+	}
+	cfg, cfgPath, ok := findIgnitionConfig(reflect.ValueOf(final), path.New("json"))
+	if !ok {
+		return
+	}
+	checkSupportedFields(cfg, target, cfgPath, version, &r)
+	return
+}
+
+func checkSupportedFields(v reflect.Value, target reflect.Type, p path.ContextPath, version string, r *report.Report) {
+	// a non-nil pointer is set, even if it points to a zero value
+	if !v.IsValid() || isEmptyValue(v) {
+		return
+	}
+	v = indirect(v)
+	for target.Kind() == reflect.Ptr {
+		target = target.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Slice:
+		if target.Kind() != reflect.Slice {
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			checkSupportedFields(v.Index(i), target.Elem(), p.Append(i), version, r)
+		}
+	case reflect.Struct:
+		if target.Kind() != reflect.Struct {
+			return
+		}
+		targetFields := make(map[string]reflect.Type)
+		collectFieldTypes(target, targetFields)
+		for _, f := range jsonFields(v) {
+			name, field := f.name, f.value
+			if name == "version" && p.Len() > 0 && p.Path[p.Len()-1] == "ignition" {
+				// the spec version itself
+				continue
+			}
+			fieldTarget, ok := targetFields[name]
+			if !ok {
+				if !isEmptyValue(field) {
+					r.AddOnError(p.Append(name), fmt.Errorf("%w %s", common.ErrFieldUnsupportedByIgnition, version))
+				}
+				continue
+			}
+			checkSupportedFields(field, fieldTarget, p.Append(name), version, r)
+		}
+	}
+}
+
+// collectFieldTypes adds the JSON names and types of the fields of struct
+// type t to m, including fields of embedded structs.
+func collectFieldTypes(t reflect.Type, m map[string]reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Anonymous {
+			collectFieldTypes(sf.Type, m)
+			continue
+		}
+		if name, ok := jsonName(sf); ok {
+			m[name] = sf.Type
+		}
+	}
+}
+
+// jsonFields returns the fields of struct v with their JSON names, in
+// order, including fields of embedded structs.
+func jsonFields(v reflect.Value) []streamField {
+	var ret []streamField
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Anonymous {
+			ret = append(ret, jsonFields(v.Field(i))...)
+			continue
+		}
+		if name, ok := jsonName(sf); ok {
+			ret = append(ret, streamField{
+				name:  name,
+				value: v.Field(i),
+			})
+		}
+	}
+	return ret
+}
+
+// setIgnitionVersion returns a copy of the translated config final with
+// the Ignition spec version set to version.
+func setIgnitionVersion(final interface{}, version string) interface{} {
+	v := reflect.New(reflect.TypeOf(final)).Elem()
+	v.Set(reflect.ValueOf(final))
+	cfg, _, ok := findIgnitionConfig(v, path.New("json"))
+	if !ok {
+		panic(fmt.Errorf("no Ignition config found in %T", final))
+	}
+	ignition, _ := jsonField(cfg, "ignition")
+	setJSONField(ignition, "version", version)
+	return v.Interface()
+}
+
+// dropUnknownVersion returns r without the errors Ignition reports for a
+// spec version other than the one the config structs are for.
+func dropUnknownVersion(r report.Report) report.Report {
+	var ret report.Report
+	for _, entry := range r.Entries {
+		p := entry.Context
+		if entry.Kind == report.Error && entry.Message == ignerrors.ErrUnknownVersion.Error() &&
+			p.Len() >= 2 && p.Path[p.Len()-2] == "ignition" && p.Path[p.Len()-1] == "version" {
+			continue
+		}
+		ret.Entries = append(ret.Entries, entry)
+	}
+	return ret
+}
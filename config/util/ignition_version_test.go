@@ -0,0 +1,134 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/coreos/ignition/v2/config/util"
+	"github.com/coreos/ignition/v2/config/v3_5_experimental/types"
+	"github.com/coreos/vcontext/path"
+	"github.com/coreos/vcontext/report"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckIgnitionVersion(t *testing.T) {
+	cfg := types.Config{
+		Ignition: types.Ignition{
+			Version: "3.5.0-experimental",
+		},
+		KernelArguments: types.KernelArguments{
+			ShouldExist: []types.KernelArgument{"foo"},
+		},
+		Storage: types.Storage{
+			Files: []types.File{
+				{
+					Node: types.Node{
+						Path: "/etc/a",
+					},
+					FileEmbedded1: types.FileEmbedded1{
+						Contents: types.Resource{
+							Source: util.StrToPtr("https://example.com/a"),
+							HTTPHeaders: types.HTTPHeaders{
+								{
+									Name:  "a",
+									Value: util.StrToPtr("b"),
+								},
+							},
+						},
+					},
+				},
+			},
+			Luks: []types.Luks{
+				{
+					Name:    "root",
+					Device:  util.StrToPtr("/dev/vda"),
+					Discard: util.BoolToPtr(false),
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		version string
+		paths   []path.ContextPath
+	}{
+		{
+			"3.0.0",
+			[]path.ContextPath{
+				path.New("json", "kernelArguments"),
+				path.New("json", "storage", "files", 0, "contents", "httpHeaders"),
+				path.New("json", "storage", "luks"),
+			},
+		},
+		{
+			"3.2.0",
+			[]path.ContextPath{
+				path.New("json", "kernelArguments"),
+				path.New("json", "storage", "luks", 0, "discard"),
+			},
+		},
+		{
+			"3.3.0",
+			[]path.ContextPath{
+				path.New("json", "storage", "luks", 0, "discard"),
+			},
+		},
+		{
+			"3.4.0",
+			nil,
+		},
+		{
+			"3.5.0-experimental",
+			nil,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("check %d", i), func(t *testing.T) {
+			var expected report.Report
+			for _, p := range test.paths {
+				expected.AddOnError(p, fmt.Errorf("%w %s", common.ErrFieldUnsupportedByIgnition, test.version))
+			}
+			assert.Equal(t, expected, checkIgnitionVersion(cfg, test.version), "bad report")
+		})
+	}
+}
+
+func TestSetIgnitionVersion(t *testing.T) {
+	cfg := types.Config{
+		Ignition: types.Ignition{
+			Version: "3.5.0-experimental",
+		},
+	}
+	// wrapped config, e.g. a MachineConfig
+	type wrapper struct {
+		Spec struct {
+			Config types.Config `json:"config"`
+		} `json:"spec"`
+	}
+	var wrapped wrapper
+	wrapped.Spec.Config = cfg
+
+	out := setIgnitionVersion(cfg, "3.4.0").(types.Config)
+	assert.Equal(t, "3.4.0", out.Ignition.Version, "bad version")
+	assert.Equal(t, "3.5.0-experimental", cfg.Ignition.Version, "input modified")
+	wrappedOut := setIgnitionVersion(wrapped, "3.4.0").(wrapper)
+	assert.Equal(t, "3.4.0", wrappedOut.Spec.Config.Ignition.Version, "bad wrapped version")
+	assert.Equal(t, "3.5.0-experimental", wrapped.Spec.Config.Ignition.Version, "wrapped input modified")
+}
@@ -0,0 +1,205 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	slashpath "path"
+	"strings"
+
+	baseutil "github.com/coreos/butane/base/util"
+	"github.com/coreos/butane/config/common"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	includeKey      = "include"
+	maxIncludeDepth = 16
+)
+
+// expandIncludes merges the Butane configs named by the top-level
+// include key of input, and recursively by their own include keys, into
+// input.  Included paths are relative to the directory of the including
+// config within the files directory; the top-level config is at its
+// root.  Mappings are merged key by key, lists are concatenated with
+// included entries first, and other values in the including config take
+// precedence.  If options.SourcePath is set, it's the start of every
+// include chain, so a config which includes input is reported as a
+// cycle.  If input has no include key, isn't for an experimental spec
+// version, or can't be parsed, it's returned unchanged.  Otherwise, the
+// merged config is re-marshaled, so line and column numbers in reports
+// refer to the merged document rather than to input or to any included
+// file.
+func expandIncludes(input []byte, options common.TranslateOptions) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(input, &doc); err != nil {
+		// let the caller report the error
+		return input, nil
+	}
+	root := documentRoot(&doc)
+	if root == nil || root.Kind != yaml.MappingNode || mappingIndex(root, includeKey) < 0 {
+		return input, nil
+	}
+	variant := mappingScalar(root, "variant")
+	version := mappingScalar(root, "version")
+	if !strings.HasSuffix(version, "-experimental") {
+		// only experimental specs support includes; the unused key
+		// check will report the key
+		return input, nil
+	}
+	var chain []string
+	if options.SourcePath != "" {
+		name, err := baseutil.LocalFSPath(options.SourcePath)
+		if err != nil {
+			return nil, common.ErrInclude{Err: err}
+		}
+		chain = []string{name}
+	}
+	if err := expandMappingIncludes(root, ".", chain, variant, version, options); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(&doc)
+}
+
+// expandMappingIncludes replaces the include key of mapping, a config
+// in directory dir reached via the includes in chain, with the contents
+// of the configs it names.
+func expandMappingIncludes(mapping *yaml.Node, dir string, chain []string, variant, version string, options common.TranslateOptions) error {
+	i := mappingIndex(mapping, includeKey)
+	if i < 0 {
+		return nil
+	}
+	paths, err := includePaths(mapping.Content[i+1])
+	if err != nil {
+		return common.ErrInclude{Chain: chain, Err: err}
+	}
+	mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+
+	merged := &yaml.Node{
+		Kind: yaml.MappingNode,
+		Tag:  "!!map",
+	}
+	for _, p := range paths {
+		name, err := baseutil.LocalFSPath(slashpath.Join(dir, p))
+		childChain := append(append([]string{}, chain...), p)
+		if err != nil {
+			return common.ErrInclude{Chain: childChain, Err: err}
+		}
+		for _, c := range chain {
+			if c == name {
+				return common.ErrInclude{Chain: childChain, Err: common.ErrIncludeCycle}
+			}
+		}
+		if len(childChain) > maxIncludeDepth {
+			return common.ErrInclude{Chain: childChain, Err: common.ErrIncludeDepth}
+		}
+		childChain[len(childChain)-1] = name
+		data, err := baseutil.ReadLocalFSFile(name, options)
+		if err != nil {
+			return common.ErrInclude{Chain: childChain, Err: err}
+		}
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return common.ErrInclude{Chain: childChain, Err: common.ErrUnmarshal{Detail: err.Error()}}
+		}
+		child := documentRoot(&doc)
+		if child == nil {
+			// empty file
+			continue
+		}
+		if child.Kind != yaml.MappingNode {
+			return common.ErrInclude{Chain: childChain, Err: common.ErrIncludeRoot}
+		}
+		for key, want := range map[string]string{"variant": variant, "version": version} {
+			if got := mappingScalar(child, key); got != "" && got != want {
+				return common.ErrInclude{Chain: childChain, Err: common.ErrIncludeVariant}
+			}
+		}
+		if err := expandMappingIncludes(child, slashpath.Dir(name), childChain, variant, version, options); err != nil {
+			return err
+		}
+		mergeNodes(merged, child)
+	}
+	mergeNodes(merged, mapping)
+	*mapping = *merged
+	return nil
+}
+
+// includePaths returns the paths in the value of an include key.
+func includePaths(n *yaml.Node) ([]string, error) {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		return []string{n.Value}, nil
+	case yaml.SequenceNode:
+		var ret []string
+		for _, item := range n.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, common.ErrIncludeType
+			}
+			ret = append(ret, item.Value)
+		}
+		return ret, nil
+	default:
+		return nil, common.ErrIncludeType
+	}
+}
+
+// mergeNodes merges mapping src into mapping dst.  Mappings present in
+// both are merged recursively and sequences are concatenated; otherwise
+// values from src replace those in dst.
+func mergeNodes(dst, src *yaml.Node) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+		j := mappingIndex(dst, key.Value)
+		if j < 0 {
+			dst.Content = append(dst.Content, key, value)
+			continue
+		}
+		existing := dst.Content[j+1]
+		switch {
+		case existing.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			mergeNodes(existing, value)
+		case existing.Kind == yaml.SequenceNode && value.Kind == yaml.SequenceNode:
+			existing.Content = append(existing.Content, value.Content...)
+		default:
+			dst.Content[j+1] = value
+		}
+	}
+}
+
+// documentRoot returns the top-level node of doc, or nil if doc is empty.
+func documentRoot(doc *yaml.Node) *yaml.Node {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil
+	}
+	return doc.Content[0]
+}
+
+// mappingIndex returns the index of key in the Content of mapping, or -1.
+func mappingIndex(mapping *yaml.Node, key string) int {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func mappingScalar(mapping *yaml.Node, key string) string {
+	if i := mappingIndex(mapping, key); i >= 0 && mapping.Content[i+1].Kind == yaml.ScalarNode {
+		return mapping.Content[i+1].Value
+	}
+	return ""
+}
@@ -0,0 +1,192 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"fmt"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+// TestExpandIncludes tests merging included Butane configs.
+func TestExpandIncludes(t *testing.T) {
+	fsys := fstest.MapFS{
+		"users.bu": {
+			Data: []byte("variant: fcos\nversion: 1.6.0-experimental\npasswd:\n  users:\n    - name: core\n"),
+		},
+		"frag/files.bu": {
+			Data: []byte("include: nested.bu\nstorage:\n  files:\n    - path: /etc/b\n"),
+		},
+		"frag/nested.bu": {
+			Data: []byte("storage:\n  files:\n    - path: /etc/a\n  directories:\n    - path: /etc/d\n"),
+		},
+		"empty.bu": {
+			Data: []byte(""),
+		},
+		"self.bu": {
+			Data: []byte("version: 1.6.0-experimental\ninclude: self.bu\n"),
+		},
+		"escape.bu": {
+			Data: []byte("include: ../outside.bu\n"),
+		},
+		"variant.bu": {
+			Data: []byte("variant: flatcar\n"),
+		},
+		"list.bu": {
+			Data: []byte("- a\n"),
+		},
+		"root.bu": {
+			Data: []byte("version: 1.6.0-experimental\ninclude: back.bu\n"),
+		},
+		"back.bu": {
+			Data: []byte("include: root.bu\n"),
+		},
+	}
+	for i := 0; i < maxIncludeDepth; i++ {
+		fsys[fmt.Sprintf("deep%d.bu", i)] = &fstest.MapFile{
+			Data: []byte(fmt.Sprintf("include: deep%d.bu\n", i+1)),
+		}
+	}
+	fsys[fmt.Sprintf("deep%d.bu", maxIncludeDepth)] = &fstest.MapFile{}
+
+	var deepChain []string
+	for i := 0; i <= maxIncludeDepth; i++ {
+		deepChain = append(deepChain, fmt.Sprintf("deep%d.bu", i))
+	}
+
+	tests := []struct {
+		in    string
+		out   string
+		chain []string
+		err   error
+	}{
+		// no include
+		{
+			"variant: fcos\nversion: 1.6.0-experimental\n",
+			"variant: fcos\nversion: 1.6.0-experimental\n",
+			nil,
+			nil,
+		},
+		// stable spec
+		{
+			"variant: fcos\nversion: 1.5.0\ninclude: users.bu\n",
+			"variant: fcos\nversion: 1.5.0\ninclude: users.bu\n",
+			nil,
+			nil,
+		},
+		// include list, nested relative include, list concatenation,
+		// mapping merge, and override
+		{
+			"variant: fcos\nversion: 1.6.0-experimental\ninclude:\n  - users.bu\n  - frag/files.bu\n  - empty.bu\nstorage:\n  files:\n    - path: /etc/c\npasswd:\n  users:\n    - name: other\n",
+			"variant: fcos\nversion: 1.6.0-experimental\npasswd:\n  users:\n    - name: core\n    - name: other\nstorage:\n  directories:\n    - path: /etc/d\n  files:\n    - path: /etc/a\n    - path: /etc/b\n    - path: /etc/c\n",
+			nil,
+			nil,
+		},
+		// include scalar
+		{
+			"variant: fcos\nversion: 1.6.0-experimental\ninclude: frag/nested.bu\n",
+			"variant: fcos\nversion: 1.6.0-experimental\nstorage:\n  files:\n    - path: /etc/a\n  directories:\n    - path: /etc/d\n",
+			nil,
+			nil,
+		},
+		// bad include type
+		{
+			"version: 1.6.0-experimental\ninclude:\n  a: b\n",
+			"",
+			nil,
+			common.ErrIncludeType,
+		},
+		// missing file
+		{
+			"version: 1.6.0-experimental\ninclude: frag/missing.bu\n",
+			"",
+			[]string{"frag/missing.bu"},
+			fs.ErrNotExist,
+		},
+		// cycle
+		{
+			"version: 1.6.0-experimental\ninclude: self.bu\n",
+			"",
+			[]string{"self.bu", "self.bu"},
+			common.ErrIncludeCycle,
+		},
+		// depth limit
+		{
+			"version: 1.6.0-experimental\ninclude: deep0.bu\n",
+			"",
+			deepChain,
+			common.ErrIncludeDepth,
+		},
+		// traversal
+		{
+			"version: 1.6.0-experimental\ninclude: escape.bu\n",
+			"",
+			[]string{"escape.bu", "../outside.bu"},
+			common.ErrFilesDirEscape,
+		},
+		// variant mismatch
+		{
+			"variant: fcos\nversion: 1.6.0-experimental\ninclude: variant.bu\n",
+			"",
+			[]string{"variant.bu"},
+			common.ErrIncludeVariant,
+		},
+		// non-mapping config
+		{
+			"version: 1.6.0-experimental\ninclude: list.bu\n",
+			"",
+			[]string{"list.bu"},
+			common.ErrIncludeRoot,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("include %d", i), func(t *testing.T) {
+			actual, err := expandIncludes([]byte(test.in), common.TranslateOptions{
+				FilesFS: fsys,
+			})
+			if test.err != nil {
+				var includeErr common.ErrInclude
+				if assert.ErrorAs(t, err, &includeErr) {
+					assert.Equal(t, test.chain, includeErr.Chain, "bad include chain")
+				}
+				assert.ErrorIs(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			var expected, got interface{}
+			assert.NoError(t, yaml.Unmarshal([]byte(test.out), &expected))
+			assert.NoError(t, yaml.Unmarshal(actual, &got))
+			assert.Equal(t, expected, got, "bad output")
+		})
+	}
+
+	// including the source config is a cycle
+	_, err := expandIncludes(fsys["root.bu"].Data, common.TranslateOptions{
+		FilesFS:    fsys,
+		SourcePath: "root.bu",
+	})
+	assert.Equal(t, common.ErrInclude{Chain: []string{"root.bu", "back.bu", "root.bu"}, Err: common.ErrIncludeCycle}, err)
+
+	// includes require a files directory
+	_, err = expandIncludes([]byte("variant: fcos\nversion: 1.6.0-experimental\ninclude: users.bu\n"), common.TranslateOptions{})
+	assert.Equal(t, common.ErrInclude{Chain: []string{"users.bu"}, Err: common.ErrNoFilesDir}, err)
+}
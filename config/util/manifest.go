@@ -0,0 +1,334 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	baseutil "github.com/coreos/butane/base/util"
+	"github.com/coreos/butane/translate"
+
+	"github.com/coreos/vcontext/path"
+	"github.com/vincent-petithory/dataurl"
+)
+
+// ManifestEntry describes one node or systemd unit produced by a
+// translation.
+type ManifestEntry struct {
+	Kind   string `json:"kind"`             // file, directory, link, or unit
+	Path   string `json:"path"`             // node path or unit name
+	Mode   string `json:"mode,omitempty"`   // octal; files and directories only
+	User   string `json:"user,omitempty"`   // user name or ID
+	Group  string `json:"group,omitempty"`  // group name or ID
+	Target string `json:"target,omitempty"` // links only
+	Source string `json:"source,omitempty"` // origin of the contents: inline, local, git, exec, oci, tree, remote, or generated
+	From   string `json:"from,omitempty"`   // path of the Butane config entry which produced the node or unit
+	Size   *int   `json:"size,omitempty"`   // uncompressed size in bytes; files whose contents and appends are all embedded
+}
+
+var manifestKinds = []struct {
+	kind    string
+	section string
+	list    string
+}{
+	{"file", "storage", "files"},
+	{"directory", "storage", "directories"},
+	{"link", "storage", "links"},
+	{"unit", "systemd", "units"},
+}
+
+// writeManifest writes a JSON inventory of the nodes and units in the
+// translated config final to w.
+func writeManifest(w io.Writer, final interface{}, ts translate.TranslationSet) error {
+	entries := manifest(final, ts)
+	if entries == nil {
+		entries = []ManifestEntry{}
+	}
+	out, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(out, '\n'))
+	return err
+}
+
+// manifest returns an inventory of the nodes and units in the translated
+// config final, which is an Ignition config or a wrapper containing one.
+func manifest(final interface{}, ts translate.TranslationSet) []ManifestEntry {
+	cfg, cfgPath, ok := findIgnitionConfig(reflect.ValueOf(final), path.New("json"))
+	if !ok {
+		return nil
+	}
+	var ret []ManifestEntry
+	for _, k := range manifestKinds {
+		section, ok := jsonField(cfg, k.section)
+		if !ok {
+			continue
+		}
+		list, ok := jsonField(section, k.list)
+		if !ok {
+			continue
+		}
+		for i := 0; i < list.Len(); i++ {
+			item := list.Index(i)
+			itemPath := cfgPath.Append(k.section, k.list, i)
+			entry := ManifestEntry{
+				Kind: k.kind,
+			}
+			from, fromOk := ts.Lookup(itemPath)
+			if fromOk {
+				entry.From = from.From.String()
+			}
+			if k.kind == "unit" {
+				entry.Path = stringField(item, "name")
+				if _, ok := optionalField(item, "contents"); ok {
+					entry.Source = manifestSource(ts, itemPath.Append("contents"), "")
+				}
+				if entry.Source == "" && fromOk && !hasPrefix(from.From, "systemd", "units") {
+					entry.Source = "generated"
+				}
+			} else {
+				entry.Path = stringField(item, "path")
+				entry.User = ownerField(item, "user")
+				entry.Group = ownerField(item, "group")
+				if mode, ok := optionalField(item, "mode"); ok {
+					entry.Mode = fmt.Sprintf("%04o", mode.Int())
+				}
+				entry.Target = stringField(item, "target")
+				if contents, ok := jsonField(item, "contents"); ok {
+					if source, ok := optionalField(contents, "source"); ok {
+						entry.Source = manifestSource(ts, itemPath.Append("contents", "source"), source.String())
+					}
+				}
+				if k.kind == "file" {
+					if size, ok := manifestSize(item); ok {
+						entry.Size = &size
+					}
+				}
+			}
+			ret = append(ret, entry)
+		}
+	}
+	return ret
+}
+
+// manifestSource returns the origin of the contents at path p, which has
+// the value value if it's a resource source.
+func manifestSource(ts translate.TranslationSet, p path.ContextPath, value string) string {
+	t, ok := ts.Lookup(p)
+	if !ok {
+		return ""
+	}
+	if hasPrefix(t.From, "storage", "trees") {
+		return "tree"
+	}
+	if t.From.Len() == 0 {
+		return ""
+	}
+	switch t.From.Path[t.From.Len()-1] {
+	case "inline", "contents":
+		return "inline"
+	case "local", "contents_local":
+		return "local"
+	case "git":
+		return "git"
+	case "exec":
+		return "exec"
+	case "oci":
+		return "oci"
+	case "source":
+		if strings.HasPrefix(value, "data:") {
+			return "inline"
+		}
+		return "remote"
+	}
+	return "generated"
+}
+
+// manifestSize returns the size of file after Ignition writes its contents
+// and appends, if they're all embedded in data URLs.  Ignition has no
+// field for an expected size, so this is only reported in the manifest.
+func manifestSize(file reflect.Value) (int, bool) {
+	contents, ok := jsonField(file, "contents")
+	if !ok {
+		return 0, false
+	}
+	size, ok := resourceSize(contents)
+	if !ok {
+		return 0, false
+	}
+	if appends, ok := jsonField(file, "append"); ok {
+		for i := 0; i < appends.Len(); i++ {
+			appendSize, ok := resourceSize(appends.Index(i))
+			if !ok {
+				return 0, false
+			}
+			size += appendSize
+		}
+	}
+	return size, true
+}
+
+// resourceSize returns the uncompressed size of the contents of resource
+// res, if its source is a data URL.
+func resourceSize(res reflect.Value) (int, bool) {
+	source := stringField(res, "source")
+	if !strings.HasPrefix(source, "data:") {
+		return 0, false
+	}
+	decoded, err := dataurl.DecodeString(source)
+	if err != nil {
+		return 0, false
+	}
+	data := decoded.Data
+	if stringField(res, "compression") == "gzip" {
+		if data, err = baseutil.GunzipBytes(data); err != nil {
+			return 0, false
+		}
+	}
+	return len(data), true
+}
+
+// hasPrefix returns true if p starts with the elements prefix.
+func hasPrefix(p path.ContextPath, prefix ...interface{}) bool {
+	if p.Len() < len(prefix) {
+		return false
+	}
+	for i, e := range prefix {
+		if p.Path[i] != e {
+			return false
+		}
+	}
+	return true
+}
+
+// findIgnitionConfig searches v for the Ignition config struct, which is
+// the one with an "ignition" field, and returns it with its JSON path.
+func findIgnitionConfig(v reflect.Value, p path.ContextPath) (reflect.Value, path.ContextPath, bool) {
+	v = indirect(v)
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, p, false
+	}
+	if _, ok := jsonField(v, "ignition"); ok {
+		return v, p, true
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := jsonName(t.Field(i))
+		if !ok {
+			continue
+		}
+		if cfg, cfgPath, ok := findIgnitionConfig(v.Field(i), p.Append(name)); ok {
+			return cfg, cfgPath, true
+		}
+	}
+	return reflect.Value{}, p, false
+}
+
+// jsonField returns the dereferenced field of struct v with the JSON
+// name name, looking through embedded structs.
+func jsonField(v reflect.Value, name string) (reflect.Value, bool) {
+	f, ok := rawJSONField(v, name)
+	if !ok {
+		return reflect.Value{}, false
+	}
+	return indirect(f), true
+}
+
+// rawJSONField is like jsonField, but doesn't dereference the field.
+func rawJSONField(v reflect.Value, name string) (reflect.Value, bool) {
+	v = indirect(v)
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Anonymous {
+			if f, ok := rawJSONField(v.Field(i), name); ok {
+				return f, true
+			}
+			continue
+		}
+		if n, ok := jsonName(sf); ok && n == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// optionalField is like jsonField, but fails if the field is a nil
+// pointer.
+func optionalField(v reflect.Value, name string) (reflect.Value, bool) {
+	f, ok := jsonField(v, name)
+	if !ok || !f.IsValid() {
+		return reflect.Value{}, false
+	}
+	return f, true
+}
+
+func stringField(v reflect.Value, name string) string {
+	if f, ok := optionalField(v, name); ok && f.Kind() == reflect.String {
+		return f.String()
+	}
+	return ""
+}
+
+// ownerField returns the name of the user or group in field name of v,
+// or its ID if no name is specified.
+func ownerField(v reflect.Value, name string) string {
+	owner, ok := jsonField(v, name)
+	if !ok {
+		return ""
+	}
+	if n := stringField(owner, "name"); n != "" {
+		return n
+	}
+	if id, ok := optionalField(owner, "id"); ok {
+		return strconv.FormatInt(id.Int(), 10)
+	}
+	return ""
+}
+
+func jsonName(sf reflect.StructField) (string, bool) {
+	if sf.PkgPath != "" {
+		return "", false
+	}
+	name := strings.Split(sf.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return "", false
+	}
+	if name == "" {
+		name = sf.Name
+	}
+	return name, true
+}
+
+// indirect dereferences pointers and interfaces, returning the zero
+// Value if any are nil.
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
@@ -0,0 +1,206 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"fmt"
+	"testing"
+
+	baseutil "github.com/coreos/butane/base/util"
+	"github.com/coreos/butane/translate"
+
+	"github.com/coreos/ignition/v2/config/util"
+	"github.com/coreos/ignition/v2/config/v3_5_experimental/types"
+	"github.com/coreos/vcontext/path"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestManifest checks that node and unit provenance is derived from the
+// TranslationSet.
+func TestManifest(t *testing.T) {
+	gzipped, err := baseutil.MakeGzipDataURL([]byte("gzipped"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := types.Config{
+		Ignition: types.Ignition{
+			Version: "3.5.0-experimental",
+		},
+		Storage: types.Storage{
+			Directories: []types.Directory{
+				{
+					Node: types.Node{
+						Path: "/etc/d",
+						Group: types.NodeGroup{
+							ID: util.IntToPtr(10),
+						},
+					},
+					DirectoryEmbedded1: types.DirectoryEmbedded1{
+						Mode: util.IntToPtr(0750),
+					},
+				},
+			},
+			Files: []types.File{
+				{
+					Node: types.Node{
+						Path: "/etc/local",
+						User: types.NodeUser{
+							Name: util.StrToPtr("core"),
+						},
+					},
+					FileEmbedded1: types.FileEmbedded1{
+						Append: []types.Resource{
+							{
+								Source: util.StrToPtr("data:,bc"),
+							},
+						},
+						Contents: types.Resource{
+							Source: util.StrToPtr("data:,a"),
+						},
+						Mode: util.IntToPtr(0600),
+					},
+				},
+				{
+					Node: types.Node{
+						Path: "/etc/tree",
+					},
+					FileEmbedded1: types.FileEmbedded1{
+						Contents: types.Resource{
+							Compression: util.StrToPtr("gzip"),
+							Source:      util.StrToPtr(gzipped),
+						},
+						Mode: util.IntToPtr(0644),
+					},
+				},
+				{
+					Node: types.Node{
+						Path: "/etc/remote",
+					},
+					FileEmbedded1: types.FileEmbedded1{
+						Contents: types.Resource{
+							Source: util.StrToPtr("https://example.com/c"),
+						},
+					},
+				},
+			},
+			Links: []types.Link{
+				{
+					Node: types.Node{
+						Path: "/etc/l",
+					},
+					LinkEmbedded1: types.LinkEmbedded1{
+						Target: util.StrToPtr("/etc/local"),
+					},
+				},
+			},
+		},
+		Systemd: types.Systemd{
+			Units: []types.Unit{
+				{
+					Name:     "var-data.mount",
+					Contents: util.StrToPtr("[Mount]\n"),
+				},
+				{
+					Name:    "user.service",
+					Enabled: util.BoolToPtr(true),
+				},
+			},
+		},
+	}
+	ts := translate.NewTranslationSet("yaml", "json")
+	ts.AddTranslation(path.New("yaml", "storage", "directories", 0), path.New("json", "storage", "directories", 0))
+	ts.AddTranslation(path.New("yaml", "storage", "files", 0), path.New("json", "storage", "files", 0))
+	ts.AddTranslation(path.New("yaml", "storage", "files", 0, "contents", "local"), path.New("json", "storage", "files", 0, "contents", "source"))
+	ts.AddTranslation(path.New("yaml", "storage", "trees", 0), path.New("json", "storage", "files", 1))
+	ts.AddTranslation(path.New("yaml", "storage", "trees", 0), path.New("json", "storage", "files", 1, "contents", "source"))
+	ts.AddTranslation(path.New("yaml", "storage", "files", 1), path.New("json", "storage", "files", 2))
+	ts.AddTranslation(path.New("yaml", "storage", "files", 1, "contents", "source"), path.New("json", "storage", "files", 2, "contents", "source"))
+	ts.AddTranslation(path.New("yaml", "storage", "links", 0), path.New("json", "storage", "links", 0))
+	ts.AddTranslation(path.New("yaml", "storage", "filesystems", 0), path.New("json", "systemd", "units", 0))
+	ts.AddTranslation(path.New("yaml", "storage", "filesystems", 0), path.New("json", "systemd", "units", 0, "contents"))
+	ts.AddTranslation(path.New("yaml", "systemd", "units", 0), path.New("json", "systemd", "units", 1))
+
+	expected := []ManifestEntry{
+		{
+			Kind:   "file",
+			Path:   "/etc/local",
+			Mode:   "0600",
+			User:   "core",
+			Source: "local",
+			From:   "$.storage.files.0",
+			Size:   util.IntToPtr(3),
+		},
+		{
+			Kind:   "file",
+			Path:   "/etc/tree",
+			Mode:   "0644",
+			Source: "tree",
+			From:   "$.storage.trees.0",
+			Size:   util.IntToPtr(7),
+		},
+		{
+			Kind:   "file",
+			Path:   "/etc/remote",
+			Source: "remote",
+			From:   "$.storage.files.1",
+		},
+		{
+			Kind:  "directory",
+			Path:  "/etc/d",
+			Mode:  "0750",
+			Group: "10",
+			From:  "$.storage.directories.0",
+		},
+		{
+			Kind:   "link",
+			Path:   "/etc/l",
+			Target: "/etc/local",
+			From:   "$.storage.links.0",
+		},
+		{
+			Kind:   "unit",
+			Path:   "var-data.mount",
+			Source: "generated",
+			From:   "$.storage.filesystems.0",
+		},
+		{
+			Kind: "unit",
+			Path: "user.service",
+			From: "$.systemd.units.0",
+		},
+	}
+
+	// wrapped config, e.g. a MachineConfig
+	type wrapper struct {
+		Spec struct {
+			Config types.Config `json:"config"`
+		} `json:"spec"`
+	}
+	var wrapped wrapper
+	wrapped.Spec.Config = cfg
+
+	tests := []struct {
+		in interface{}
+		ts translate.TranslationSet
+	}{
+		{cfg, ts},
+		{&wrapped, ts.PrefixPaths(path.New("yaml"), path.New("json", "spec", "config"))},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("manifest %d", i), func(t *testing.T) {
+			assert.Equal(t, expected, manifest(test.in, test.ts))
+		})
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/coreos/butane/translate"
+
+	"github.com/coreos/go-systemd/v22/unit"
+	"github.com/coreos/vcontext/path"
+)
+
+// writePlan writes a plain-language summary of what the translated config
+// final does to w.
+func writePlan(w io.Writer, final interface{}, ts translate.TranslationSet) error {
+	_, err := io.WriteString(w, strings.Join(plan(final, ts), ""))
+	return err
+}
+
+// plan returns one line for each action taken by the translated config
+// final: formatting filesystems, then creating nodes, then writing and
+// enabling units, in the order the config lists them.  Each line ends
+// with the path of the Butane config entry responsible, if known.  The
+// wording is stable, so plans can be diffed.
+func plan(final interface{}, ts translate.TranslationSet) []string {
+	cfg, cfgPath, ok := findIgnitionConfig(reflect.ValueOf(final), path.New("json"))
+	if !ok {
+		return nil
+	}
+	var ret []string
+	add := func(from, format string, args ...interface{}) {
+		line := fmt.Sprintf(format, args...)
+		if from != "" {
+			line += " [" + from + "]"
+		}
+		ret = append(ret, line+"\n")
+	}
+
+	if storage, ok := jsonField(cfg, "storage"); ok {
+		if filesystems, ok := jsonField(storage, "filesystems"); ok {
+			for i := 0; i < filesystems.Len(); i++ {
+				fs := filesystems.Index(i)
+				format := stringField(fs, "format")
+				if format == "" {
+					continue
+				}
+				from := planFrom(ts, cfgPath.Append("storage", "filesystems", i))
+				verb := "format %s as %s if it isn't already"
+				if wipe, ok := optionalField(fs, "wipeFilesystem"); ok && wipe.Bool() {
+					verb = "wipe and format %s as %s"
+				}
+				add(from, verb+"%s", stringField(fs, "device"), format, planDetails(detail("label %s", stringField(fs, "label"))))
+			}
+		}
+	}
+
+	var units reflect.Value
+	if systemd, ok := jsonField(cfg, "systemd"); ok {
+		units, _ = jsonField(systemd, "units")
+	}
+	unitIndex := 0
+	for _, entry := range manifest(final, ts) {
+		switch entry.Kind {
+		case "file":
+			var contents string
+			switch {
+			case entry.Size != nil && entry.Source != "":
+				contents = fmt.Sprintf("%d bytes of %s contents", *entry.Size, entry.Source)
+			case entry.Source != "":
+				contents = entry.Source + " contents"
+			}
+			add(entry.From, "create file %s%s", entry.Path, planDetails(detail("mode %s", entry.Mode), detail("user %s", entry.User), detail("group %s", entry.Group), contents))
+		case "directory":
+			add(entry.From, "create directory %s%s", entry.Path, planDetails(detail("mode %s", entry.Mode), detail("user %s", entry.User), detail("group %s", entry.Group)))
+		case "link":
+			add(entry.From, "create link %s -> %s%s", entry.Path, entry.Target, planDetails(detail("user %s", entry.User), detail("group %s", entry.Group)))
+		case "unit":
+			item := units.Index(unitIndex)
+			unitIndex++
+			if contents, ok := optionalField(item, "contents"); ok {
+				add(entry.From, "write unit %s%s", entry.Path, planDetails(detail("%s", entry.Source), planUnitSummary(entry.Path, contents.String())))
+			}
+			if dropins, ok := jsonField(item, "dropins"); ok {
+				for j := 0; j < dropins.Len(); j++ {
+					if _, ok := optionalField(dropins.Index(j), "contents"); ok {
+						add(entry.From, "write dropin %s for unit %s", stringField(dropins.Index(j), "name"), entry.Path)
+					}
+				}
+			}
+			if enabled, ok := optionalField(item, "enabled"); ok {
+				if enabled.Bool() {
+					add(entry.From, "enable unit %s", entry.Path)
+				} else {
+					add(entry.From, "disable unit %s", entry.Path)
+				}
+			}
+			if mask, ok := optionalField(item, "mask"); ok && mask.Bool() {
+				add(entry.From, "mask unit %s", entry.Path)
+			}
+		}
+	}
+	return ret
+}
+
+// planFrom returns the path of the Butane config entry which produced
+// the output at p, or "" if unknown.
+func planFrom(ts translate.TranslationSet, p path.ContextPath) string {
+	if t, ok := ts.Lookup(p); ok {
+		return t.From.String()
+	}
+	return ""
+}
+
+// planUnitSummary describes what the mount or swap unit name with
+// contents contents does, or returns "" for other units.
+func planUnitSummary(name, contents string) string {
+	if !strings.HasSuffix(name, ".mount") && !strings.HasSuffix(name, ".swap") {
+		return ""
+	}
+	opts, err := unit.DeserializeOptions(strings.NewReader(contents))
+	if err != nil {
+		return ""
+	}
+	values := make(map[string]string)
+	for _, opt := range opts {
+		if opt.Section == "Mount" || opt.Section == "Swap" {
+			values[opt.Name] = opt.Value
+		}
+	}
+	if strings.HasSuffix(name, ".swap") {
+		return detail("swap on %s", values["What"])
+	}
+	if values["What"] == "" || values["Where"] == "" {
+		return ""
+	}
+	return fmt.Sprintf("mount %s at %s", values["What"], values["Where"])
+}
+
+// detail formats value into format, or returns "" if value is empty.
+func detail(format, value string) string {
+	if value == "" {
+		return ""
+	}
+	return fmt.Sprintf(format, value)
+}
+
+// planDetails returns the non-empty details, parenthesized and preceded
+// by a space, or "" if there are none.
+func planDetails(details ...string) string {
+	var ret []string
+	for _, d := range details {
+		if d != "" {
+			ret = append(ret, d)
+		}
+	}
+	if len(ret) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(ret, ", ") + ")"
+}
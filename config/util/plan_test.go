@@ -0,0 +1,171 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/coreos/butane/translate"
+
+	"github.com/coreos/ignition/v2/config/util"
+	"github.com/coreos/ignition/v2/config/v3_5_experimental/types"
+	"github.com/coreos/vcontext/path"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWritePlan checks the wording and order of the plan, and that
+// provenance is derived from the TranslationSet.
+func TestWritePlan(t *testing.T) {
+	cfg := types.Config{
+		Ignition: types.Ignition{
+			Version: "3.5.0-experimental",
+		},
+		Storage: types.Storage{
+			Directories: []types.Directory{
+				{
+					Node: types.Node{
+						Path: "/etc/d",
+						Group: types.NodeGroup{
+							ID: util.IntToPtr(10),
+						},
+					},
+					DirectoryEmbedded1: types.DirectoryEmbedded1{
+						Mode: util.IntToPtr(0750),
+					},
+				},
+			},
+			Files: []types.File{
+				{
+					Node: types.Node{
+						Path: "/etc/local",
+						User: types.NodeUser{
+							Name: util.StrToPtr("core"),
+						},
+					},
+					FileEmbedded1: types.FileEmbedded1{
+						Contents: types.Resource{
+							Source: util.StrToPtr("data:,abc"),
+						},
+						Mode: util.IntToPtr(0600),
+					},
+				},
+				{
+					Node: types.Node{
+						Path: "/etc/remote",
+					},
+					FileEmbedded1: types.FileEmbedded1{
+						Contents: types.Resource{
+							Source: util.StrToPtr("https://example.com/c"),
+						},
+					},
+				},
+			},
+			Filesystems: []types.Filesystem{
+				{
+					Device:         "/dev/disk/by-label/data",
+					Format:         util.StrToPtr("xfs"),
+					Label:          util.StrToPtr("data"),
+					Path:           util.StrToPtr("/var/data"),
+					WipeFilesystem: util.BoolToPtr(true),
+				},
+				{
+					Device: "/dev/vdb",
+					Format: util.StrToPtr("swap"),
+				},
+				{
+					// only referenced by Ignition
+					Device: "/dev/vdc",
+				},
+			},
+			Links: []types.Link{
+				{
+					Node: types.Node{
+						Path: "/etc/l",
+					},
+					LinkEmbedded1: types.LinkEmbedded1{
+						Target: util.StrToPtr("/etc/local"),
+					},
+				},
+			},
+		},
+		Systemd: types.Systemd{
+			Units: []types.Unit{
+				{
+					Name:     "var-data.mount",
+					Enabled:  util.BoolToPtr(true),
+					Contents: util.StrToPtr("[Mount]\nWhere=/var/data\nWhat=/dev/disk/by-label/data\nType=xfs\n"),
+				},
+				{
+					Name:     "dev-vdb.swap",
+					Enabled:  util.BoolToPtr(true),
+					Contents: util.StrToPtr("[Swap]\nWhat=/dev/vdb\n"),
+				},
+				{
+					Name: "user.service",
+					Dropins: []types.Dropin{
+						{
+							Name:     "10-env.conf",
+							Contents: util.StrToPtr("[Service]\nEnvironment=A=b\n"),
+						},
+					},
+					Enabled: util.BoolToPtr(false),
+				},
+				{
+					Name: "masked.service",
+					Mask: util.BoolToPtr(true),
+				},
+			},
+		},
+	}
+	ts := translate.NewTranslationSet("yaml", "json")
+	ts.AddTranslation(path.New("yaml", "storage", "directories", 0), path.New("json", "storage", "directories", 0))
+	ts.AddTranslation(path.New("yaml", "storage", "files", 0), path.New("json", "storage", "files", 0))
+	ts.AddTranslation(path.New("yaml", "storage", "files", 0, "contents", "local"), path.New("json", "storage", "files", 0, "contents", "source"))
+	ts.AddTranslation(path.New("yaml", "storage", "files", 1), path.New("json", "storage", "files", 1))
+	ts.AddTranslation(path.New("yaml", "storage", "files", 1, "contents", "source"), path.New("json", "storage", "files", 1, "contents", "source"))
+	ts.AddTranslation(path.New("yaml", "storage", "filesystems", 0), path.New("json", "storage", "filesystems", 0))
+	ts.AddTranslation(path.New("yaml", "storage", "filesystems", 1), path.New("json", "storage", "filesystems", 1))
+	ts.AddTranslation(path.New("yaml", "storage", "links", 0), path.New("json", "storage", "links", 0))
+	ts.AddTranslation(path.New("yaml", "storage", "filesystems", 0), path.New("json", "systemd", "units", 0))
+	ts.AddTranslation(path.New("yaml", "storage", "filesystems", 0), path.New("json", "systemd", "units", 0, "contents"))
+	ts.AddTranslation(path.New("yaml", "storage", "filesystems", 1), path.New("json", "systemd", "units", 1))
+	ts.AddTranslation(path.New("yaml", "storage", "filesystems", 1), path.New("json", "systemd", "units", 1, "contents"))
+	ts.AddTranslation(path.New("yaml", "systemd", "units", 0), path.New("json", "systemd", "units", 2))
+	ts.AddTranslation(path.New("yaml", "systemd", "units", 1), path.New("json", "systemd", "units", 3))
+
+	expected := `wipe and format /dev/disk/by-label/data as xfs (label data) [$.storage.filesystems.0]
+format /dev/vdb as swap if it isn't already [$.storage.filesystems.1]
+create file /etc/local (mode 0600, user core, 3 bytes of local contents) [$.storage.files.0]
+create file /etc/remote (remote contents) [$.storage.files.1]
+create directory /etc/d (mode 0750, group 10) [$.storage.directories.0]
+create link /etc/l -> /etc/local [$.storage.links.0]
+write unit var-data.mount (generated, mount /dev/disk/by-label/data at /var/data) [$.storage.filesystems.0]
+enable unit var-data.mount [$.storage.filesystems.0]
+write unit dev-vdb.swap (generated, swap on /dev/vdb) [$.storage.filesystems.1]
+enable unit dev-vdb.swap [$.storage.filesystems.1]
+write dropin 10-env.conf for unit user.service [$.systemd.units.0]
+disable unit user.service [$.systemd.units.0]
+mask unit masked.service [$.systemd.units.1]
+`
+	var out bytes.Buffer
+	assert.NoError(t, writePlan(&out, cfg, ts))
+	assert.Equal(t, expected, out.String())
+
+	// empty config
+	out.Reset()
+	assert.NoError(t, writePlan(&out, types.Config{}, translate.NewTranslationSet("yaml", "json")))
+	assert.Equal(t, "", out.String())
+}
@@ -0,0 +1,163 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	baseutil "github.com/coreos/butane/base/util"
+	"github.com/coreos/butane/translate"
+
+	"github.com/coreos/vcontext/path"
+	"github.com/vincent-petithory/dataurl"
+)
+
+// storeResources returns a copy of the translated config final with the
+// contents of each data URL resource written to dir, named by its
+// SHA-256, and the resource pointed at the stored file beneath baseURL
+// instead.  Identical contents are stored once.  It also writes a
+// manifest mapping each stored hash to the Butane config paths which
+// produced it.
+func storeResources(final interface{}, ts translate.TranslationSet, dir, baseURL string) (interface{}, translate.TranslationSet, error) {
+	v := reflect.New(reflect.TypeOf(final)).Elem()
+	v.Set(reflect.ValueOf(final))
+	cfg, cfgPath, ok := findIgnitionConfig(v, path.New("json"))
+	if !ok {
+		return nil, ts, fmt.Errorf("no Ignition config found in %T", final)
+	}
+
+	type resourceRef struct {
+		res  reflect.Value
+		path path.ContextPath
+	}
+	var refs []resourceRef
+	addResource := func(res reflect.Value, p path.ContextPath) {
+		if res.IsValid() {
+			refs = append(refs, resourceRef{res, p.Copy()})
+		}
+	}
+	// copy each list before modifying its entries, so final isn't
+	// modified
+	addResources := func(list reflect.Value, p path.ContextPath) {
+		if !list.IsValid() {
+			return
+		}
+		copyList(list)
+		for i := 0; i < list.Len(); i++ {
+			addResource(indirect(list.Index(i)), p.Append(i))
+		}
+	}
+	ignition, _ := jsonField(cfg, "ignition")
+	ignitionConfig, _ := jsonField(ignition, "config")
+	merge, _ := jsonField(ignitionConfig, "merge")
+	addResources(merge, cfgPath.Append("ignition", "config", "merge"))
+	replace, _ := jsonField(ignitionConfig, "replace")
+	addResource(replace, cfgPath.Append("ignition", "config", "replace"))
+	security, _ := jsonField(ignition, "security")
+	tls, _ := jsonField(security, "tls")
+	cas, _ := jsonField(tls, "certificateAuthorities")
+	addResources(cas, cfgPath.Append("ignition", "security", "tls", "certificateAuthorities"))
+	storage, _ := jsonField(cfg, "storage")
+	if files, ok := jsonField(storage, "files"); ok {
+		copyList(files)
+		for i := 0; i < files.Len(); i++ {
+			filePath := cfgPath.Append("storage", "files", i)
+			contents, _ := jsonField(files.Index(i), "contents")
+			addResource(contents, filePath.Append("contents"))
+			appends, _ := jsonField(files.Index(i), "append")
+			addResources(appends, filePath.Append("append"))
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, ts, err
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	manifest := make(map[string][]string)
+	for _, ref := range refs {
+		source := stringField(ref.res, "source")
+		if !strings.HasPrefix(source, "data:") {
+			continue
+		}
+		sourcePath := ref.path.Append("source")
+		decoded, err := dataurl.DecodeString(source)
+		if err != nil {
+			return nil, ts, fmt.Errorf("decoding %s: %w", sourcePath, err)
+		}
+		contents := decoded.Data
+		// store uncompressed, so the name matches the verification hash
+		if stringField(ref.res, "compression") == "gzip" {
+			contents, err = baseutil.GunzipBytes(contents)
+			if err != nil {
+				return nil, ts, fmt.Errorf("decompressing %s: %w", sourcePath, err)
+			}
+			setJSONField(ref.res, "compression", "")
+		}
+		digest := sha256.Sum256(contents)
+		hash := hex.EncodeToString(digest[:])
+		if _, ok := manifest[hash]; !ok {
+			if err := os.WriteFile(filepath.Join(dir, hash), contents, 0644); err != nil {
+				return nil, ts, err
+			}
+		}
+		setJSONField(ref.res, "source", baseURL+"/"+hash)
+		// every source has a translation
+		from, _ := ts.Lookup(sourcePath)
+		manifest[hash] = append(manifest[hash], from.From.String())
+		if verification, ok := jsonField(ref.res, "verification"); ok && stringField(verification, "hash") == "" {
+			setJSONField(verification, "hash", "sha256-"+hash)
+			ts.AddTranslation(from.From, ref.path.Append("verification"))
+			ts.AddTranslation(from.From, ref.path.Append("verification", "hash"))
+		}
+	}
+
+	for hash, paths := range manifest {
+		sort.Strings(paths)
+		// trees produce many nodes from one path
+		var unique []string
+		for i, p := range paths {
+			if i == 0 || p != paths[i-1] {
+				unique = append(unique, p)
+			}
+		}
+		manifest[hash] = unique
+	}
+	out, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, ts, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "manifest.json"), append(out, '\n'), 0644); err != nil {
+		return nil, ts, err
+	}
+	return v.Interface(), ts, nil
+}
+
+// copyList replaces the contents of the settable slice list with a copy.
+func copyList(list reflect.Value) {
+	if list.Len() == 0 {
+		return
+	}
+	c := reflect.MakeSlice(list.Type(), list.Len(), list.Len())
+	reflect.Copy(c, list)
+	list.Set(c)
+}
@@ -0,0 +1,161 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	baseutil "github.com/coreos/butane/base/util"
+	"github.com/coreos/butane/translate"
+
+	"github.com/coreos/ignition/v2/config/util"
+	"github.com/coreos/ignition/v2/config/v3_5_experimental/types"
+	"github.com/coreos/vcontext/path"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestStoreResources checks that embedded contents are written to the
+// resource store, deduplicated, and referenced remotely.
+func TestStoreResources(t *testing.T) {
+	zzz := strings.Repeat("z", 150)
+	zzzGzipped, err := baseutil.MakeGzipDataURL([]byte(zzz))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := types.Config{
+		Ignition: types.Ignition{
+			Version: "3.5.0-experimental",
+		},
+		Storage: types.Storage{
+			Files: []types.File{
+				{
+					Node: types.Node{
+						Path: "/a",
+					},
+					FileEmbedded1: types.FileEmbedded1{
+						Contents: types.Resource{
+							Source:      util.StrToPtr("data:,hi"),
+							Compression: util.StrToPtr(""),
+						},
+					},
+				},
+				{
+					Node: types.Node{
+						Path: "/b",
+					},
+					FileEmbedded1: types.FileEmbedded1{
+						Contents: types.Resource{
+							Source:      util.StrToPtr("data:,hi"),
+							Compression: util.StrToPtr(""),
+						},
+						Append: []types.Resource{
+							{
+								Source:      util.StrToPtr(zzzGzipped),
+								Compression: util.StrToPtr("gzip"),
+							},
+							{
+								Source: util.StrToPtr("https://example.com/c"),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	ts := translate.NewTranslationSet("yaml", "json")
+	ts.AddTranslation(path.New("yaml", "storage", "files", 0, "contents", "inline"), path.New("json", "storage", "files", 0, "contents", "source"))
+	ts.AddTranslation(path.New("yaml", "storage", "files", 1, "contents", "inline"), path.New("json", "storage", "files", 1, "contents", "source"))
+	ts.AddTranslation(path.New("yaml", "storage", "files", 1, "append", 0, "inline"), path.New("json", "storage", "files", 1, "append", 0, "source"))
+
+	hiHash := "8f434346648f6b96df89dda901c5176b10a6d83961dd3c1ac88b59b2dc327aa4"
+	zzzHash := "bb1e85ae940de0d9de9bd9e8ca729ba63ac9b6b2b6e675a03e99a77d4d1ea58c"
+	storeDir := filepath.Join(t.TempDir(), "store")
+	actual, ts, err := storeResources(cfg, ts, storeDir, "https://example.com/store/")
+	assert.NoError(t, err, "storing resources")
+	assert.Equal(t, []types.File{
+		{
+			Node: types.Node{
+				Path: "/a",
+			},
+			FileEmbedded1: types.FileEmbedded1{
+				Contents: types.Resource{
+					Source:      util.StrToPtr("https://example.com/store/" + hiHash),
+					Compression: util.StrToPtr(""),
+					Verification: types.Verification{
+						Hash: util.StrToPtr("sha256-" + hiHash),
+					},
+				},
+			},
+		},
+		{
+			Node: types.Node{
+				Path: "/b",
+			},
+			FileEmbedded1: types.FileEmbedded1{
+				Contents: types.Resource{
+					Source:      util.StrToPtr("https://example.com/store/" + hiHash),
+					Compression: util.StrToPtr(""),
+					Verification: types.Verification{
+						Hash: util.StrToPtr("sha256-" + hiHash),
+					},
+				},
+				Append: []types.Resource{
+					{
+						Source:      util.StrToPtr("https://example.com/store/" + zzzHash),
+						Compression: util.StrToPtr(""),
+						Verification: types.Verification{
+							Hash: util.StrToPtr("sha256-" + zzzHash),
+						},
+					},
+					{
+						Source: util.StrToPtr("https://example.com/c"),
+					},
+				},
+			},
+		},
+	}, actual.(types.Config).Storage.Files, "bad files")
+	from, ok := ts.Lookup(path.New("json", "storage", "files", 1, "append", 0, "verification", "hash"))
+	assert.True(t, ok, "missing translation")
+	assert.Equal(t, path.New("yaml", "storage", "files", 1, "append", 0, "inline"), from.From, "bad translation")
+	// input must not be modified
+	assert.Equal(t, "data:,hi", *cfg.Storage.Files[0].Contents.Source, "input modified")
+	assert.Nil(t, cfg.Storage.Files[1].Append[0].Verification.Hash, "input modified")
+
+	entries, err := os.ReadDir(storeDir)
+	assert.NoError(t, err, "reading store")
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{hiHash, zzzHash, "manifest.json"}, names, "bad store contents")
+	contents, err := os.ReadFile(filepath.Join(storeDir, zzzHash))
+	assert.NoError(t, err, "reading stored file")
+	assert.Equal(t, zzz, string(contents), "bad stored contents")
+	manifest, err := os.ReadFile(filepath.Join(storeDir, "manifest.json"))
+	assert.NoError(t, err, "reading manifest")
+	assert.Equal(t, `{
+  "`+hiHash+`": [
+    "$.storage.files.0.contents.inline",
+    "$.storage.files.1.contents.inline"
+  ],
+  "`+zzzHash+`": [
+    "$.storage.files.1.append.0.inline"
+  ]
+}
+`, string(manifest), "bad manifest")
+}
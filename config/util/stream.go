@@ -0,0 +1,279 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/clarketm/json"
+	"github.com/coreos/vcontext/report"
+)
+
+var (
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// TranslateBytesWriter is like TranslateBytes, but writes the marshaled
+// Ignition config to w rather than returning it.  The output is identical
+// to that of TranslateBytes.  The translated config is still built in
+// memory, but its struct fields and list entries (notably storage.files)
+// are marshaled one at a time, so the complete JSON document is never
+// held in memory.  Nothing is written if translation fails, but if
+// marshaling or writing fails partway through, w may have received
+// partial output.
+func TranslateBytesWriter(w io.Writer, input []byte, container interface{}, translateMethod string, options common.TranslateBytesOptions) (report.Report, error) {
+	final, r, err := translateBytes(input, container, translateMethod, options)
+	if err != nil {
+		return r, err
+	}
+	return r, marshalStream(w, final, options.Pretty)
+}
+
+// TranslateBytesYAMLWriter is like TranslateBytesYAML, but writes the
+// YAML document to w rather than returning it.  The output is identical
+// to that of TranslateBytesYAML.  The config is still translated and
+// converted in memory; only the YAML encoding is written to w as it's
+// produced.  Nothing is written if translation fails, but if encoding or
+// writing fails partway through, w may have received partial output.
+func TranslateBytesYAMLWriter(w io.Writer, input []byte, container interface{}, translateMethod string, options common.TranslateBytesOptions) (report.Report, error) {
+	jsonCfg, r, err := TranslateBytes(input, container, translateMethod, options)
+	if err != nil {
+		return r, err
+	}
+	tw := trimNewlineWriter{w: w}
+	return r, encodeYAML(&tw, jsonCfg)
+}
+
+// trimNewlineWriter drops trailing newlines from the output, matching
+// TranslateBytesYAML.  Newlines are held back until more output follows.
+type trimNewlineWriter struct {
+	w       io.Writer
+	pending int
+}
+
+func (t *trimNewlineWriter) Write(p []byte) (int, error) {
+	trimmed := bytes.TrimRight(p, "\n")
+	if len(trimmed) > 0 {
+		if t.pending > 0 {
+			if _, err := t.w.Write(bytes.Repeat([]byte("\n"), t.pending)); err != nil {
+				return 0, err
+			}
+			t.pending = 0
+		}
+		if _, err := t.w.Write(trimmed); err != nil {
+			return 0, err
+		}
+	}
+	t.pending += len(p) - len(trimmed)
+	return len(p), nil
+}
+
+// marshalStream writes the JSON encoding of from to w, producing the same
+// bytes as marshal().
+func marshalStream(w io.Writer, from interface{}, pretty bool) error {
+	s := streamEncoder{
+		w:      bufio.NewWriter(w),
+		pretty: pretty,
+	}
+	s.encode(reflect.ValueOf(from), "")
+	if s.err != nil {
+		return s.err
+	}
+	return s.w.Flush()
+}
+
+type streamEncoder struct {
+	w      *bufio.Writer
+	pretty bool
+	err    error
+}
+
+type streamField struct {
+	name  string
+	value reflect.Value
+}
+
+func (s *streamEncoder) write(str string) {
+	if s.err == nil {
+		_, s.err = s.w.WriteString(str)
+	}
+}
+
+// leaf marshals v in one piece, indenting it to match its position in
+// the document.
+func (s *streamEncoder) leaf(v reflect.Value, indent string) {
+	if s.err != nil {
+		return
+	}
+	var out []byte
+	var iface interface{}
+	if v.IsValid() {
+		iface = v.Interface()
+	}
+	if s.pretty {
+		out, s.err = json.MarshalIndent(iface, indent, "  ")
+	} else {
+		out, s.err = json.Marshal(iface)
+	}
+	if s.err == nil {
+		_, s.err = s.w.Write(out)
+	}
+}
+
+func (s *streamEncoder) newline(indent string) {
+	if s.pretty {
+		s.write("\n" + indent)
+	}
+}
+
+func (s *streamEncoder) encode(v reflect.Value, indent string) {
+	if !v.IsValid() || v.Type().Implements(marshalerType) {
+		s.leaf(v, indent)
+		return
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			s.leaf(v, indent)
+			return
+		}
+		s.encode(v.Elem(), indent)
+	case reflect.Struct:
+		fields, ok := streamFields(v)
+		if !ok {
+			s.leaf(v, indent)
+			return
+		}
+		s.write("{")
+		for i, f := range fields {
+			if i > 0 {
+				s.write(",")
+			}
+			s.newline(indent + "  ")
+			key, err := json.Marshal(f.name)
+			if err != nil {
+				s.err = err
+				return
+			}
+			s.write(string(key) + ":")
+			if s.pretty {
+				s.write(" ")
+			}
+			s.encode(f.value, indent+"  ")
+		}
+		if len(fields) > 0 {
+			s.newline(indent)
+		}
+		s.write("}")
+	case reflect.Slice:
+		if v.Len() == 0 || v.Type().Elem().Kind() == reflect.Uint8 {
+			s.leaf(v, indent)
+			return
+		}
+		s.write("[")
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				s.write(",")
+			}
+			s.newline(indent + "  ")
+			s.encode(v.Index(i), indent+"  ")
+		}
+		s.newline(indent)
+		s.write("]")
+	default:
+		s.leaf(v, indent)
+	}
+}
+
+// streamFields returns the fields of struct v which should be encoded, in
+// order.  It returns false if the struct uses encoding features we don't
+// replicate, in which case the caller should marshal it in one piece.
+func streamFields(v reflect.Value) ([]streamField, bool) {
+	var ret []streamField
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Anonymous {
+			return nil, false
+		}
+		if sf.PkgPath != "" {
+			// unexported
+			continue
+		}
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		name := parts[0]
+		if name == "" {
+			name = sf.Name
+		}
+		omitEmpty := false
+		for _, opt := range parts[1:] {
+			switch opt {
+			case "omitempty":
+				omitEmpty = true
+			default:
+				return nil, false
+			}
+		}
+		if omitEmpty && isEmptyValue(v.Field(i)) {
+			continue
+		}
+		ret = append(ret, streamField{
+			name:  name,
+			value: v.Field(i),
+		})
+	}
+	return ret, true
+}
+
+// isEmptyValue matches the omitempty semantics of clarketm/json, which
+// additionally treats structs with all-empty fields as empty.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	case reflect.Struct:
+		t := v.Type()
+		for i := v.NumField() - 1; i >= 0; i-- {
+			if t.Field(i).PkgPath != "" {
+				continue
+			}
+			if !isEmptyValue(v.Field(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/coreos/ignition/v2/config/util"
+	"github.com/coreos/ignition/v2/config/v3_5_experimental/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMarshalStream checks that the streaming encoder produces the same
+// bytes as the in-memory one.
+func TestMarshalStream(t *testing.T) {
+	manyFiles := make([]types.File, 100)
+	for i := range manyFiles {
+		manyFiles[i] = types.File{
+			Node: types.Node{
+				Path: fmt.Sprintf("/etc/file-%d", i),
+			},
+			FileEmbedded1: types.FileEmbedded1{
+				Contents: types.Resource{
+					Source: util.StrToPtr(fmt.Sprintf("data:,%d", i)),
+				},
+				Mode: util.IntToPtr(0644),
+			},
+		}
+	}
+
+	tests := []struct {
+		in     types.Config
+		golden string
+	}{
+		// empty config
+		{
+			types.Config{},
+			`{"ignition":{"version":""}}`,
+		},
+		// escaping, nested structs, and omitted empty fields
+		{
+			types.Config{
+				Ignition: types.Ignition{
+					Version: "3.5.0-experimental",
+				},
+				Storage: types.Storage{
+					Directories: []types.Directory{
+						{
+							Node: types.Node{
+								Path: "/etc/<dir>",
+							},
+						},
+					},
+					Files: []types.File{
+						{
+							Node: types.Node{
+								Path:      "/etc/a&b",
+								Overwrite: util.BoolToPtr(false),
+							},
+							FileEmbedded1: types.FileEmbedded1{
+								Append: []types.Resource{
+									{
+										Source: util.StrToPtr("data:,x"),
+									},
+								},
+							},
+						},
+					},
+				},
+				Systemd: types.Systemd{
+					Units: []types.Unit{
+						{
+							Name:    "a.service",
+							Enabled: util.BoolToPtr(true),
+							Dropins: []types.Dropin{
+								{
+									Name:     "b.conf",
+									Contents: util.StrToPtr("[Service]\n"),
+								},
+							},
+						},
+					},
+				},
+			},
+			`{"ignition":{"version":"3.5.0-experimental"},"storage":{"directories":[{"path":"/etc/\u003cdir\u003e"}],"files":[{"overwrite":false,"path":"/etc/a\u0026b","append":[{"source":"data:,x"}]}]},"systemd":{"units":[{"dropins":[{"contents":"[Service]\n","name":"b.conf"}],"enabled":true,"name":"a.service"}]}}`,
+		},
+		// many files
+		{
+			types.Config{
+				Ignition: types.Ignition{
+					Version: "3.5.0-experimental",
+				},
+				Storage: types.Storage{
+					Files: manyFiles,
+				},
+			},
+			"",
+		},
+	}
+
+	for i, test := range tests {
+		for _, pretty := range []bool{false, true} {
+			t.Run(fmt.Sprintf("stream %d pretty %v", i, pretty), func(t *testing.T) {
+				expected, err := marshal(test.in, pretty)
+				assert.NoError(t, err, "marshaling")
+				if test.golden != "" && !pretty {
+					assert.Equal(t, test.golden, string(expected), "bad golden output")
+				}
+				var actual bytes.Buffer
+				assert.NoError(t, marshalStream(&actual, test.in, pretty), "streaming")
+				assert.Equal(t, string(expected), actual.String(), "streamed output differs")
+			})
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/coreos/vcontext/path"
+	"github.com/coreos/vcontext/report"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// decimal, or octal with the YAML 1.2 prefix
+	strictIntRe = regexp.MustCompile(`^(-?(0|[1-9][0-9]*)|0o[0-7]+)$`)
+	// YAML 1.1 octal, which YAML 1.2 parsers read as decimal
+	legacyOctalRe = regexp.MustCompile(`^-?0[0-9]+$`)
+)
+
+// checkStrictScalars reports YAML scalars in input which are decoded into
+// boolean or integer fields of container but aren't written in a form
+// that all YAML parsers agree on: booleans other than true and false, and
+// integers other than decimal or 0o-prefixed octal.
+func checkStrictScalars(input []byte, container interface{}) (r report.Report) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(input, &doc); err != nil {
+		// the caller has already unmarshaled input successfully
+		return
+	}
+	if root := documentRoot(&doc); root != nil {
+		checkStrictNode(root, reflect.TypeOf(container), path.New("yaml"), &r)
+	}
+	return
+}
+
+func checkStrictNode(node *yaml.Node, t reflect.Type, p path.ContextPath, r *report.Report) {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case node.Kind == yaml.MappingNode && t.Kind() == reflect.Struct:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if ft, ok := yamlFieldType(t, key); ok {
+				checkStrictNode(node.Content[i+1], ft, p.Append(key), r)
+			}
+		}
+	case node.Kind == yaml.MappingNode && t.Kind() == reflect.Map:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			checkStrictNode(node.Content[i+1], t.Elem(), p.Append(node.Content[i].Value), r)
+		}
+	case node.Kind == yaml.SequenceNode && t.Kind() == reflect.Slice:
+		for i, child := range node.Content {
+			checkStrictNode(child, t.Elem(), p.Append(i), r)
+		}
+	case node.Kind == yaml.ScalarNode && node.Style&(yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0:
+		// quoted and block scalars aren't decoded into booleans or
+		// integers
+		switch t.Kind() {
+		case reflect.Bool:
+			if node.Value != "true" && node.Value != "false" {
+				r.AddOnError(p, common.ErrStrictBool)
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if legacyOctalRe.MatchString(node.Value) {
+				r.AddOnError(p, common.ErrStrictOctal)
+			} else if !strictIntRe.MatchString(node.Value) {
+				r.AddOnError(p, common.ErrStrictInt)
+			}
+		}
+	}
+}
+
+// yamlFieldType returns the type of the field of struct type t with the
+// YAML key name, looking through inlined structs.
+func yamlFieldType(t reflect.Type, name string) (reflect.Type, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := strings.Split(sf.Tag.Get("yaml"), ",")
+		if len(tag) > 1 && tag[1] == "inline" {
+			ft := sf.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if ret, ok := yamlFieldType(ft, name); ok {
+					return ret, true
+				}
+			}
+			continue
+		}
+		if sf.PkgPath != "" || tag[0] == "-" {
+			continue
+		}
+		key := tag[0]
+		if key == "" {
+			key = strings.ToLower(sf.Name)
+		}
+		if key == name {
+			return sf.Type, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,109 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/coreos/vcontext/path"
+	"github.com/coreos/vcontext/report"
+	"github.com/stretchr/testify/assert"
+)
+
+type strictFile struct {
+	Path      string  `yaml:"path"`
+	Mode      *int    `yaml:"mode"`
+	Overwrite *bool   `yaml:"overwrite"`
+	Comment   *string `yaml:"comment"`
+}
+
+type strictBase struct {
+	Files []strictFile `yaml:"files"`
+}
+
+type strictConfig struct {
+	strictBase `yaml:",inline"`
+	Enabled    bool           `yaml:"enabled"`
+	Sizes      map[string]int `yaml:"sizes"`
+}
+
+// TestCheckStrictScalars tests rejecting booleans and integers written in
+// ambiguous forms.
+func TestCheckStrictScalars(t *testing.T) {
+	tests := []struct {
+		in      string
+		out     error
+		errPath path.ContextPath
+	}{
+		// strict forms
+		{
+			"enabled: true\nfiles:\n  - path: /a\n    mode: 0o644\n    overwrite: false\n    comment: yes\n  - mode: 420\nsizes:\n  a: -1\n",
+			nil,
+			path.New("yaml"),
+		},
+		// YAML 1.1 octal
+		{
+			"files:\n  - path: /a\n    mode: 0644\n",
+			common.ErrStrictOctal,
+			path.New("yaml", "files", 0, "mode"),
+		},
+		// YAML 1.1 boolean
+		{
+			"enabled: yes\n",
+			common.ErrStrictBool,
+			path.New("yaml", "enabled"),
+		},
+		{
+			"files:\n  - overwrite: On\n",
+			common.ErrStrictBool,
+			path.New("yaml", "files", 0, "overwrite"),
+		},
+		{
+			"enabled: True\n",
+			common.ErrStrictBool,
+			path.New("yaml", "enabled"),
+		},
+		// hex and underscores
+		{
+			"files:\n  - mode: 0x1a4\n",
+			common.ErrStrictInt,
+			path.New("yaml", "files", 0, "mode"),
+		},
+		{
+			"sizes:\n  a: 1_000\n",
+			common.ErrStrictInt,
+			path.New("yaml", "sizes", "a"),
+		},
+		// aliases
+		{
+			"x: &mode 0755\nfiles:\n  - mode: *mode\n",
+			common.ErrStrictOctal,
+			path.New("yaml", "files", 0, "mode"),
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("strict %d", i), func(t *testing.T) {
+			var cfg strictConfig
+			actual := checkStrictScalars([]byte(test.in), &cfg)
+			expected := report.Report{}
+			expected.AddOnError(test.errPath, test.out)
+			assert.Equal(t, expected, actual, "bad report")
+		})
+	}
+}
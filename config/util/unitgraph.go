@@ -0,0 +1,167 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/butane/config/common"
+	"github.com/coreos/butane/translate"
+
+	"github.com/coreos/go-systemd/v22/unit"
+	"github.com/coreos/vcontext/path"
+)
+
+// UnitGraph describes the ordering and dependency relationships of the
+// mount, swap, and automount units generated by a translation.
+type UnitGraph struct {
+	Units []string        `json:"units"` // generated units, in config order
+	Edges []UnitGraphEdge `json:"edges"`
+}
+
+// UnitGraphEdge is a relationship from one generated unit to another
+// unit, declared in the former.
+type UnitGraphEdge struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Relation string `json:"relation"` // After, Before, Requires, RequiredBy, or WantedBy
+}
+
+var unitGraphRelations = []struct {
+	section string
+	option  string
+}{
+	{"Unit", "After"},
+	{"Unit", "Before"},
+	{"Unit", "Requires"},
+	{"Install", "RequiredBy"},
+	{"Install", "WantedBy"},
+}
+
+var unitGraphSuffixes = []string{".mount", ".swap", ".automount"}
+
+// writeUnitGraph writes the graph of generated units in the translated
+// config final to w, in the specified format: dot (the default) or json.
+func writeUnitGraph(w io.Writer, final interface{}, ts translate.TranslationSet, format string) error {
+	graph, err := unitGraph(final, ts)
+	if err != nil {
+		return err
+	}
+	var out []byte
+	switch format {
+	case "", "dot":
+		out = graph.dot()
+	case "json":
+		if graph.Units == nil {
+			graph.Units = []string{}
+		}
+		if graph.Edges == nil {
+			graph.Edges = []UnitGraphEdge{}
+		}
+		out, err = json.MarshalIndent(graph, "", "  ")
+		if err != nil {
+			return err
+		}
+		out = append(out, '\n')
+	default:
+		return fmt.Errorf("%w: %q", common.ErrUnknownUnitGraphFormat, format)
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// unitGraph collects the relationships declared by the mount, swap, and
+// automount units in the translated config final whose contents were
+// generated by Butane rather than copied from systemd.units.
+func unitGraph(final interface{}, ts translate.TranslationSet) (UnitGraph, error) {
+	var graph UnitGraph
+	cfg, cfgPath, ok := findIgnitionConfig(reflect.ValueOf(final), path.New("json"))
+	if !ok {
+		return graph, nil
+	}
+	systemd, ok := jsonField(cfg, "systemd")
+	if !ok {
+		return graph, nil
+	}
+	units, ok := jsonField(systemd, "units")
+	if !ok {
+		return graph, nil
+	}
+	for i := 0; i < units.Len(); i++ {
+		item := units.Index(i)
+		name := stringField(item, "name")
+		if !hasUnitGraphSuffix(name) {
+			continue
+		}
+		contents, ok := optionalField(item, "contents")
+		if !ok {
+			continue
+		}
+		from, ok := ts.Lookup(cfgPath.Append("systemd", "units", i, "contents"))
+		if !ok || hasPrefix(from.From, "systemd", "units") {
+			continue
+		}
+		opts, err := unit.DeserializeOptions(strings.NewReader(contents.String()))
+		if err != nil {
+			return graph, fmt.Errorf("parsing %s: %w", name, err)
+		}
+		graph.Units = append(graph.Units, name)
+		for _, rel := range unitGraphRelations {
+			for _, opt := range opts {
+				if opt.Section != rel.section || opt.Name != rel.option {
+					continue
+				}
+				for _, to := range strings.Fields(opt.Value) {
+					graph.Edges = append(graph.Edges, UnitGraphEdge{
+						From:     name,
+						To:       to,
+						Relation: rel.option,
+					})
+				}
+			}
+		}
+	}
+	return graph, nil
+}
+
+func hasUnitGraphSuffix(name string) bool {
+	for _, suffix := range unitGraphSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// dot renders the graph in Graphviz format, with generated units drawn
+// as boxes.
+func (g UnitGraph) dot() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("digraph units {\n")
+	for _, name := range g.Units {
+		fmt.Fprintf(&buf, "\t%s [shape=box];\n", strconv.Quote(name))
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&buf, "\t%s -> %s [label=%s];\n", strconv.Quote(e.From), strconv.Quote(e.To), strconv.Quote(e.Relation))
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,123 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/coreos/butane/config/common"
+	"github.com/coreos/butane/translate"
+
+	"github.com/coreos/ignition/v2/config/util"
+	"github.com/coreos/ignition/v2/config/v3_5_experimental/types"
+	"github.com/coreos/vcontext/path"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWriteUnitGraph checks that only generated units are included, and
+// the output in each format.
+func TestWriteUnitGraph(t *testing.T) {
+	cfg := types.Config{
+		Ignition: types.Ignition{
+			Version: "3.5.0-experimental",
+		},
+		Systemd: types.Systemd{
+			Units: []types.Unit{
+				{
+					Name:     "var-data.mount",
+					Enabled:  util.BoolToPtr(true),
+					Contents: util.StrToPtr("[Unit]\nRequires=systemd-fsck@dev-vdb.service\nAfter=systemd-fsck@dev-vdb.service\n\n[Mount]\nWhere=/var/data\n\n[Install]\nWantedBy=local-fs.target remote-fs.target\n"),
+				},
+				{
+					Name:     "user.mount",
+					Contents: util.StrToPtr("[Unit]\nAfter=user.target\n"),
+				},
+				{
+					Name:     "generated.service",
+					Contents: util.StrToPtr("[Unit]\nAfter=generated.target\n"),
+				},
+			},
+		},
+	}
+	ts := translate.NewTranslationSet("yaml", "json")
+	ts.AddTranslation(path.New("yaml", "storage", "filesystems", 0), path.New("json", "systemd", "units", 0, "contents"))
+	ts.AddTranslation(path.New("yaml", "systemd", "units", 0, "contents"), path.New("json", "systemd", "units", 1, "contents"))
+	ts.AddTranslation(path.New("yaml", "systemd", "timers", 0), path.New("json", "systemd", "units", 2, "contents"))
+
+	tests := []struct {
+		format string
+		out    string
+		err    error
+	}{
+		{
+			format: "",
+			out: "digraph units {\n" +
+				"\t\"var-data.mount\" [shape=box];\n" +
+				"\t\"var-data.mount\" -> \"systemd-fsck@dev-vdb.service\" [label=\"After\"];\n" +
+				"\t\"var-data.mount\" -> \"systemd-fsck@dev-vdb.service\" [label=\"Requires\"];\n" +
+				"\t\"var-data.mount\" -> \"local-fs.target\" [label=\"WantedBy\"];\n" +
+				"\t\"var-data.mount\" -> \"remote-fs.target\" [label=\"WantedBy\"];\n" +
+				"}\n",
+		},
+		{
+			format: "json",
+			out: `{
+  "units": [
+    "var-data.mount"
+  ],
+  "edges": [
+    {
+      "from": "var-data.mount",
+      "to": "systemd-fsck@dev-vdb.service",
+      "relation": "After"
+    },
+    {
+      "from": "var-data.mount",
+      "to": "systemd-fsck@dev-vdb.service",
+      "relation": "Requires"
+    },
+    {
+      "from": "var-data.mount",
+      "to": "local-fs.target",
+      "relation": "WantedBy"
+    },
+    {
+      "from": "var-data.mount",
+      "to": "remote-fs.target",
+      "relation": "WantedBy"
+    }
+  ]
+}
+`,
+		},
+		{
+			format: "xml",
+			err:    common.ErrUnknownUnitGraphFormat,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			var out bytes.Buffer
+			err := writeUnitGraph(&out, cfg, ts, test.format)
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err, "bad error")
+				return
+			}
+			assert.NoError(t, err, "writing unit graph")
+			assert.Equal(t, test.out, out.String(), "bad output")
+		})
+	}
+}
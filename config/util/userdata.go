@@ -0,0 +1,49 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/coreos/vcontext/path"
+	"github.com/coreos/vcontext/report"
+)
+
+// userdataLimits are the maximum sizes of user data accepted by each
+// provider.  They apply to the user data before the base64 encoding that
+// AWS and Azure require in API requests; GCP metadata isn't encoded.
+var userdataLimits = map[string]int{
+	"aws":   16384,
+	"azure": 65535,
+	"gcp":   262144,
+}
+
+// CheckUserdataSize warns if data, the final serialized config including
+// any wrapper, is larger than provider allows for user data.  The warning
+// reports the measured size, both raw and base64-encoded, and the limit.
+func CheckUserdataSize(data []byte, provider string) (report.Report, error) {
+	var r report.Report
+	limit, ok := userdataLimits[provider]
+	if !ok {
+		return r, fmt.Errorf("%w: %q", common.ErrUnknownUserdataProvider, provider)
+	}
+	if len(data) > limit {
+		r.AddOnWarn(path.New("json"), fmt.Errorf("%w: config is %d bytes (%d base64-encoded); %s allows %d bytes before encoding", common.ErrUserdataTooLarge, len(data), base64.StdEncoding.EncodedLen(len(data)), provider, limit))
+	}
+	return r, nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCheckUserdataSize checks the provider limits, the limit boundary,
+// and the reported sizes.
+func TestCheckUserdataSize(t *testing.T) {
+	tests := []struct {
+		size     int
+		provider string
+		report   string
+		err      error
+	}{
+		{16384, "aws", "", nil},
+		{16385, "aws", "warning: " + common.ErrUserdataTooLarge.Error() + ": config is 16385 bytes (21848 base64-encoded); aws allows 16384 bytes before encoding\n", nil},
+		{65535, "azure", "", nil},
+		{65536, "azure", "warning: " + common.ErrUserdataTooLarge.Error() + ": config is 65536 bytes (87384 base64-encoded); azure allows 65535 bytes before encoding\n", nil},
+		{262144, "gcp", "", nil},
+		{262145, "gcp", "warning: " + common.ErrUserdataTooLarge.Error() + ": config is 262145 bytes (349528 base64-encoded); gcp allows 262144 bytes before encoding\n", nil},
+		{0, "openstack", "", common.ErrUnknownUserdataProvider},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("check %d", i), func(t *testing.T) {
+			r, err := CheckUserdataSize(bytes.Repeat([]byte("a"), test.size), test.provider)
+			if test.err != nil {
+				assert.True(t, errors.Is(err, test.err), "bad error: %v", err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.report, r.String(), "bad report")
+		})
+	}
+}
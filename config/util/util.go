@@ -17,7 +17,9 @@ package util
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"os"
+	slashpath "path"
 	"reflect"
 	"regexp"
 	"strings"
@@ -50,12 +52,43 @@ type Config interface {
 // using the named translation method on cfg, and returns the marshaled
 // Ignition config.  It returns a report of any errors or warnings in the
 // source and resultant config.  If the report has fatal errors or it
-// encounters other problems translating, an error is returned.
+// encounters other problems translating, an error is returned.  If
+// options.SourceName is set, it prefixes the message of each report entry.
 func Translate(cfg Config, translateMethod string, options common.TranslateOptions) (interface{}, report.Report, error) {
+	final, r, err := translateConfig(cfg, translateMethod, options)
+	return final, annotateReport(r, options.SourceName), err
+}
+
+// translateConfig implements Translate, without annotating the report.
+func translateConfig(cfg Config, translateMethod string, options common.TranslateOptions) (interface{}, report.Report, error) {
 	// Get method, and zero return value for error returns.
 	method := reflect.ValueOf(cfg).MethodByName(translateMethod)
 	zeroValue := reflect.Zero(method.Type().Out(0)).Interface()
 
+	if options.TargetIgnitionVersion != "" {
+		if _, ok := ignitionSpecs[options.TargetIgnitionVersion]; !ok {
+			return zeroValue, report.Report{}, fmt.Errorf("%w: %q", common.ErrUnknownIgnitionVersion, options.TargetIgnitionVersion)
+		}
+	}
+	if options.IgnitionVersionOverride != "" {
+		if _, ok := ignitionSpecs[options.IgnitionVersionOverride]; !ok {
+			return zeroValue, report.Report{}, fmt.Errorf("%w: %q", common.ErrUnknownIgnitionVersion, options.IgnitionVersionOverride)
+		}
+	}
+	if options.ChecksumFile != "" && !slashpath.IsAbs(options.ChecksumFile) {
+		return zeroValue, report.Report{}, common.ErrChecksumFileNotAbsolute
+	}
+	if options.ResourceStoreDir != "" && options.ResourceStoreURL == "" {
+		return zeroValue, report.Report{}, common.ErrResourceStoreURLRequired
+	}
+	if options.EmitUnitGraph != nil && options.UnitGraphFormat != "" && options.UnitGraphFormat != "dot" && options.UnitGraphFormat != "json" {
+		return zeroValue, report.Report{}, fmt.Errorf("%w: %q", common.ErrUnknownUnitGraphFormat, options.UnitGraphFormat)
+	}
+
+	// Bound IO by the timeout, if one hasn't already been started.
+	options, cancel := options.StartTimeout()
+	defer cancel()
+
 	// Validate the input.
 	r := validate.Validate(cfg, "yaml")
 	if r.IsFatal() {
@@ -88,20 +121,211 @@ func Translate(cfg Config, translateMethod string, options common.TranslateOptio
 		}
 	}
 
-	// Check for invalid duplicated keys.
-	dupsReport := validate.ValidateCustom(final, "json", ignvalidate.ValidateDups)
-	r.Merge(TranslateReportPaths(dupsReport, translations))
+	// Check for fields newer than the target Ignition spec.
+	if options.TargetIgnitionVersion != "" {
+		versionReport := checkIgnitionVersion(final, options.TargetIgnitionVersion)
+		r.Merge(TranslateReportPaths(versionReport, translations))
+		if r.IsFatal() {
+			return zeroValue, r, common.ErrInvalidGeneratedConfig
+		}
+	}
+
+	// Label the config with the overridden spec version, warning about
+	// fields that version doesn't support.
+	if options.IgnitionVersionOverride != "" {
+		versionReport := checkIgnitionVersion(final, options.IgnitionVersionOverride)
+		for i := range versionReport.Entries {
+			versionReport.Entries[i].Kind = report.Warn
+		}
+		r.Merge(TranslateReportPaths(versionReport, translations))
+		final = setIgnitionVersion(final, options.IgnitionVersionOverride)
+	}
+
+	// Check for invalid duplicated keys, including with the checksum
+	// file so an existing file at the same path is reported.  The file
+	// itself is added last, so its checksum covers stored resources.
+	dupsConfig, dupsTranslations := final, translations
+	if options.ChecksumFile != "" {
+		var err error
+		dupsConfig, dupsTranslations, err = addChecksumFile(final, translations, options.ChecksumFile)
+		if err != nil {
+			return zeroValue, r, fmt.Errorf("adding checksum file: %w", err)
+		}
+	}
+	dupsReport := validate.ValidateCustom(dupsConfig, "json", ignvalidate.ValidateDups)
+	r.Merge(TranslateReportPaths(dupsReport, dupsTranslations))
 
 	// Validate JSON semantics.
 	jsonReport := validate.Validate(final, "json")
+	if options.IgnitionVersionOverride != "" {
+		jsonReport = dropUnknownVersion(jsonReport)
+	}
 	r.Merge(TranslateReportPaths(jsonReport, translations))
 
 	if r.IsFatal() {
 		return zeroValue, r, common.ErrInvalidGeneratedConfig
 	}
+
+	// Write embedded contents to the resource store, only once the
+	// config is known to be valid.
+	if options.ResourceStoreDir != "" {
+		var err error
+		final, translations, err = storeResources(final, translations, options.ResourceStoreDir, options.ResourceStoreURL)
+		if err != nil {
+			return zeroValue, r, fmt.Errorf("writing resource store: %w", err)
+		}
+	}
+
+	// Record the checksum of the final config.
+	if options.ChecksumFile != "" {
+		var err error
+		final, translations, err = addChecksumFile(final, translations, options.ChecksumFile)
+		if err != nil {
+			return zeroValue, r, fmt.Errorf("adding checksum file: %w", err)
+		}
+	}
+
+	// Write the node inventory.
+	if options.EmitManifest != nil {
+		if err := writeManifest(options.EmitManifest, final, translations); err != nil {
+			return zeroValue, r, fmt.Errorf("writing manifest: %w", err)
+		}
+	}
+
+	// Write the plain-language summary.
+	if options.EmitPlan != nil {
+		if err := writePlan(options.EmitPlan, final, translations); err != nil {
+			return zeroValue, r, fmt.Errorf("writing plan: %w", err)
+		}
+	}
+
+	// Write the graph of generated units.
+	if options.EmitUnitGraph != nil {
+		if err := writeUnitGraph(options.EmitUnitGraph, final, translations, options.UnitGraphFormat); err != nil {
+			return zeroValue, r, fmt.Errorf("writing unit graph: %w", err)
+		}
+	}
 	return final, r, nil
 }
 
+// translateAny translates Butane configs of any variant and version for
+// FragmentTranslator.  It's registered by the config package, which can't
+// be imported here.
+var translateAny func([]byte, common.TranslateBytesOptions) ([]byte, report.Report, error)
+
+// RegisterFragmentTranslator sets the function FragmentTranslator uses to
+// translate Butane configs of any variant and version.  This is only
+// needed by the config package.
+func RegisterFragmentTranslator(translate func([]byte, common.TranslateBytesOptions) ([]byte, report.Report, error)) {
+	translateAny = translate
+}
+
+// FragmentTranslator returns a translator for Butane configs embedded
+// via ignition.config.merge_trees, which always produces a bare Ignition
+// config.  Fragments can't embed further Butane configs, which could
+// otherwise recurse indefinitely.
+func FragmentTranslator(options common.TranslateBytesOptions) common.ButaneTranslator {
+	options.Raw = true
+	options.Pretty = false
+	options.EmitManifest = nil
+	options.EmitPlan = nil
+	options.EmitUnitGraph = nil
+	options.ChecksumFile = ""
+	options.ResourceStoreDir = ""
+	// fragment entries are nested in the report of the parent config
+	options.SourceName = ""
+	// fragments aren't the root of any include chain
+	options.SourcePath = ""
+	// the mtimes unit of a fragment would replace the parent's when
+	// Ignition merges the configs
+	options.PreserveTreeMtimes = false
+	options.TranslateButaneFragment = func([]byte) ([]byte, report.Report, error) {
+		return nil, report.Report{}, common.ErrConfigTreeNested
+	}
+	return func(input []byte) ([]byte, report.Report, error) {
+		if translateAny == nil {
+			return nil, report.Report{}, common.ErrConfigTreeButaneUnsupported
+		}
+		return translateAny(input, options)
+	}
+}
+
+// Check runs the checks performed by Translate on cfg, using the named
+// translation method, without returning a translated config or
+// producing any other output.  Butane configs in
+// ignition.config.merge_trees are checked the same way.  Git, OCI, and
+// exec resources aren't fetched or run; if they're allowed by options,
+// each is reported as unchecked.  If options specify neither FilesDir
+// nor FilesFS, local files aren't read and the errors that would report
+// are omitted.  Checks of the translated config are skipped if there are
+// such resources or files.
+func Check(cfg Config, translateMethod string, options common.TranslateOptions) report.Report {
+	options.EmitManifest = nil
+	options.EmitPlan = nil
+	options.EmitUnitGraph = nil
+	options.ChecksumFile = ""
+	options.ResourceStoreDir = ""
+	unchecked := make(map[string]bool)
+	for _, source := range []struct {
+		allowed *bool
+		err     error
+	}{
+		{&options.AllowGit, common.ErrGitNotAllowed},
+		{&options.AllowOCI, common.ErrOCINotAllowed},
+		{&options.AllowExec, common.ErrExecNotAllowed},
+	} {
+		if *source.allowed {
+			unchecked[source.err.Error()] = true
+			*source.allowed = false
+		}
+	}
+	skipLocal := options.FilesDir == "" && options.FilesFS == nil
+	// start the timeout here, so fragments share it
+	options, cancel := options.StartTimeout()
+	defer cancel()
+	if options.TranslateButaneFragment == nil {
+		options.TranslateButaneFragment = FragmentTranslator(common.TranslateBytesOptions{TranslateOptions: options})
+	}
+	_, r, _ := translateConfig(cfg, translateMethod, options)
+
+	// entries from fragments have the fragment path and context prefixed
+	// to their message
+	matches := func(message string, err string) bool {
+		return message == err || strings.HasSuffix(message, ": "+err)
+	}
+	var ret report.Report
+	for _, entry := range r.Entries {
+		if entry.Kind == report.Error {
+			if skipLocal && matches(entry.Message, common.ErrNoFilesDir.Error()) {
+				continue
+			}
+			for message := range unchecked {
+				if matches(entry.Message, message) {
+					entry.Kind = report.Warn
+					entry.Message = strings.TrimSuffix(entry.Message, message) + common.ErrSourceUnchecked.Error()
+					break
+				}
+			}
+		}
+		ret.Entries = append(ret.Entries, entry)
+	}
+	return annotateReport(ret, options.SourceName)
+}
+
+// annotateReport returns a copy of r with name, if non-empty, prefixed to
+// the message of each entry.
+func annotateReport(r report.Report, name string) report.Report {
+	if name == "" {
+		return r
+	}
+	var ret report.Report
+	for _, entry := range r.Entries {
+		entry.Message = name + ": " + entry.Message
+		ret.Entries = append(ret.Entries, entry)
+	}
+	return ret
+}
+
 // TranslateBytes unmarshals the Butane config specified in input into the
 // struct pointed to by container, translates it to the corresponding Ignition
 // config version using the named translation method, and returns the
@@ -109,8 +333,27 @@ func Translate(cfg Config, translateMethod string, options common.TranslateOptio
 // in the source and resultant config.  If the report has fatal errors or it
 // encounters other problems translating, an error is returned.
 func TranslateBytes(input []byte, container interface{}, translateMethod string, options common.TranslateBytesOptions) ([]byte, report.Report, error) {
+	final, r, err := translateBytes(input, container, translateMethod, options)
+	if err != nil {
+		return nil, r, err
+	}
+
+	// Marshal the JSON.
+	outbytes, err := marshal(final, options.Pretty)
+	return outbytes, r, err
+}
+
+// translateBytes unmarshals and translates the Butane config, returning
+// the translated (but unmarshaled) Ignition config.
+func translateBytes(input []byte, container interface{}, translateMethod string, options common.TranslateBytesOptions) (interface{}, report.Report, error) {
 	cfg := container
 
+	// Merge in included configs.
+	input, err := expandIncludes(input, options.TranslateOptions)
+	if err != nil {
+		return nil, report.Report{}, err
+	}
+
 	// Unmarshal the YAML.
 	contextTree, err := unmarshal(input, cfg)
 	if err != nil {
@@ -122,7 +365,12 @@ func TranslateBytes(input []byte, container interface{}, translateMethod string,
 		return ignvalidate.ValidateUnusedKeys(v, c, contextTree)
 	}
 	r := validate.ValidateCustom(cfg, "yaml", unusedKeyCheck)
+	if options.StrictYAMLScalars {
+		r.Merge(checkStrictScalars(input, cfg))
+	}
 	r.Correlate(contextTree)
+	// the translate method annotates its own report
+	r = annotateReport(r, options.SourceName)
 	if r.IsFatal() {
 		return nil, r, common.ErrInvalidSourceConfig
 	}
@@ -140,10 +388,7 @@ func TranslateBytes(input []byte, container interface{}, translateMethod string,
 	if r.IsFatal() {
 		return nil, r, common.ErrInvalidSourceConfig
 	}
-
-	// Marshal the JSON.
-	outbytes, err := marshal(final, options.Pretty)
-	return outbytes, r, err
+	return final, r, nil
 }
 
 func TranslateBytesYAML(input []byte, container interface{}, translateMethod string, options common.TranslateBytesOptions) ([]byte, report.Report, error) {
@@ -155,23 +400,31 @@ func TranslateBytesYAML(input []byte, container interface{}, translateMethod str
 		return jsonCfg, r, err
 	}
 
+	var yamlCfgBuf bytes.Buffer
+	if err := encodeYAML(&yamlCfgBuf, jsonCfg); err != nil {
+		return []byte{}, r, err
+	}
+	yamlCfg := bytes.Trim(yamlCfgBuf.Bytes(), "\n")
+	return yamlCfg, r, err
+}
+
+// encodeYAML writes the JSON document jsonCfg to w as YAML, preceded by a
+// header comment.
+func encodeYAML(w io.Writer, jsonCfg []byte) error {
 	var ifaceCfg interface{}
 	if err := json.Unmarshal(jsonCfg, &ifaceCfg); err != nil {
-		return []byte{}, r, err
+		return err
 	}
 
-	var yamlCfgBuf bytes.Buffer
-	yamlCfgBuf.WriteString("# Generated by Butane; do not edit\n")
-	encoder := yaml.NewEncoder(&yamlCfgBuf)
+	if _, err := io.WriteString(w, "# Generated by Butane; do not edit\n"); err != nil {
+		return err
+	}
+	encoder := yaml.NewEncoder(w)
 	encoder.SetIndent(2)
 	if err := encoder.Encode(ifaceCfg); err != nil {
-		return []byte{}, r, err
-	}
-	if err := encoder.Close(); err != nil {
-		return []byte{}, r, err
+		return err
 	}
-	yamlCfg := bytes.Trim(yamlCfgBuf.Bytes(), "\n")
-	return yamlCfg, r, err
+	return encoder.Close()
 }
 
 // Report an ErrFieldElided warning for any non-zero top-level fields in the
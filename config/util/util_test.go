@@ -119,3 +119,44 @@ func TestTranslateReportPaths(t *testing.T) {
 	assert.Equal(t, makeReport(false), r, "TranslateReportPaths changed original report")
 	assert.Equal(t, makeReport(true), r2, "TranslateReportPaths returned incorrect report")
 }
+
+// TestFragmentTranslator tests that fragments are translated with the
+// registered translator, without the options which produce output.
+func TestFragmentTranslator(t *testing.T) {
+	options := common.TranslateBytesOptions{
+		TranslateOptions: common.TranslateOptions{
+			ChecksumFile:       "/etc/checksum",
+			ResourceStoreDir:   "store",
+			SourceName:         "host.bu",
+			PreserveTreeMtimes: true,
+			AllowGit:           true,
+		},
+		Pretty: true,
+	}
+	saved := translateAny
+	defer func() {
+		translateAny = saved
+	}()
+
+	translateAny = nil
+	_, _, err := FragmentTranslator(options)([]byte("variant: fcos"))
+	assert.ErrorIs(t, err, common.ErrConfigTreeButaneUnsupported, "bad error")
+
+	var got common.TranslateBytesOptions
+	RegisterFragmentTranslator(func(input []byte, options common.TranslateBytesOptions) ([]byte, report.Report, error) {
+		got = options
+		return []byte("{}"), report.Report{}, nil
+	})
+	out, _, err := FragmentTranslator(options)([]byte("variant: fcos"))
+	assert.NoError(t, err, "translation failed")
+	assert.Equal(t, "{}", string(out), "bad output")
+	assert.True(t, got.Raw, "fragment not raw")
+	assert.False(t, got.Pretty, "fragment pretty")
+	assert.Empty(t, got.ChecksumFile, "checksum file not cleared")
+	assert.Empty(t, got.ResourceStoreDir, "resource store not cleared")
+	assert.Empty(t, got.SourceName, "source name not cleared")
+	assert.False(t, got.PreserveTreeMtimes, "tree mtimes not cleared")
+	assert.True(t, got.AllowGit, "unrelated option cleared")
+	_, _, err = got.TranslateButaneFragment(nil)
+	assert.ErrorIs(t, err, common.ErrConfigTreeNested, "bad nested error")
+}
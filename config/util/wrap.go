@@ -0,0 +1,103 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/coreos/butane/config/common"
+)
+
+const ignitionContentType = "application/vnd.coreos.ignition+json"
+
+// WrapConfig wraps a translated config in a MIME multipart/mixed
+// document with a single part, for platforms which expect user data in
+// that form.  The format is one of:
+//
+//   - "mime": RFC 2046 multipart with CRLF line endings, and a part with
+//     only a Content-Type header.
+//   - "cloud-init": the layout produced by cloud-init's make-mime, with
+//     LF line endings and MIME-Version, Content-Transfer-Encoding, and
+//     Content-Disposition part headers.
+//
+// The part's Content-Type defaults to application/vnd.coreos.ignition+json.
+// headers are added to the part, replacing the value of any default
+// header with the same name, case-insensitively; defaults are written
+// first, followed by the remaining headers sorted by name.  The boundary is derived from the
+// config, so the output is reproducible.
+func WrapConfig(config []byte, format string, headers map[string]string) ([]byte, error) {
+	var newline string
+	partHeaders := [][2]string{{"Content-Type", ignitionContentType}}
+	switch format {
+	case "mime":
+		newline = "\r\n"
+	case "cloud-init":
+		newline = "\n"
+		encoding := "7bit"
+		for _, b := range config {
+			if b >= 0x80 {
+				encoding = "8bit"
+				break
+			}
+		}
+		partHeaders = append(partHeaders,
+			[2]string{"MIME-Version", "1.0"},
+			[2]string{"Content-Transfer-Encoding", encoding},
+			[2]string{"Content-Disposition", `attachment; filename="config.ign"`},
+		)
+	default:
+		return nil, fmt.Errorf("%w: %q", common.ErrUnknownWrapFormat, format)
+	}
+
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+outer:
+	for _, name := range names {
+		value := headers[name]
+		if name == "" || strings.ContainsAny(name, ": \t\r\n") || strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("%w: %q", common.ErrWrapHeaderInvalid, name)
+		}
+		for i := range partHeaders {
+			if strings.EqualFold(partHeaders[i][0], name) {
+				partHeaders[i][1] = value
+				continue outer
+			}
+		}
+		partHeaders = append(partHeaders, [2]string{name, value})
+	}
+
+	sum := sha256.Sum256(config)
+	boundary := "===============" + hex.EncodeToString(sum[:8]) + "=="
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=\"%s\"%s", boundary, newline)
+	fmt.Fprintf(&buf, "MIME-Version: 1.0%s%s", newline, newline)
+	fmt.Fprintf(&buf, "--%s%s", boundary, newline)
+	for _, header := range partHeaders {
+		fmt.Fprintf(&buf, "%s: %s%s", header[0], header[1], newline)
+	}
+	buf.WriteString(newline)
+	buf.Write(config)
+	fmt.Fprintf(&buf, "%s--%s--%s", newline, boundary, newline)
+	return buf.Bytes(), nil
+}
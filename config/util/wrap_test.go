@@ -0,0 +1,110 @@
+// Copyright 2023 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"testing"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWrapConfig checks the wrapped output against golden documents and
+// that it can be parsed as MIME multipart.
+func TestWrapConfig(t *testing.T) {
+	config := []byte(`{"ignition":{"version":"3.5.0-experimental"}}`)
+	tests := []struct {
+		format  string
+		headers map[string]string
+		out     string
+		err     error
+	}{
+		// cloud-init layout
+		{
+			format: "cloud-init",
+			out: "Content-Type: multipart/mixed; boundary=\"===============b5c0becb865b067d==\"\n" +
+				"MIME-Version: 1.0\n" +
+				"\n" +
+				"--===============b5c0becb865b067d==\n" +
+				"Content-Type: application/vnd.coreos.ignition+json\n" +
+				"MIME-Version: 1.0\n" +
+				"Content-Transfer-Encoding: 7bit\n" +
+				"Content-Disposition: attachment; filename=\"config.ign\"\n" +
+				"\n" +
+				`{"ignition":{"version":"3.5.0-experimental"}}` + "\n" +
+				"--===============b5c0becb865b067d==--\n",
+		},
+		// RFC 2046 layout with custom headers
+		{
+			format: "mime",
+			headers: map[string]string{
+				"content-type": "application/json",
+				"X-Platform":   "example",
+			},
+			out: "Content-Type: multipart/mixed; boundary=\"===============b5c0becb865b067d==\"\r\n" +
+				"MIME-Version: 1.0\r\n" +
+				"\r\n" +
+				"--===============b5c0becb865b067d==\r\n" +
+				"Content-Type: application/json\r\n" +
+				"X-Platform: example\r\n" +
+				"\r\n" +
+				`{"ignition":{"version":"3.5.0-experimental"}}` + "\r\n" +
+				"--===============b5c0becb865b067d==--\r\n",
+		},
+		// unknown format
+		{
+			format: "yaml",
+			err:    common.ErrUnknownWrapFormat,
+		},
+		// header injection
+		{
+			format: "mime",
+			headers: map[string]string{
+				"X-Platform": "example\r\nX-Injected: true",
+			},
+			err: common.ErrWrapHeaderInvalid,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("wrap %d", i), func(t *testing.T) {
+			actual, err := WrapConfig(config, test.format, test.headers)
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err, "bad error")
+				return
+			}
+			assert.NoError(t, err, "wrapping failed")
+			assert.Equal(t, test.out, string(actual), "bad output")
+
+			msg, err := mail.ReadMessage(bytes.NewReader(actual))
+			assert.NoError(t, err, "couldn't parse message")
+			mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+			assert.NoError(t, err, "couldn't parse media type")
+			assert.Equal(t, "multipart/mixed", mediaType, "bad media type")
+			part, err := multipart.NewReader(msg.Body, params["boundary"]).NextPart()
+			assert.NoError(t, err, "couldn't read part")
+			body, err := io.ReadAll(part)
+			assert.NoError(t, err, "couldn't read part body")
+			assert.Equal(t, config, body, "bad part body")
+		})
+	}
+}
@@ -15,14 +15,18 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/pflag"
 
 	"github.com/coreos/butane/config"
 	"github.com/coreos/butane/config/common"
+	cutil "github.com/coreos/butane/config/util"
 	"github.com/coreos/butane/internal/version"
 )
 
@@ -35,6 +39,12 @@ func main() {
 	var (
 		input       string
 		output      string
+		manifest    string
+		plan        string
+		unitGraph   string
+		wrap        string
+		wrapHeaders []string
+		userdata    string
 		check       bool
 		strict      bool
 		helpFlag    bool
@@ -54,6 +64,52 @@ func main() {
 	pflag.Lookup("input").Hidden = true
 	pflag.StringVarP(&output, "output", "o", "", "write to output file instead of stdout")
 	pflag.StringVarP(&options.FilesDir, "files-dir", "d", "", "allow embedding local files from this directory")
+	pflag.BoolVar(&options.SkipMissingLocalFiles, "skip-missing-local", false, "warn and skip files, units, trees, and other entries whose local contents don't exist")
+	pflag.StringVar(&options.PathPrefix, "path-prefix", "", "prefix storage and mount paths with this absolute path")
+	pflag.BoolVar(&options.ReadableDataURLs, "readable-data-urls", false, "don't compress or base64-encode short printable file contents")
+	pflag.BoolVar(&options.VerifyCompression, "verify-compression", false, "check that embedded file contents decode to the original")
+	pflag.BoolVar(&options.AllowGit, "allow-git", false, "allow embedding files fetched from git repositories")
+	pflag.BoolVar(&options.AllowOCI, "allow-oci", false, "allow embedding files fetched from OCI registries")
+	pflag.StringArrayVar(&options.AllowedExecCommands, "allow-exec", nil, "allow embedding the output of this command (repeatable)")
+	pflag.StringVar(&wrap, "wrap", "", "wrap the output in a MIME multipart document (mime or cloud-init)")
+	pflag.StringVar(&userdata, "userdata-limit", "", "warn if the output exceeds this provider's user data size limit (aws, azure, or gcp)")
+	pflag.StringArrayVar(&wrapHeaders, "wrap-header", nil, "add this \"Name: value\" header to the wrapped config (repeatable)")
+	pflag.StringVar(&manifest, "manifest", "", "write an inventory of produced nodes and units to this file")
+	pflag.StringVar(&plan, "plan", "", "write a plain-language summary of what the config does to this file")
+	pflag.StringVar(&unitGraph, "unit-graph", "", "write the ordering relationships of generated mount units to this file")
+	pflag.StringVar(&options.UnitGraphFormat, "unit-graph-format", "dot", "format of the unit graph (dot or json)")
+	pflag.StringVar(&options.ResourceStoreDir, "resource-store-dir", "", "write embedded contents to this directory instead, named by hash")
+	pflag.StringVar(&options.ResourceStoreURL, "resource-store-url", "", "base URL from which the target system fetches --resource-store-dir contents")
+	pflag.BoolVar(&options.WarnReadOnlyMounts, "warn-read-only-mounts", false, "warn about files within filesystems mounted read-only")
+	pflag.StringVar(&options.TargetIgnitionVersion, "target-ignition-version", "", "fail if the config uses fields not supported by this Ignition spec version")
+	pflag.StringVar(&options.IgnitionVersionOverride, "ignition-version-override", "", "set the Ignition spec version of the output config to this, warning about unsupported fields")
+	pflag.BoolVar(&options.MergeInlineAppends, "merge-inline-appends", false, "concatenate consecutive inline append entries of a file")
+	pflag.StringVar(&options.ChecksumFile, "checksum-file", "", "add a file at this path containing the checksum of the rest of the config")
+	pflag.BoolVar(&options.WarnUnknownDropinParents, "warn-unknown-dropin-parents", false, "warn about dropins for units not declared in the config or with --known-unit")
+	pflag.StringArrayVar(&options.KnownUnits, "known-unit", nil, "treat this unit as provided by the OS image (repeatable)")
+	pflag.BoolVar(&options.WarnDanglingLinks, "warn-dangling-links", false, "warn about absolute symlink targets not created by the config or within --known-path")
+	pflag.StringArrayVar(&options.KnownPaths, "known-path", nil, "treat this path and its contents as provided by the OS image (repeatable)")
+	pflag.BoolVar(&options.WarnTreeLinkCycles, "warn-tree-link-cycles", false, "warn about symlinks from trees which form a cycle")
+	pflag.BoolVar(&options.WarnDuplicateSSHKeys, "warn-duplicate-ssh-keys", false, "warn about SSH keys listed more than once for a user")
+	pflag.BoolVar(&options.DedupeSSHKeys, "dedupe-ssh-keys", false, "drop SSH keys listed more than once for a user")
+	pflag.BoolVar(&options.WarnSharedCredentials, "warn-shared-credentials", false, "warn about SSH keys and password hashes used by more than one user")
+	pflag.IntVar(&options.InlineWarnBytes, "inline-warn-bytes", 0, "warn about inline contents larger than this many bytes")
+	pflag.BoolVar(&options.WarnRedundantCompression, "warn-redundant-compression", false, "warn about compression fields which Butane would have chosen automatically")
+	pflag.BoolVar(&options.DetectSecrets, "detect-secrets", false, "warn about embedded contents and password hashes which look like plaintext secrets")
+	pflag.StringArrayVar(&options.SecretPatterns, "secret-pattern", nil, "also treat matches for this regular expression as secrets; implies --detect-secrets (repeatable)")
+	pflag.BoolVar(&options.FailOnSecrets, "fail-on-secrets", false, "fail instead of warning about likely secrets; implies --detect-secrets")
+	pflag.BoolVar(&options.CheckUnitContents, "check-unit-contents", false, "warn about unit contents that systemd would ignore")
+	pflag.BoolVar(&options.FailOnUnitContents, "fail-on-unit-contents", false, "fail instead of warning about unit contents; implies --check-unit-contents")
+	pflag.BoolVar(&options.RequireExplicitModes, "require-explicit-modes", false, "fail if a file or directory has no explicitly specified mode")
+	pflag.BoolVar(&options.PreserveTreeMtimes, "preserve-tree-mtimes", false, "add a first-boot service which restores the modification times of files from trees")
+	pflag.DurationVar(&options.Timeout, "timeout", 0, "fail if reading local files, git fetches, or commands take longer than this in total")
+	pflag.StringVar(&options.DevicePathForm, "device-path-form", "", "rewrite devices on declared partitions to this form (partlabel or disk)")
+	pflag.StringVar(&options.MountStyle, "mount-style", "", "mount filesystems with with_mount_unit using this (units or fstab)")
+	pflag.BoolVar(&options.OrderMountsAfterFormat, "order-mounts-after-format", false, "order mounts of filesystems with wipe_filesystem after the systemd unit which formats their device")
+	pflag.IntVar(&options.MaxDataURLSize, "max-data-url-size", 0, "split embedded file contents so no data URL is longer than this many bytes")
+	pflag.BoolVar(&options.StrictYAMLScalars, "strict-yaml-scalars", false, "fail on booleans and integers written in ambiguous YAML forms")
+	pflag.StringVar(&options.GeneratedUnitPrefix, "generated-unit-prefix", "", "prepend this to the names of generated services, .network files, and quadlet files")
+	pflag.BoolVar(&options.SortUnits, "sort-units", false, "sort systemd units by name")
 
 	pflag.Usage = func() {
 		fmt.Fprintf(pflag.CommandLine.Output(), "Usage: %s [options] [input-file]\n", os.Args[0])
@@ -81,6 +137,10 @@ func main() {
 		os.Exit(0)
 	}
 
+	options.AllowExec = len(options.AllowedExecCommands) > 0
+	options.DetectSecrets = options.DetectSecrets || options.FailOnSecrets || len(options.SecretPatterns) > 0
+	options.CheckUnitContents = options.CheckUnitContents || options.FailOnUnitContents
+
 	infile := os.Stdin
 	if input != "" {
 		var err error
@@ -89,6 +149,17 @@ func main() {
 			fail("failed to open %s: %v\n", input, err)
 		}
 		defer infile.Close()
+		if options.FilesDir != "" {
+			// let includes of the input config be reported as cycles
+			dir, dirErr := filepath.Abs(options.FilesDir)
+			path, pathErr := filepath.Abs(input)
+			if dirErr == nil && pathErr == nil {
+				rel, err := filepath.Rel(dir, path)
+				if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+					options.SourcePath = filepath.ToSlash(rel)
+				}
+			}
+		}
 	}
 
 	dataIn, err := io.ReadAll(infile)
@@ -96,6 +167,19 @@ func main() {
 		fail("failed to read %s: %v\n", infile.Name(), err)
 	}
 
+	var manifestOut bytes.Buffer
+	if manifest != "" {
+		options.EmitManifest = &manifestOut
+	}
+	var planOut bytes.Buffer
+	if plan != "" {
+		options.EmitPlan = &planOut
+	}
+	var unitGraphOut bytes.Buffer
+	if unitGraph != "" {
+		options.EmitUnitGraph = &unitGraphOut
+	}
+
 	dataOut, r, err := config.TranslateBytes(dataIn, options)
 	fmt.Fprintf(os.Stderr, "%s", r.String())
 	if err != nil {
@@ -105,6 +189,50 @@ func main() {
 		fail("Config produced warnings and --strict was specified\n")
 	}
 
+	if manifest != "" {
+		if err := os.WriteFile(manifest, manifestOut.Bytes(), 0644); err != nil {
+			fail("Failed to write manifest to %s: %v\n", manifest, err)
+		}
+	}
+	if plan != "" {
+		if err := os.WriteFile(plan, planOut.Bytes(), 0644); err != nil {
+			fail("Failed to write plan to %s: %v\n", plan, err)
+		}
+	}
+	if unitGraph != "" {
+		if err := os.WriteFile(unitGraph, unitGraphOut.Bytes(), 0644); err != nil {
+			fail("Failed to write unit graph to %s: %v\n", unitGraph, err)
+		}
+	}
+
+	if wrap != "" {
+		headers := make(map[string]string)
+		for _, header := range wrapHeaders {
+			name, value, ok := strings.Cut(header, ":")
+			if !ok {
+				fail("Invalid wrapper header %q; must be \"Name: value\"\n", header)
+			}
+			headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+		dataOut, err = cutil.WrapConfig(dataOut, wrap, headers)
+		if err != nil {
+			fail("Error wrapping config: %v\n", err)
+		}
+	} else {
+		dataOut = append(dataOut, '\n')
+	}
+
+	if userdata != "" {
+		r, err := cutil.CheckUserdataSize(dataOut, userdata)
+		if err != nil {
+			fail("Error checking config size: %v\n", err)
+		}
+		fmt.Fprintf(os.Stderr, "%s", r.String())
+		if strict && len(r.Entries) > 0 {
+			fail("Config produced warnings and --strict was specified\n")
+		}
+	}
+
 	if !check {
 		outfile := os.Stdout
 		if output != "" {
@@ -116,7 +244,7 @@ func main() {
 			defer outfile.Close()
 		}
 
-		if _, err := outfile.Write(append(dataOut, '\n')); err != nil {
+		if _, err := outfile.Write(dataOut); err != nil {
 			fail("Failed to write config to %s: %v\n", outfile.Name(), err)
 		}
 	}
@@ -80,6 +80,36 @@ func (ts TranslationSet) String() string {
 	return str
 }
 
+// Mapping is the string representation of a Translation, for use by
+// external tools such as source map generators.
+type Mapping struct {
+	FromPath string `json:"from"`
+	ToPath   string `json:"to"`
+}
+
+// Mappings returns every translation in the set, sorted by destination
+// path.
+func (ts TranslationSet) Mappings() []Mapping {
+	ret := make([]Mapping, 0, len(ts.Set))
+	for _, t := range ts.Set {
+		ret = append(ret, Mapping{
+			FromPath: t.From.String(),
+			ToPath:   t.To.String(),
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ToPath < ret[j].ToPath
+	})
+	return ret
+}
+
+// Lookup returns the translation whose destination is exactly to, if
+// there is one.
+func (ts TranslationSet) Lookup(to path.ContextPath) (Translation, bool) {
+	t, ok := ts.Set[to.String()]
+	return t, ok
+}
+
 // AddTranslation adds a translation to the set
 func (ts TranslationSet) AddTranslation(from, to path.ContextPath) {
 	// create copies of the paths so if someone else changes from.Path the added translation does not change.
@@ -191,6 +221,39 @@ func (ts TranslationSet) Map(mappings TranslationSet) TranslationSet {
 	return ret
 }
 
+// Reorder returns a new TranslationSet with To translation paths adjusted
+// for a reordering of the list at list.  order[i] is the previous index of
+// the element now at index i.  Translations outside the list are copied
+// unmodified.
+func (ts TranslationSet) Reorder(list path.ContextPath, order []int) TranslationSet {
+	newIndex := make(map[int]int, len(order))
+	for i, old := range order {
+		newIndex[old] = i
+	}
+	ret := NewTranslationSet(ts.FromTag, ts.ToTag)
+OUTER:
+	for _, tr := range ts.Set {
+		if tr.To.Tag != list.Tag || len(tr.To.Path) <= len(list.Path) {
+			ret.AddTranslation(tr.From, tr.To)
+			continue
+		}
+		for i, e := range list.Path {
+			if tr.To.Path[i] != e {
+				ret.AddTranslation(tr.From, tr.To)
+				continue OUTER
+			}
+		}
+		to := tr.To.Copy()
+		if old, ok := to.Path[len(list.Path)].(int); ok {
+			if i, ok := newIndex[old]; ok {
+				to.Path[len(list.Path)] = i
+			}
+		}
+		ret.AddTranslation(tr.From, to)
+	}
+	return ret
+}
+
 // DebugVerifyCoverage recursively checks whether every non-zero field in v
 // has a translation.  If translations are missing, it returns a multi-line
 // error listing them.
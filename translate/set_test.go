@@ -98,3 +98,48 @@ func TestTranslationSetAddFromCommonSource(t *testing.T) {
 	actual.AddFromCommonObject(path.New("yaml", "y"), path.New("json", "z", 0), &Main{})
 	assert.Equal(t, expected, actual)
 }
+
+func TestTranslationSetMappings(t *testing.T) {
+	ts := NewTranslationSet("yaml", "json")
+	ts.AddTranslation(path.New("yaml", "storage", "trees", 0), path.New("json", "storage", "files", 1))
+	ts.AddTranslation(path.New("yaml", "storage", "files", 0, "path"), path.New("json", "storage", "files", 0, "path"))
+	ts.AddTranslation(path.New("yaml", "storage"), path.New("json", "storage"))
+
+	expected := []Mapping{
+		{FromPath: "$.storage", ToPath: "$.storage"},
+		{FromPath: "$.storage.files.0.path", ToPath: "$.storage.files.0.path"},
+		{FromPath: "$.storage.trees.0", ToPath: "$.storage.files.1"},
+	}
+	assert.Equal(t, expected, ts.Mappings(), "bad mappings")
+	assert.Equal(t, []Mapping{}, NewTranslationSet("yaml", "json").Mappings(), "bad empty mappings")
+
+	translation, ok := ts.Lookup(path.New("json", "storage", "files", 1))
+	assert.True(t, ok, "lookup failed")
+	assert.Equal(t, path.New("yaml", "storage", "trees", 0), translation.From, "bad lookup result")
+	_, ok = ts.Lookup(path.New("json", "storage", "files", 1, "path"))
+	assert.False(t, ok, "lookup of untranslated path succeeded")
+}
+
+func TestTranslationSetReorder(t *testing.T) {
+	ts := mkTrans(
+		fp(), fp(),
+		fp("a"), fp("A"),
+		fp("a", 0), fp("A", 0),
+		fp("a", 0, "b"), fp("A", 0, "B"),
+		fp("a", 1), fp("A", 1),
+		fp("a", 1, "b"), fp("A", 1, "B", 0),
+		fp("a", 2), fp("A", 2),
+		fp("c", 0), fp("C", 0),
+	)
+	result := ts.Reorder(fp("A"), []int{2, 0, 1})
+	assert.Equal(t, mkTrans(
+		fp(), fp(),
+		fp("a"), fp("A"),
+		fp("a", 0), fp("A", 1),
+		fp("a", 0, "b"), fp("A", 1, "B"),
+		fp("a", 1), fp("A", 2),
+		fp("a", 1, "b"), fp("A", 2, "B", 0),
+		fp("a", 2), fp("A", 0),
+		fp("c", 0), fp("C", 0),
+	), result, "bad reordering")
+}